@@ -0,0 +1,66 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import "time"
+
+// IncrementCounter adds one to the counter of the key and returns the new count,
+// the counter is removed after the window from its first increment.
+func IncrementCounter(key string, window time.Duration) (int64, error) {
+
+	store := GetCache()
+
+	count, err := store.Increment(key, 1)
+
+	if err == ErrCacheMiss {
+		// The memory store only increments existing keys
+		if err := store.Add(key, int64(1), window); err != ErrNotStored {
+			return 1, err
+		}
+
+		return store.Increment(key, 1)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	// Redis creates missing keys without expiration
+	if count == 1 {
+		if _, err := store.Expire(key, window); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// GetCounter returns the count of the key, zero if it's not counted.
+func GetCounter(key string) (int64, error) {
+
+	var count int64
+
+	if err := GetCache().Get(key, &count); err != nil {
+		if err == ErrCacheMiss {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -17,7 +17,6 @@
 package cache
 
 import (
-	"errors"
 	"reflect"
 	"time"
 
@@ -106,5 +105,10 @@ func (c *InMemoryStore) Flush() error {
 }
 
 func (c *InMemoryStore) Expire(key string, expires time.Duration) (bool, error) {
-	return false, errors.New("not implemented")
+	val, found := c.Cache.Get(key)
+	if !found {
+		return false, nil
+	}
+	c.Cache.Set(key, val, expires)
+	return true, nil
 }
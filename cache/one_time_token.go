@@ -0,0 +1,92 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/primasio/wormhole/util"
+)
+
+const oneTimeTokenPrefix = "wormhole_one_time_token_"
+
+var ErrOneTimeTokenNotFound = errors.New("invalid or expired token")
+
+// OneTimeTokenIssue stores the data under a new random token of the purpose,
+// only the hash of the token is kept in the cache.
+func OneTimeTokenIssue(purpose string, data interface{}, ttl time.Duration) (error, string) {
+
+	token, err := util.RandToken(43)
+
+	if err != nil {
+		return err, ""
+	}
+
+	value, err := json.Marshal(data)
+
+	if err != nil {
+		return err, ""
+	}
+
+	if err := GetCache().Set(oneTimeTokenKey(purpose, token), string(value), ttl); err != nil {
+		return err, ""
+	}
+
+	return nil, token
+}
+
+// OneTimeTokenConsume loads the data of the token into data and removes the token,
+// a token can only be consumed once.
+func OneTimeTokenConsume(purpose, token string, data interface{}) error {
+
+	if token == "" {
+		return ErrOneTimeTokenNotFound
+	}
+
+	key := oneTimeTokenKey(purpose, token)
+
+	var value string
+
+	if err := GetCache().Get(key, &value); err != nil {
+		if err == ErrCacheMiss || err == ErrNotStored {
+			return ErrOneTimeTokenNotFound
+		}
+
+		return err
+	}
+
+	// The token is used by whoever deletes it first,
+	// Delete fails with ErrCacheMiss for the others
+	if err := GetCache().Delete(key); err != nil {
+		if err == ErrCacheMiss {
+			return ErrOneTimeTokenNotFound
+		}
+
+		return err
+	}
+
+	return json.Unmarshal([]byte(value), data)
+}
+
+func oneTimeTokenKey(purpose, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return oneTimeTokenPrefix + purpose + "_" + hex.EncodeToString(sum[:])
+}
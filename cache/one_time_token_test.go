@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/cache"
+)
+
+func TestOneTimeTokenConsume(t *testing.T) {
+	err, token := cache.OneTimeTokenIssue("test", map[string]string{"key": "value"}, time.Minute)
+	assert.Equal(t, err, nil)
+
+	// Only one of the concurrent consumers gets the data
+
+	results := make(chan error, 10)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			var data map[string]string
+			results <- cache.OneTimeTokenConsume("test", token, &data)
+		}()
+	}
+
+	wg.Wait()
+	close(results)
+
+	consumed := 0
+
+	for err := range results {
+		if err == nil {
+			consumed++
+		} else {
+			assert.Equal(t, err, cache.ErrOneTimeTokenNotFound)
+		}
+	}
+
+	assert.Equal(t, consumed, 1)
+}
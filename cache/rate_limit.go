@@ -0,0 +1,102 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+type RateLimit struct {
+	Limit     int64
+	Remaining int64
+
+	// Reset is the time left in the current window
+	Reset time.Duration
+
+	// RetryAfter is set when the request is rejected
+	RetryAfter time.Duration
+}
+
+func (limit *RateLimit) Allowed() bool {
+	return limit.RetryAfter == 0
+}
+
+// SlidingWindowLimit counts a request of the key and tells whether it's within
+// the limit of the window. The count of the previous fixed window is weighted
+// by its overlap with the sliding window, so only atomic counters are needed
+// and the limit holds across the memory and redis stores.
+func SlidingWindowLimit(key string, limit int64, window time.Duration, now time.Time) (*RateLimit, error) {
+
+	index := now.UnixNano() / int64(window)
+	elapsed := time.Duration(now.UnixNano() - index*int64(window))
+
+	currentKey := key + "_" + strconv.FormatInt(index, 10)
+
+	previous, err := GetCounter(key + "_" + strconv.FormatInt(index-1, 10))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The counter is kept through the next window where it's the previous one
+	current, err := IncrementCounter(currentKey, window*2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	weight := 1 - float64(elapsed)/float64(window)
+	count := float64(previous)*weight + float64(current)
+
+	result := &RateLimit{Limit: limit, Reset: window - elapsed}
+
+	if count <= float64(limit) {
+		result.Remaining = int64(float64(limit) - count)
+		return result, nil
+	}
+
+	// Rejected requests are not counted so clients get through once they slow down
+	if _, err := GetCache().Decrement(currentKey, 1); err != nil && err != ErrCacheMiss {
+		return nil, err
+	}
+
+	result.RetryAfter = slidingWindowRetryAfter(previous, current-1, limit, window, elapsed)
+
+	return result, nil
+}
+
+// slidingWindowRetryAfter returns the time until one more request is allowed.
+func slidingWindowRetryAfter(previous, current, limit int64, window, elapsed time.Duration) time.Duration {
+
+	allowed := float64(limit - 1)
+	left := window - elapsed
+
+	var wait time.Duration
+
+	if float64(current) <= allowed {
+		// The previous window fades out enough in the current one
+		wait = left - time.Duration((allowed-float64(current))/float64(previous)*float64(window))
+	} else {
+		// The current window becomes the previous one and fades out in the next
+		wait = left + time.Duration((1-allowed/float64(current))*float64(window))
+	}
+
+	// Retry-After is given in seconds
+	return time.Duration(math.Max(float64(wait), float64(time.Second)))
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/cache"
+)
+
+func TestSlidingWindowLimit(t *testing.T) {
+	window := time.Minute
+	start := time.Now().Truncate(window)
+
+	// Two requests at the end of a window
+
+	for i := 0; i < 2; i++ {
+		limit, err := cache.SlidingWindowLimit("test_sliding_window", 2, window, start.Add(window-time.Second))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, limit.Allowed(), true)
+		assert.Equal(t, limit.Remaining, int64(1-i))
+		assert.Equal(t, limit.Reset, time.Second)
+	}
+
+	// The previous window still counts at the start of the next one
+
+	limit, err := cache.SlidingWindowLimit("test_sliding_window", 2, window, start.Add(window+time.Second*15))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, limit.Allowed(), false)
+
+	// 2 * (1 - 30s / 60s) + 1 <= 2 after 15 more seconds
+	assert.Equal(t, limit.RetryAfter, time.Second*15)
+
+	limit, err = cache.SlidingWindowLimit("test_sliding_window", 2, window, start.Add(window+time.Second*30))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, limit.Allowed(), true)
+	assert.Equal(t, limit.Remaining, int64(0))
+}
@@ -73,16 +73,46 @@ func (c *RedisStore) Set(key string, value interface{}, expires time.Duration) e
 
 // Add (see CacheStore interface)
 func (c *RedisStore) Add(key string, value interface{}, expires time.Duration) error {
+	switch expires {
+	case DEFAULT:
+		expires = c.defaultExpiration
+	case FOREVER:
+		expires = time.Duration(0)
+	}
+
+	b, err := utils.Serialize(value)
+	if err != nil {
+		return err
+	}
+
+	// SET NX checks and sets the key in one step, so only one of
+	// the concurrent callers adds the key
+	args := []interface{}{key, b}
+	if expires > 0 {
+		ms := int64(expires / time.Millisecond)
+		if ms < 1 {
+			ms = 1
+		}
+		args = append(args, "PX", ms)
+	}
+	args = append(args, "NX")
+
 	conn := c.pool.Get()
-	if exists(conn, key) {
+	defer conn.Close()
+	ret, err := conn.Do("SET", args...)
+	if err != nil {
+		return err
+	}
+	if ret == nil {
 		return ErrNotStored
 	}
-	return c.invoke(conn.Do, key, value, expires)
+	return nil
 }
 
 // Replace (see CacheStore interface)
 func (c *RedisStore) Replace(key string, value interface{}, expires time.Duration) error {
 	conn := c.pool.Get()
+	defer conn.Close()
 	if !exists(conn, key) {
 		return ErrNotStored
 	}
@@ -118,11 +148,15 @@ func exists(conn redis.Conn, key string) bool {
 func (c *RedisStore) Delete(key string) error {
 	conn := c.pool.Get()
 	defer conn.Close()
-	if !exists(conn, key) {
+	// The count of DEL tells which of the concurrent callers removed the key
+	deleted, err := redis.Int(conn.Do("DEL", key))
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
 		return ErrCacheMiss
 	}
-	_, err := conn.Do("DEL", key)
-	return err
+	return nil
 }
 
 // Increment (see CacheStore interface)
@@ -17,12 +17,63 @@
 package cache
 
 import (
+	"encoding/json"
 	"errors"
-	"github.com/primasio/wormhole/util"
+	"strings"
 	"time"
+
+	"github.com/primasio/wormhole/util"
 )
 
 const sessionPrefix = "wormhole_session_"
+const sessionInfoPrefix = "wormhole_session_info_"
+const sessionSeenPrefix = "wormhole_session_seen_"
+const userSessionsPrefix = "wormhole_user_sessions_"
+const userSessionsLockPrefix = "wormhole_user_sessions_lock_"
+const sessionLockPrefix = "wormhole_session_lock_"
+
+// Session list of a user lives as long as the longest session
+const userSessionsDuration = time.Hour * 24 * 30
+
+// Sessions and session lists of users are changed by one caller at a time,
+// a lock is dropped after the ttl if its holder goes away
+const lockTTL = time.Second * 5
+const lockWait = time.Second * 2
+
+var ErrSessionNotFound = errors.New("session not found")
+var ErrSessionLocked = errors.New("session is locked")
+var ErrUserSessionsLocked = errors.New("session list of the user is locked")
+
+// Session is a login of a user on a device,
+// access tokens are issued to it and rotated with refresh tokens.
+type Session struct {
+	ID         string `json:"id"`
+	UserID     string `json:"user_id"`
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+
+	AccessToken       string   `json:"access_token"`
+	RefreshTokenHash  string   `json:"refresh_token_hash"`
+	UsedRefreshHashes []string `json:"used_refresh_hashes"`
+}
+
+// sessionSeen is the latest activity of a session, it's stored apart
+// from the session so recording it never writes the session back
+type sessionSeen struct {
+	LastSeenAt int64  `json:"t"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"ua"`
+}
+
+// sessionEntry is the value stored for an access token
+type sessionEntry struct {
+	UserID    string `json:"u"`
+	SessionID string `json:"s,omitempty"`
+}
 
 func NewSessionKey() (error, string) {
 
@@ -30,7 +81,12 @@ func NewSessionKey() (error, string) {
 
 	for {
 		counter = counter + 1
-		key := util.RandString(32)
+		key, err := util.RandToken(32)
+
+		if err != nil {
+			return err, ""
+		}
+
 		err, check := SessionGet(key)
 
 		if err != nil {
@@ -50,7 +106,6 @@ func NewSessionKey() (error, string) {
 }
 
 func SessionSet(token, userId string, expires bool) error {
-	store := GetCache()
 
 	duration := time.Hour * 24 * 30
 
@@ -58,24 +113,353 @@ func SessionSet(token, userId string, expires bool) error {
 		duration = time.Hour * 2
 	}
 
-	return store.Set(sessionPrefix+token, userId, duration)
+	return SessionSetWithID(token, userId, "", duration)
+}
+
+// SessionSetWithID stores the access token of the session.
+func SessionSetWithID(token, userId, sessionId string, duration time.Duration) error {
+
+	data, err := json.Marshal(&sessionEntry{UserID: userId, SessionID: sessionId})
+
+	if err != nil {
+		return err
+	}
+
+	return GetCache().Set(sessionPrefix+token, string(data), duration)
 }
 
 func SessionGet(token string) (err error, userId string) {
+	err, userId, _ = SessionGetWithID(token)
+	return
+}
+
+// SessionGetWithID returns the user and the session of the access token.
+func SessionGetWithID(token string) (err error, userId string, sessionId string) {
 
 	store := GetCache()
 
 	if store == nil {
-		return errors.New("cache store is nil"), ""
+		return errors.New("cache store is nil"), "", ""
 	}
 
-	var userIdStore string
+	var value string
 
-	if err := store.Get(sessionPrefix+token, &userIdStore); err != nil {
+	if err := store.Get(sessionPrefix+token, &value); err != nil {
 		if err != ErrCacheMiss && err != ErrNotStored {
-			return err, ""
+			return err, "", ""
 		}
 	}
 
-	return nil, userIdStore
+	if !strings.HasPrefix(value, "{") {
+		// Tokens stored with the user id only
+		return nil, value, ""
+	}
+
+	entry := &sessionEntry{}
+
+	if err := json.Unmarshal([]byte(value), entry); err != nil {
+		return err, "", ""
+	}
+
+	return nil, entry.UserID, entry.SessionID
+}
+
+func SessionDelete(token string) error {
+	return ignoreMiss(GetCache().Delete(sessionPrefix + token))
+}
+
+// SessionInfoSave stores the session until it expires
+// and adds it to the session list of the user.
+func SessionInfoSave(session *Session) error {
+
+	ttl := time.Until(time.Unix(session.ExpiresAt, 0))
+
+	if ttl <= 0 {
+		return ErrSessionNotFound
+	}
+
+	data, err := json.Marshal(session)
+
+	if err != nil {
+		return err
+	}
+
+	if err := GetCache().Set(sessionInfoPrefix+session.ID, string(data), ttl); err != nil {
+		return err
+	}
+
+	return addUserSession(session.UserID, session.ID)
+}
+
+func SessionInfoGet(sessionId string) (error, *Session) {
+
+	var value string
+
+	if err := GetCache().Get(sessionInfoPrefix+sessionId, &value); err != nil {
+		if err == ErrCacheMiss || err == ErrNotStored {
+			return ErrSessionNotFound, nil
+		}
+
+		return err, nil
+	}
+
+	session := &Session{}
+
+	if err := json.Unmarshal([]byte(value), session); err != nil {
+		return err, nil
+	}
+
+	if err := GetCache().Get(sessionSeenPrefix+sessionId, &value); err != nil {
+		if err == ErrCacheMiss || err == ErrNotStored {
+			return nil, session
+		}
+
+		return err, nil
+	}
+
+	seen := &sessionSeen{}
+
+	if err := json.Unmarshal([]byte(value), seen); err != nil {
+		return err, nil
+	}
+
+	if seen.LastSeenAt > session.LastSeenAt {
+		session.LastSeenAt = seen.LastSeenAt
+		session.IP = seen.IP
+		session.UserAgent = seen.UserAgent
+	}
+
+	return nil, session
+}
+
+// SessionInfoUpdate replaces the stored session,
+// a session removed in the meantime is not brought back.
+func SessionInfoUpdate(session *Session) error {
+
+	ttl := time.Until(time.Unix(session.ExpiresAt, 0))
+
+	if ttl <= 0 {
+		return ErrSessionNotFound
+	}
+
+	data, err := json.Marshal(session)
+
+	if err != nil {
+		return err
+	}
+
+	if err := GetCache().Replace(sessionInfoPrefix+session.ID, string(data), ttl); err != nil {
+		if err == ErrNotStored {
+			return ErrSessionNotFound
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// SessionLock takes the lock of the session, so it's rotated
+// or revoked by one caller at a time. It's released by SessionUnlock.
+func SessionLock(sessionId string) error {
+	return acquireLock(sessionLockPrefix+sessionId, ErrSessionLocked)
+}
+
+func SessionUnlock(sessionId string) {
+	GetCache().Delete(sessionLockPrefix + sessionId)
+}
+
+// SessionTouch records the activity of the session until it expires.
+// Only the activity is written, a session rotated or revoked
+// in the meantime is not overwritten or brought back.
+func SessionTouch(session *Session, lastSeenAt int64, ip, userAgent string) error {
+
+	ttl := time.Until(time.Unix(session.ExpiresAt, 0))
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(&sessionSeen{LastSeenAt: lastSeenAt, IP: ip, UserAgent: userAgent})
+
+	if err != nil {
+		return err
+	}
+
+	return GetCache().Set(sessionSeenPrefix+session.ID, string(data), ttl)
+}
+
+// SessionInfoDelete removes the session and its access token.
+func SessionInfoDelete(session *Session) error {
+
+	if session.AccessToken != "" {
+		if err := SessionDelete(session.AccessToken); err != nil {
+			return err
+		}
+	}
+
+	if err := ignoreMiss(GetCache().Delete(sessionInfoPrefix + session.ID)); err != nil {
+		return err
+	}
+
+	if err := ignoreMiss(GetCache().Delete(sessionSeenPrefix + session.ID)); err != nil {
+		return err
+	}
+
+	return removeUserSession(session.UserID, session.ID)
+}
+
+// UserSessionList returns the live sessions of the user,
+// expired sessions are dropped from the list.
+func UserSessionList(userId string) (error, []*Session) {
+
+	err, ids := getUserSessionIDs(userId)
+
+	if err != nil {
+		return err, nil
+	}
+
+	sessions := make([]*Session, 0, len(ids))
+	expired := make([]string, 0)
+
+	for _, id := range ids {
+		err, session := SessionInfoGet(id)
+
+		if err == ErrSessionNotFound {
+			expired = append(expired, id)
+			continue
+		}
+
+		if err != nil {
+			return err, nil
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	if len(expired) != 0 {
+		if err := removeUserSession(userId, expired...); err != nil {
+			return err, nil
+		}
+	}
+
+	return nil, sessions
+}
+
+func getUserSessionIDs(userId string) (error, []string) {
+
+	var value string
+
+	if err := GetCache().Get(userSessionsPrefix+userId, &value); err != nil {
+		if err == ErrCacheMiss || err == ErrNotStored {
+			return nil, []string{}
+		}
+
+		return err, nil
+	}
+
+	ids := make([]string, 0)
+
+	if err := json.Unmarshal([]byte(value), &ids); err != nil {
+		return err, nil
+	}
+
+	return nil, ids
+}
+
+func setUserSessionIDs(userId string, ids []string) error {
+
+	data, err := json.Marshal(ids)
+
+	if err != nil {
+		return err
+	}
+
+	return GetCache().Set(userSessionsPrefix+userId, string(data), userSessionsDuration)
+}
+
+func addUserSession(userId, sessionId string) error {
+	return updateUserSessionIDs(userId, func(ids []string) []string {
+		for _, id := range ids {
+			if id == sessionId {
+				return ids
+			}
+		}
+
+		return append(ids, sessionId)
+	})
+}
+
+func removeUserSession(userId string, sessionIds ...string) error {
+
+	removed := make(map[string]bool)
+
+	for _, sessionId := range sessionIds {
+		removed[sessionId] = true
+	}
+
+	return updateUserSessionIDs(userId, func(ids []string) []string {
+		live := make([]string, 0, len(ids))
+
+		for _, id := range ids {
+			if !removed[id] {
+				live = append(live, id)
+			}
+		}
+
+		return live
+	})
+}
+
+// updateUserSessionIDs changes the session list of the user under its lock,
+// so concurrent logins and revocations don't drop each other's changes.
+func updateUserSessionIDs(userId string, update func(ids []string) []string) error {
+
+	lockKey := userSessionsLockPrefix + userId
+
+	if err := acquireLock(lockKey, ErrUserSessionsLocked); err != nil {
+		return err
+	}
+
+	defer GetCache().Delete(lockKey)
+
+	err, ids := getUserSessionIDs(userId)
+
+	if err != nil {
+		return err
+	}
+
+	return setUserSessionIDs(userId, update(ids))
+}
+
+// acquireLock adds the lock key, waiting while another caller holds it.
+func acquireLock(lockKey string, lockedErr error) error {
+
+	deadline := time.Now().Add(lockWait)
+
+	for {
+		err := GetCache().Add(lockKey, 1, lockTTL)
+
+		if err == nil {
+			return nil
+		}
+
+		if err != ErrNotStored {
+			return err
+		}
+
+		if time.Now().After(deadline) {
+			return lockedErr
+		}
+
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func ignoreMiss(err error) error {
+	if err == ErrCacheMiss {
+		return nil
+	}
+
+	return err
 }
@@ -16,8 +16,85 @@
 
 package cache_test
 
-import "testing"
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/cache"
+)
 
 func TestSession(t *testing.T) {
 
 }
+
+func TestSessionTouch(t *testing.T) {
+	now := time.Now()
+
+	session := &cache.Session{
+		ID:               "test_touch_session",
+		UserID:           "1",
+		IP:               "10.0.0.1",
+		LastSeenAt:       now.Unix(),
+		ExpiresAt:        now.Add(time.Hour).Unix(),
+		RefreshTokenHash: "first",
+	}
+
+	assert.Equal(t, cache.SessionInfoSave(session), nil)
+
+	// The activity is read with the session, which is rotated independently
+
+	touched := *session
+	assert.Equal(t, cache.SessionTouch(&touched, now.Unix()+60, "10.0.0.2", "agent"), nil)
+
+	session.RefreshTokenHash = "second"
+	assert.Equal(t, cache.SessionInfoSave(session), nil)
+
+	err, saved := cache.SessionInfoGet(session.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, saved.RefreshTokenHash, "second")
+	assert.Equal(t, saved.LastSeenAt, now.Unix()+60)
+	assert.Equal(t, saved.IP, "10.0.0.2")
+	assert.Equal(t, saved.UserAgent, "agent")
+
+	// Touching a revoked session doesn't bring it back
+
+	assert.Equal(t, cache.SessionInfoDelete(session), nil)
+	assert.Equal(t, cache.SessionTouch(&touched, now.Unix()+120, "10.0.0.3", "agent"), nil)
+
+	err, _ = cache.SessionInfoGet(session.ID)
+	assert.Equal(t, err, cache.ErrSessionNotFound)
+}
+
+func TestUserSessionList(t *testing.T) {
+	now := time.Now()
+
+	// Sessions saved at the same time are all listed
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			session := &cache.Session{
+				ID:         "test_list_session_" + strconv.Itoa(i),
+				UserID:     "test_list_user",
+				LastSeenAt: now.Unix(),
+				ExpiresAt:  now.Add(time.Hour).Unix(),
+			}
+
+			assert.Equal(t, cache.SessionInfoSave(session), nil)
+		}(i)
+	}
+
+	wg.Wait()
+
+	err, sessions := cache.UserSessionList("test_list_user")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(sessions), 20)
+}
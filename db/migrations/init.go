@@ -55,6 +55,19 @@ func getMigrations() []*gormigrate.Migration {
 	migrations = append(migrations, Migration20180911()...)
 	migrations = append(migrations, Migration20181109()...)
 	migrations = append(migrations, Migration20181113()...)
+	migrations = append(migrations, Migration20261018()...)
+	migrations = append(migrations, Migration20261019()...)
+	migrations = append(migrations, Migration20261020()...)
+	migrations = append(migrations, Migration20261021()...)
+	migrations = append(migrations, Migration20261022()...)
+	migrations = append(migrations, Migration20261023()...)
+	migrations = append(migrations, Migration20261024()...)
+	migrations = append(migrations, Migration20261025()...)
+	migrations = append(migrations, Migration20261026()...)
+	migrations = append(migrations, Migration20261027()...)
+	migrations = append(migrations, Migration20261028()...)
+	migrations = append(migrations, Migration20261029()...)
+	migrations = append(migrations, Migration20261030()...)
 
 	return migrations
 }
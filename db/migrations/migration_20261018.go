@@ -0,0 +1,76 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261018() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610181020",
+			Migrate: func(tx *gorm.DB) error {
+
+				type BaseModel struct {
+					ID        uint `gorm:"primary_key" json:"-"`
+					CreatedAt uint `json:"created_at"`
+					UpdatedAt uint `json:"updated_at"`
+				}
+
+				type URLContentComment struct {
+					BaseModel
+
+					UniqueID     string `gorm:"type:varchar(128);unique_index" json:"id"`
+					UserID       uint   `json:"-"`
+					URLContentId uint   `json:"-"`
+					Content      string `gorm:"type:longtext" json:"content"`
+
+					ParentID       uint   `gorm:"index" json:"-"`
+					ParentUniqueID string `gorm:"type:varchar(128)" json:"parent_id"`
+					RootID         uint   `gorm:"index" json:"-"`
+					Depth          uint   `gorm:"default:0" json:"depth"`
+
+					ReplyCount       uint `json:"reply_count" gorm:"type:INT(11);default:0"`
+					ThreadReplyCount uint `json:"thread_reply_count" gorm:"type:INT(11);default:0"`
+
+					CommentUpVotes   uint `json:"comment_up_votes" gorm:"default:0"`
+					CommentDownVotes uint `json:"comment_down_votes" gorm:"default:0"`
+					IsDeleted        bool `gorm:"default:false" json:"is_deleted"`
+				}
+
+				if err := tx.AutoMigrate(&URLContentComment{}).Error; err != nil {
+					return err
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				columns := []string{"parent_id", "parent_unique_id", "root_id", "depth", "reply_count", "thread_reply_count"}
+
+				for _, column := range columns {
+					if err := tx.Table("url_content_comments").DropColumn(column).Error; err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+		},
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261019() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610191415",
+			Migrate: func(tx *gorm.DB) error {
+
+				type BaseModel struct {
+					ID        uint `gorm:"primary_key" json:"-"`
+					CreatedAt uint `json:"created_at"`
+					UpdatedAt uint `json:"updated_at"`
+				}
+
+				type URLContentComment struct {
+					BaseModel
+
+					IsEdited bool `gorm:"default:false" json:"is_edited"`
+					EditedAt uint `json:"edited_at"`
+				}
+
+				type URLContentCommentRevision struct {
+					BaseModel
+
+					URLContentCommentID uint   `gorm:"index" json:"-"`
+					Content             string `gorm:"type:longtext" json:"content"`
+
+					CommentUpVotes   uint `json:"comment_up_votes" gorm:"type:INT(11);default:0"`
+					CommentDownVotes uint `json:"comment_down_votes" gorm:"type:INT(11);default:0"`
+				}
+
+				if err := tx.AutoMigrate(&URLContentComment{}).Error; err != nil {
+					return err
+				}
+
+				if err := tx.AutoMigrate(&URLContentCommentRevision{}).Error; err != nil {
+					return err
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				if err := tx.DropTable("url_content_comment_revisions").Error; err != nil {
+					return err
+				}
+				return nil
+			},
+		},
+	}
+}
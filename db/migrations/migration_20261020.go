@@ -0,0 +1,64 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261020() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610201130",
+			Migrate: func(tx *gorm.DB) error {
+
+				type BaseModel struct {
+					ID        uint `gorm:"primary_key" json:"-"`
+					CreatedAt uint `json:"created_at"`
+					UpdatedAt uint `json:"updated_at"`
+				}
+
+				type User struct {
+					BaseModel
+					Role string `json:"-" gorm:"type:varchar(32);default:'user'"`
+				}
+
+				type URLContentComment struct {
+					BaseModel
+
+					RemovedAt    uint   `json:"-"`
+					RemovedByID  uint   `json:"-"`
+					RemoveReason string `gorm:"type:text" json:"-"`
+				}
+
+				if err := tx.AutoMigrate(&User{}).Error; err != nil {
+					return err
+				}
+
+				if err := tx.AutoMigrate(&URLContentComment{}).Error; err != nil {
+					return err
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return nil
+			},
+		},
+	}
+}
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/util"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261021() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610211400",
+			Migrate: func(tx *gorm.DB) error {
+
+				type BaseModel struct {
+					ID        uint `gorm:"primary_key" json:"-"`
+					CreatedAt uint `json:"created_at"`
+					UpdatedAt uint `json:"updated_at"`
+				}
+
+				type URLContentComment struct {
+					BaseModel
+
+					CommentUpVotes   uint `json:"comment_up_votes"`
+					CommentDownVotes uint `json:"comment_down_votes"`
+
+					Score              int64   `json:"score" gorm:"type:INT(11);default:0;index"`
+					BestScore          float64 `json:"-" gorm:"default:0;index"`
+					HotScore           float64 `json:"-" gorm:"default:0;index"`
+					ControversialScore float64 `json:"-" gorm:"default:0;index"`
+				}
+
+				if err := tx.AutoMigrate(&URLContentComment{}).Error; err != nil {
+					return err
+				}
+
+				// Calculate the scores of existing comments
+
+				var comments []URLContentComment
+
+				if err := tx.Find(&comments).Error; err != nil {
+					return err
+				}
+
+				for _, comment := range comments {
+
+					ups, downs := comment.CommentUpVotes, comment.CommentDownVotes
+
+					err := tx.Model(&URLContentComment{}).Where("id = ?", comment.ID).UpdateColumns(map[string]interface{}{
+						"score":               util.NetScore(ups, downs),
+						"best_score":          util.WilsonScore(ups, downs),
+						"hot_score":           util.HotScore(ups, downs, comment.CreatedAt),
+						"controversial_score": util.ControversialScore(ups, downs),
+					}).Error
+
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return nil
+			},
+		},
+	}
+}
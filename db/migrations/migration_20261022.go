@@ -0,0 +1,554 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/util"
+	"golang.org/x/net/idna"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261022() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610221000",
+			Migrate: func(tx *gorm.DB) error {
+
+				// Rebuild the hash keys with the canonical urls and domains,
+				// rows sharing the same key are merged into the oldest one.
+
+				if err := mergeURLContents(tx); err != nil {
+					return err
+				}
+
+				if err := mergeDomains(tx); err != nil {
+					return err
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return nil
+			},
+		},
+	}
+}
+
+func mergeURLContents(tx *gorm.DB) error {
+
+	type URLContent struct {
+		ID           uint `gorm:"primary_key"`
+		URL          string
+		HashKey      string
+		TotalComment uint
+	}
+
+	var contents []URLContent
+
+	if err := tx.Table("url_contents").Order("id ASC").Find(&contents).Error; err != nil {
+		return err
+	}
+
+	survivors := make(map[string]*URLContent)
+	order := make([]string, 0)
+
+	for i := range contents {
+
+		content := &contents[i]
+		hashKey := migrationURLHashKey(content.URL)
+
+		survivor, ok := survivors[hashKey]
+
+		if !ok {
+			survivors[hashKey] = content
+			order = append(order, hashKey)
+			continue
+		}
+
+		// Move the comments of the duplicate to the survivor
+
+		err := tx.Table("url_content_comments").
+			Where("url_content_id = ?", content.ID).
+			UpdateColumn("url_content_id", survivor.ID).Error
+
+		if err != nil {
+			return err
+		}
+
+		survivor.TotalComment += content.TotalComment
+
+		if err := tx.Exec("DELETE FROM url_contents WHERE id = ?", content.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	// Duplicates are deleted before updating the keys
+	// so the unique index is not violated
+
+	for _, hashKey := range order {
+
+		survivor := survivors[hashKey]
+
+		err := tx.Table("url_contents").Where("id = ?", survivor.ID).UpdateColumns(map[string]interface{}{
+			"url":           migrationCleanURL(survivor.URL),
+			"hash_key":      hashKey,
+			"total_comment": survivor.TotalComment,
+		}).Error
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mergeDomains(tx *gorm.DB) error {
+
+	type Domain struct {
+		ID       uint `gorm:"primary_key"`
+		UserID   uint
+		Domain   string
+		HashKey  string
+		IsActive bool
+		Votes    uint
+	}
+
+	type DomainVote struct {
+		ID        uint `gorm:"primary_key"`
+		CreatedAt uint
+		UpdatedAt uint
+		UserID    uint
+		DomainID  uint
+	}
+
+	var domains []Domain
+
+	if err := tx.Table("domains").Order("id ASC").Find(&domains).Error; err != nil {
+		return err
+	}
+
+	survivors := make(map[string]*Domain)
+	order := make([]string, 0)
+
+	for i := range domains {
+
+		domain := &domains[i]
+		hashKey := migrationDomainHashKey(domain.Domain)
+
+		survivor, ok := survivors[hashKey]
+
+		if !ok {
+			survivors[hashKey] = domain
+			order = append(order, hashKey)
+			continue
+		}
+
+		// Move the votes of the duplicate to the survivor,
+		// users who voted for both only keep one vote
+
+		var votes []DomainVote
+
+		if err := tx.Table("domain_votes").Where("domain_id = ?", domain.ID).Find(&votes).Error; err != nil {
+			return err
+		}
+
+		for _, vote := range votes {
+
+			count := 0
+
+			err := tx.Table("domain_votes").
+				Where("domain_id = ? AND user_id = ?", survivor.ID, vote.UserID).
+				Count(&count).Error
+
+			if err != nil {
+				return err
+			}
+
+			if count > 0 {
+				err = tx.Exec("DELETE FROM domain_votes WHERE id = ?", vote.ID).Error
+			} else {
+				err = tx.Table("domain_votes").Where("id = ?", vote.ID).UpdateColumn("domain_id", survivor.ID).Error
+				survivor.Votes++
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		// The creator of the duplicate counts as a voter of the survivor
+
+		if domain.UserID != survivor.UserID {
+
+			count := 0
+
+			err := tx.Table("domain_votes").
+				Where("domain_id = ? AND user_id = ?", survivor.ID, domain.UserID).
+				Count(&count).Error
+
+			if err != nil {
+				return err
+			}
+
+			if count == 0 {
+				now := uint(time.Now().Unix())
+				vote := &DomainVote{CreatedAt: now, UpdatedAt: now, UserID: domain.UserID, DomainID: survivor.ID}
+
+				if err := tx.Table("domain_votes").Create(vote).Error; err != nil {
+					return err
+				}
+
+				survivor.Votes++
+			}
+		}
+
+		survivor.IsActive = survivor.IsActive || domain.IsActive
+
+		if err := tx.Exec("DELETE FROM domains WHERE id = ?", domain.ID).Error; err != nil {
+			return err
+		}
+	}
+
+	for _, hashKey := range order {
+
+		survivor := survivors[hashKey]
+
+		err := tx.Table("domains").Where("id = ?", survivor.ID).UpdateColumns(map[string]interface{}{
+			"domain":    migrationCleanDomain(survivor.Domain),
+			"hash_key":  hashKey,
+			"is_active": survivor.IsActive,
+			"votes":     survivor.Votes,
+		}).Error
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The keys are computed as the models did when this migration was written,
+// the canonicalization of util.URLCanonicalizer is copied here so later
+// changes of the models or the canonicalizer don't change what it writes.
+// The url rules in the config of the deployment are applied as they were then.
+
+// Query parameters that never change the content of a page
+var migrationTrackingParams = []string{
+	"fbclid", "gclid", "dclid", "msclkid", "yclid", "igshid",
+	"mc_cid", "mc_eid", "_ga", "_gl", "_hsenc", "_hsmi",
+	"ref_src", "ref_url", "spm", "share", "sharesource",
+}
+
+var migrationTrackingPrefixes = []string{"utm_", "pk_", "hmb_"}
+
+// Sites identifying their pages by some of the query parameters
+var migrationQueryRules = map[string][]string{
+	"youtube.com":          {"v", "list"},
+	"news.ycombinator.com": {"id"},
+	"facebook.com":         {"id", "story_fbid", "v"},
+	"google.com":           {"q"},
+	"bilibili.com":         {"p"},
+}
+
+type migrationCanonicalizer struct {
+	trackingParams map[string]bool
+	queryRules     map[string]map[string]bool
+}
+
+var migrationURLCanonicalizer *migrationCanonicalizer
+var migrationCanonicalizerOnce sync.Once
+
+func getMigrationCanonicalizer() *migrationCanonicalizer {
+	migrationCanonicalizerOnce.Do(func() {
+		c := &migrationCanonicalizer{
+			trackingParams: make(map[string]bool),
+			queryRules:     make(map[string]map[string]bool),
+		}
+
+		for _, param := range migrationTrackingParams {
+			c.trackingParams[param] = true
+		}
+
+		for domain, params := range migrationQueryRules {
+			c.setQueryRule(domain, params)
+		}
+
+		if conf := config.GetConfig(); conf != nil {
+			for _, param := range conf.GetStringSlice("url.tracking_params") {
+				c.trackingParams[param] = true
+			}
+
+			var rules []struct {
+				Domain string   `mapstructure:"domain"`
+				Params []string `mapstructure:"params"`
+			}
+
+			if err := conf.UnmarshalKey("url.query_rules", &rules); err == nil {
+				for _, rule := range rules {
+					c.setQueryRule(rule.Domain, rule.Params)
+				}
+			}
+		}
+
+		migrationURLCanonicalizer = c
+	})
+
+	return migrationURLCanonicalizer
+}
+
+func (c *migrationCanonicalizer) setQueryRule(domain string, params []string) {
+
+	host, err := migrationCanonicalizeHost(domain)
+
+	if err != nil {
+		return
+	}
+
+	if len(params) == 0 {
+		delete(c.queryRules, host)
+		return
+	}
+
+	keep := make(map[string]bool)
+
+	for _, param := range params {
+		keep[param] = true
+	}
+
+	c.queryRules[host] = keep
+}
+
+func (c *migrationCanonicalizer) canonicalizeURL(rawURL string) (string, error) {
+
+	rawURL = strings.TrimSpace(rawURL)
+
+	if rawURL == "" {
+		return "", util.ErrInvalidURL
+	}
+
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+
+	if err != nil || u.Host == "" {
+		return "", util.ErrInvalidURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.User = nil
+
+	port := u.Port()
+
+	host, err := migrationCanonicalizeHost(u.Hostname())
+
+	if err != nil {
+		return "", err
+	}
+
+	if port != "" && !((u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443")) {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 address
+		host = "[" + host + "]"
+	}
+
+	u.Host = host
+
+	p := u.Path
+
+	if p == "" {
+		p = "/"
+	}
+
+	p = path.Clean(p)
+
+	if p == "." {
+		p = "/"
+	}
+
+	u.Path = p
+	u.RawPath = ""
+
+	u.RawQuery = c.canonicalizeQuery(u.Hostname(), u.Query())
+
+	// Hash bang fragments are the paths of single page applications
+	if strings.HasPrefix(u.Fragment, "!") {
+		u.Fragment = "!" + strings.TrimRight(u.Fragment[1:], "/")
+	} else {
+		u.Fragment = ""
+	}
+
+	u.RawFragment = ""
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+func (c *migrationCanonicalizer) canonicalizeQuery(host string, query url.Values) string {
+
+	// The rule of the host or its closest parent domain
+	var keep map[string]bool
+
+	for h := host; ; {
+		if rule, ok := c.queryRules[h]; ok {
+			keep = rule
+			break
+		}
+
+		i := strings.Index(h, ".")
+
+		if i < 0 {
+			break
+		}
+
+		h = h[i+1:]
+	}
+
+	for param := range query {
+		if keep != nil {
+			if !keep[param] {
+				query.Del(param)
+			}
+			continue
+		}
+
+		if c.isTrackingParam(param) {
+			query.Del(param)
+		}
+	}
+
+	// Encode sorts the parameters by key
+	return query.Encode()
+}
+
+func (c *migrationCanonicalizer) isTrackingParam(param string) bool {
+
+	param = strings.ToLower(param)
+
+	if c.trackingParams[param] {
+		return true
+	}
+
+	for _, prefix := range migrationTrackingPrefixes {
+		if strings.HasPrefix(param, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// migrationCanonicalizeHost is util.URLCanonicalizer.CanonicalizeHost
+// as of this migration.
+func migrationCanonicalizeHost(host string) (string, error) {
+
+	host = strings.TrimSpace(host)
+
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.Trim(host, "[]")
+	host = strings.TrimSuffix(host, ".")
+
+	if host == "" {
+		return "", util.ErrInvalidURL
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+
+	if err != nil {
+		return "", util.ErrInvalidURL
+	}
+
+	return strings.TrimPrefix(ascii, "www."), nil
+}
+
+func migrationCleanURL(rawURL string) string {
+
+	cleaned, err := getMigrationCanonicalizer().canonicalizeURL(rawURL)
+
+	if err != nil {
+		return strings.TrimSpace(rawURL)
+	}
+
+	return cleaned
+}
+
+func migrationURLHashKey(rawURL string) string {
+
+	identity := migrationCleanURL(rawURL)
+
+	if i := strings.Index(identity, "://"); i >= 0 {
+		if scheme := identity[:i]; scheme == "http" || scheme == "https" {
+			identity = identity[i+3:]
+		}
+	}
+
+	sumBytes := sha1.Sum([]byte(identity))
+
+	return hex.EncodeToString(sumBytes[:])
+}
+
+func migrationCleanDomain(domain string) string {
+
+	cleaned, err := migrationCanonicalizeHost(domain)
+
+	if err != nil {
+		return strings.TrimSpace(domain)
+	}
+
+	return cleaned
+}
+
+func migrationDomainHashKey(domain string) string {
+
+	sumBytes := sha1.Sum([]byte(migrationCleanDomain(domain)))
+
+	return hex.EncodeToString(sumBytes[:])
+}
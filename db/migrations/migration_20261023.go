@@ -0,0 +1,72 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261023() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610231000",
+			Migrate: func(tx *gorm.DB) error {
+
+				type BaseModel struct {
+					ID        uint `gorm:"primary_key" json:"-"`
+					CreatedAt uint `json:"created_at"`
+					UpdatedAt uint `json:"updated_at"`
+				}
+
+				type Domain struct {
+					BaseModel
+					Status       string `gorm:"type:varchar(32);default:'pending'" json:"status"`
+					StatusReason string `gorm:"type:text" json:"status_reason"`
+				}
+
+				type DomainTransition struct {
+					BaseModel
+					DomainID   uint   `gorm:"index" json:"-"`
+					FromStatus string `gorm:"type:varchar(32)" json:"from_status"`
+					ToStatus   string `gorm:"type:varchar(32)" json:"to_status"`
+					Reason     string `gorm:"type:text" json:"reason"`
+					Actor      string `gorm:"type:varchar(32)" json:"actor"`
+					UserID     uint   `json:"-"`
+					Votes      uint   `json:"votes"`
+				}
+
+				if err := tx.AutoMigrate(&Domain{}).Error; err != nil {
+					return err
+				}
+
+				if err := tx.AutoMigrate(&DomainTransition{}).Error; err != nil {
+					return err
+				}
+
+				if err := tx.Exec("UPDATE domains SET status = ? WHERE is_active = ?", "active", true).Error; err != nil {
+					return err
+				}
+
+				return tx.Exec("UPDATE domains SET status = ? WHERE is_active = ?", "pending", false).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return tx.DropTable("domain_transitions").Error
+			},
+		},
+	}
+}
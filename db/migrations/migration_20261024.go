@@ -0,0 +1,117 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"path"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261024() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610241000",
+			Migrate: func(tx *gorm.DB) error {
+
+				type BaseModel struct {
+					ID        uint `gorm:"primary_key" json:"-"`
+					CreatedAt uint `json:"created_at"`
+					UpdatedAt uint `json:"updated_at"`
+				}
+
+				type Domain struct {
+					BaseModel
+					Domain string `gorm:"type:text" json:"domain"`
+
+					Host              string `gorm:"type:varchar(255);index" json:"host"`
+					PathPrefix        string `gorm:"type:varchar(255)" json:"path_prefix"`
+					IncludeSubdomains bool   `gorm:"default:false" json:"include_subdomains"`
+				}
+
+				if err := tx.AutoMigrate(&Domain{}).Error; err != nil {
+					return err
+				}
+
+				// Fill the scope of existing domains
+
+				var domains []Domain
+
+				if err := tx.Find(&domains).Error; err != nil {
+					return err
+				}
+
+				for _, domain := range domains {
+
+					host, pathPrefix := migrationParseDomainRule(domain.Domain)
+
+					err := tx.Model(&Domain{}).Where("id = ?", domain.ID).UpdateColumns(map[string]interface{}{
+						"host":        host,
+						"path_prefix": pathPrefix,
+					}).Error
+
+					if err != nil {
+						return err
+					}
+				}
+
+				return nil
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return nil
+			},
+		},
+	}
+}
+
+// migrationParseDomainRule is models.ParseDomainRule as of this migration,
+// it's copied so later changes of the models don't change the scopes it fills.
+// Hosts are canonicalized as in migration 20261022, which is unchanged since.
+func migrationParseDomainRule(domain string) (string, string) {
+
+	host, err := migrationCanonicalizeHost(domain)
+
+	if err != nil {
+		return strings.TrimSpace(domain), ""
+	}
+
+	rest := strings.TrimSpace(domain)
+
+	if i := strings.Index(rest, "://"); i >= 0 {
+		rest = rest[i+3:]
+	}
+
+	if i := strings.IndexAny(rest, "?#"); i >= 0 {
+		rest = rest[:i]
+	}
+
+	i := strings.Index(rest, "/")
+
+	if i < 0 {
+		return host, ""
+	}
+
+	pathPrefix := path.Clean(rest[i:])
+
+	if pathPrefix == "/" || pathPrefix == "." {
+		pathPrefix = ""
+	}
+
+	return host, pathPrefix
+}
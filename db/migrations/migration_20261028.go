@@ -0,0 +1,57 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261028() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610281000",
+			Migrate: func(tx *gorm.DB) error {
+
+				type BaseModel struct {
+					ID        uint `gorm:"primary_key" json:"-"`
+					CreatedAt uint `json:"created_at"`
+					UpdatedAt uint `json:"updated_at"`
+				}
+
+				type User struct {
+					BaseModel
+					TOTPSecret      string `json:"-" gorm:"type:varchar(64)"`
+					TOTPEnabled     bool   `json:"-" gorm:"default:false"`
+					TOTPLastCounter int64  `json:"-" gorm:"default:0"`
+				}
+
+				type UserRecoveryCode struct {
+					BaseModel
+					UserID   uint   `gorm:"index"`
+					CodeHash string `gorm:"type:varchar(64)"`
+					UsedAt   uint
+				}
+
+				return tx.AutoMigrate(&User{}, &UserRecoveryCode{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return nil
+			},
+		},
+	}
+}
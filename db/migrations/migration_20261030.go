@@ -0,0 +1,51 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migrations
+
+import (
+	"github.com/jinzhu/gorm"
+	"gopkg.in/gormigrate.v1"
+)
+
+func Migration20261030() []*gormigrate.Migration {
+	return []*gormigrate.Migration{
+		{
+			ID: "202610301000",
+			Migrate: func(tx *gorm.DB) error {
+
+				type AuditLog struct {
+					ID         uint   `gorm:"primary_key" json:"-"`
+					CreatedAt  uint   `gorm:"index" json:"created_at"`
+					ActorType  string `gorm:"type:varchar(16)" json:"actor_type"`
+					ActorID    uint   `gorm:"index" json:"-"`
+					Action     string `gorm:"type:varchar(64);index" json:"action"`
+					TargetType string `gorm:"type:varchar(32);index:idx_audit_log_target" json:"target_type"`
+					TargetID   string `gorm:"type:varchar(255);index:idx_audit_log_target" json:"target_id"`
+					Before     string `gorm:"type:text" json:"-"`
+					After      string `gorm:"type:text" json:"-"`
+					IP         string `gorm:"type:varchar(64)" json:"ip"`
+					RequestID  string `gorm:"type:varchar(64);index" json:"request_id"`
+				}
+
+				return tx.AutoMigrate(&AuditLog{}).Error
+			},
+			Rollback: func(tx *gorm.DB) error {
+				return nil
+			},
+		},
+	}
+}
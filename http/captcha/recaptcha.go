@@ -27,12 +27,17 @@ import (
 	"time"
 )
 
-const endpoint = "https://www.google.com/recaptcha/api/siteverify"
+const defaultEndpoint = "https://www.google.com/recaptcha/api/siteverify"
 
 type RecaptchaVerifyResponse struct {
-	Success     bool
-	ChallengeTs time.Time
-	Hostname    string
+	Success     bool      `json:"success"`
+	ChallengeTs time.Time `json:"challenge_ts"`
+	Hostname    string    `json:"hostname"`
+}
+
+// IsRecaptchaEnabled tells if tokens can be verified, which needs the secret in config.
+func IsRecaptchaEnabled() bool {
+	return config.GetConfig().GetString("recaptcha.secret") != ""
 }
 
 func VerifyRecaptchaToken(token string) (error, bool) {
@@ -43,7 +48,15 @@ func VerifyRecaptchaToken(token string) (error, bool) {
 	form.Set("secret", secret)
 	form.Set("response", token)
 
+	// The endpoint is only configured in tests
+	endpoint := config.GetConfig().GetString("recaptcha.endpoint")
+
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+
 	req, _ := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	ctx, cancelFn := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancelFn()
@@ -68,7 +81,7 @@ func VerifyRecaptchaToken(token string) (error, bool) {
 
 	duration := time.Now().Unix() - recaptchaResponse.ChallengeTs.Unix()
 
-	if duration <= 0 || duration >= int64(time.Minute*10) {
+	if duration <= 0 || duration >= int64((time.Minute*10).Seconds()) {
 		return nil, false
 	}
 
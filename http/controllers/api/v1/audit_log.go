@@ -0,0 +1,329 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/http/middlewares"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/util"
+)
+
+// Formats of the audit log export
+const (
+	AuditLogFormatCSV   = "csv"
+	AuditLogFormatJSONL = "jsonl"
+)
+
+const auditLogExportBatch = 500
+
+var auditLogCSVHeader = []string{
+	"created_at", "actor_type", "actor_id", "action", "target_type",
+	"target_id", "before", "after", "ip", "request_id",
+}
+
+type AuditLogController struct{}
+
+type AuditLogListForm struct {
+	Action     string `form:"action"`
+	ActorType  string `form:"actor_type"`
+	ActorID    string `form:"actor_id"`
+	TargetType string `form:"target_type"`
+	TargetID   string `form:"target_id"`
+	RequestID  string `form:"request_id"`
+	From       uint   `form:"from"`
+	To         uint   `form:"to"`
+	Page       uint   `form:"page,omitempty"`
+	PageSize   uint   `form:"page_size,omitempty"`
+	Format     string `form:"format"`
+}
+
+// AuditLogEntry is the audit log with the unique id of the actor
+// and the states as json objects.
+type AuditLogEntry struct {
+	*models.AuditLog
+	ActorID string          `json:"actor_id"`
+	Before  json.RawMessage `json:"before"`
+	After   json.RawMessage `json:"after"`
+}
+
+// newAuditLog returns the log of the action by the actor of the request.
+func newAuditLog(action, targetType, targetID string, c *gin.Context) *models.AuditLog {
+
+	auditLog := &models.AuditLog{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		IP:         c.ClientIP(),
+		RequestID:  c.GetString(middlewares.RequestId),
+	}
+
+	if userId, exists := c.Get(middlewares.AuthorizedUserId); exists {
+		auditLog.ActorType = models.AuditActorUser
+		auditLog.ActorID = userId.(uint)
+	} else if c.GetBool(middlewares.AuthorizedAdminKey) {
+		auditLog.ActorType = models.AuditActorAdminKey
+	}
+
+	return auditLog
+}
+
+// List returns the audit logs matching the filters, newest first.
+func (ctrl *AuditLogController) List(c *gin.Context) {
+	var form AuditLogListForm
+
+	if err := c.ShouldBindQuery(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	query := filterAuditLogs(db.GetDb().Model(&models.AuditLog{}), &form)
+
+	var count uint
+
+	if err := query.Count(&count).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	page, pageSize := util.PurePageArgs(form.Page, form.PageSize)
+
+	if !util.CanPaginate(page, pageSize, count) {
+		Success(util.EmptyPagination(page, pageSize), c)
+		return
+	}
+
+	auditLogs := make([]*models.AuditLog, 0)
+
+	offset := (page - 1) * pageSize
+
+	if err := query.Order("id DESC").Offset(offset).Limit(pageSize).Find(&auditLogs).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	err, entries := newAuditLogEntries(auditLogs)
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(util.Paginate(page, pageSize, count, entries), c)
+}
+
+// Export writes all the audit logs matching the filters as csv or json lines,
+// oldest first.
+func (ctrl *AuditLogController) Export(c *gin.Context) {
+	var form AuditLogListForm
+
+	if err := c.ShouldBindQuery(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	if form.Format == "" {
+		form.Format = AuditLogFormatCSV
+	}
+
+	if form.Format != AuditLogFormatCSV && form.Format != AuditLogFormatJSONL {
+		Error("format must be csv or jsonl", c)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="audit_log.`+form.Format+`"`)
+
+	var write func(entry *AuditLogEntry) error
+	var flush func() error
+
+	if form.Format == AuditLogFormatCSV {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+
+		writer := csv.NewWriter(c.Writer)
+
+		if err := writer.Write(auditLogCSVHeader); err != nil {
+			glog.Error(err)
+			return
+		}
+
+		write = func(entry *AuditLogEntry) error {
+			return writer.Write([]string{
+				strconv.FormatUint(uint64(entry.CreatedAt), 10),
+				entry.ActorType,
+				entry.ActorID,
+				entry.Action,
+				entry.TargetType,
+				entry.TargetID,
+				string(entry.Before),
+				string(entry.After),
+				entry.IP,
+				entry.RequestID,
+			})
+		}
+
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+
+		encoder := json.NewEncoder(c.Writer)
+
+		write = func(entry *AuditLogEntry) error {
+			return encoder.Encode(entry)
+		}
+
+		flush = func() error {
+			return nil
+		}
+	}
+
+	// The response is already started, errors can only be logged
+
+	var lastID uint
+
+	for {
+		auditLogs := make([]*models.AuditLog, 0)
+
+		query := filterAuditLogs(db.GetDb().Model(&models.AuditLog{}), &form)
+
+		if err := query.Where("id > ?", lastID).Order("id ASC").Limit(auditLogExportBatch).Find(&auditLogs).Error; err != nil {
+			glog.Error(err)
+			return
+		}
+
+		err, entries := newAuditLogEntries(auditLogs)
+
+		if err != nil {
+			glog.Error(err)
+			return
+		}
+
+		for _, entry := range entries {
+			if err := write(entry); err != nil {
+				glog.Error(err)
+				return
+			}
+		}
+
+		if err := flush(); err != nil {
+			glog.Error(err)
+			return
+		}
+
+		if len(auditLogs) < auditLogExportBatch {
+			return
+		}
+
+		lastID = auditLogs[len(auditLogs)-1].ID
+	}
+}
+
+func filterAuditLogs(query *gorm.DB, form *AuditLogListForm) *gorm.DB {
+
+	if form.Action != "" {
+		query = query.Where("action = ?", form.Action)
+	}
+
+	if form.ActorType != "" {
+		query = query.Where("actor_type = ?", form.ActorType)
+	}
+
+	if form.ActorID != "" {
+		// Unknown users match no logs
+		actor := &models.User{}
+		db.GetDb().Where("unique_id = ?", form.ActorID).First(actor)
+
+		query = query.Where("actor_type = ? AND actor_id = ?", models.AuditActorUser, actor.ID)
+	}
+
+	if form.TargetType != "" {
+		query = query.Where("target_type = ?", form.TargetType)
+	}
+
+	if form.TargetID != "" {
+		query = query.Where("target_id = ?", form.TargetID)
+	}
+
+	if form.RequestID != "" {
+		query = query.Where("request_id = ?", form.RequestID)
+	}
+
+	if form.From != 0 {
+		query = query.Where("created_at >= ?", form.From)
+	}
+
+	if form.To != 0 {
+		query = query.Where("created_at < ?", form.To)
+	}
+
+	return query
+}
+
+func newAuditLogEntries(auditLogs []*models.AuditLog) (error, []*AuditLogEntry) {
+
+	actorIDs := make([]uint, 0)
+
+	for _, auditLog := range auditLogs {
+		if auditLog.ActorID != 0 {
+			actorIDs = append(actorIDs, auditLog.ActorID)
+		}
+	}
+
+	actors := make(map[uint]string)
+
+	if len(actorIDs) > 0 {
+		users := make([]*models.User, 0)
+
+		if err := db.GetDb().Select("id, unique_id").Where("id IN (?)", actorIDs).Find(&users).Error; err != nil {
+			return err, nil
+		}
+
+		for _, user := range users {
+			actors[user.ID] = user.UniqueID
+		}
+	}
+
+	entries := make([]*AuditLogEntry, len(auditLogs))
+
+	for i, auditLog := range auditLogs {
+		entries[i] = &AuditLogEntry{
+			AuditLog: auditLog,
+			ActorID:  actors[auditLog.ActorID],
+			Before:   rawJSON(auditLog.Before),
+			After:    rawJSON(auditLog.After),
+		}
+	}
+
+	return nil, entries
+}
+
+func rawJSON(value string) json.RawMessage {
+	if value == "" {
+		return json.RawMessage("null")
+	}
+
+	return json.RawMessage(value)
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1_test
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/models"
+)
+
+type auditLogEntry struct {
+	Action     string                 `json:"action"`
+	ActorType  string                 `json:"actor_type"`
+	ActorID    string                 `json:"actor_id"`
+	TargetType string                 `json:"target_type"`
+	TargetID   string                 `json:"target_id"`
+	Before     map[string]interface{} `json:"before"`
+	After      map[string]interface{} `json:"after"`
+	RequestID  string                 `json:"request_id"`
+}
+
+func listAuditLogs(t *testing.T, query url.Values, authorization string) []*auditLogEntry {
+	w := userRequest("GET", "/v1/audit_logs?"+query.Encode(), "", "", authorization)
+	assert.Equal(t, w.Code, 200)
+
+	var page struct {
+		Data []*auditLogEntry `json:"data"`
+	}
+
+	parseData(t, w, &page)
+
+	return page.Data
+}
+
+func TestAuditLogController(t *testing.T) {
+	PrepareAuthToken(t)
+
+	_, adminToken := prepareRoleUser(t, models.UserRoleAdmin)
+	moderator, moderatorToken := prepareRoleUser(t, models.UserRoleModerator)
+
+	// Domain approval with the request id of the proxy
+
+	err, domain := PrepareDomain()
+	assert.Equal(t, err, nil)
+
+	domain.IsActive = false
+	domain.Status = models.DomainStatusPending
+	db.GetDb().Save(domain)
+
+	req, _ := http.NewRequest("POST", "/v1/domains/domain/approval?domain="+url.QueryEscape(domain.Domain), nil)
+	req.Header.Add("Authorization", moderatorToken)
+	req.Header.Add("X-Request-ID", "audit-test-approval")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, w.Header().Get("X-Request-ID"), "audit-test-approval")
+
+	logs := listAuditLogs(t, url.Values{"target_type": {models.AuditTargetDomain}, "target_id": {domain.Domain}}, adminToken)
+
+	assert.Equal(t, len(logs), 1)
+	assert.Equal(t, logs[0].Action, models.AuditActionDomainApprove)
+	assert.Equal(t, logs[0].ActorType, models.AuditActorUser)
+	assert.Equal(t, logs[0].ActorID, moderator.UniqueID)
+	assert.Equal(t, logs[0].RequestID, "audit-test-approval")
+	assert.Equal(t, logs[0].Before["status"], models.DomainStatusPending)
+	assert.Equal(t, logs[0].After["status"], models.DomainStatusActive)
+
+	// Comment removal keeps the content
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	err, comment := PrepareURLContentComment(urlContent)
+	assert.Equal(t, err, nil)
+
+	w = userRequest("DELETE", "/v1/comments/"+comment.UniqueID+"?reason=spam", "", "", moderatorToken)
+	assert.Equal(t, w.Code, 200)
+
+	logs = listAuditLogs(t, url.Values{"action": {models.AuditActionCommentRemove}, "target_id": {comment.UniqueID}}, adminToken)
+
+	assert.Equal(t, len(logs), 1)
+	assert.Equal(t, logs[0].Before["content"], comment.Content)
+	assert.Equal(t, logs[0].Before["is_deleted"], false)
+	assert.Equal(t, logs[0].After["is_deleted"], true)
+	assert.Equal(t, logs[0].After["remove_reason"], "spam")
+
+	// Integration adjustment
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	form := url.Values{}
+	form.Set("user_id", user.UniqueID)
+	form.Set("score", "-5")
+	form.Set("reason", "comment spam")
+
+	assert.Equal(t, userRequest("POST", "/v1/users/integration", form.Encode(), "application/x-www-form-urlencoded", moderatorToken).Code, 403)
+	assert.Equal(t, userRequest("POST", "/v1/users/integration", form.Encode(), "application/x-www-form-urlencoded", adminToken).Code, 200)
+
+	adjusted := &models.User{}
+	db.GetDb().Where("id = ?", user.ID).First(adjusted)
+	assert.Equal(t, adjusted.Integration, user.Integration-5)
+
+	logs = listAuditLogs(t, url.Values{"action": {models.AuditActionIntegrationAdjust}, "target_id": {user.UniqueID}}, adminToken)
+
+	assert.Equal(t, len(logs), 1)
+	assert.Equal(t, logs[0].Before["integration"], float64(user.Integration))
+	assert.Equal(t, logs[0].After["integration"], float64(user.Integration-5))
+	assert.Equal(t, logs[0].After["reason"], "comment spam")
+
+	// Roles granted with the admin key
+
+	form = url.Values{}
+	form.Set("user_id", user.UniqueID)
+	form.Set("role", models.UserRoleModerator)
+
+	assert.Equal(t, userRequest("POST", "/v1/users/roles", form.Encode(), "application/x-www-form-urlencoded", config.GetConfig().GetString("admin.key")).Code, 200)
+
+	logs = listAuditLogs(t, url.Values{"action": {models.AuditActionRoleGrant}, "target_id": {user.UniqueID}}, adminToken)
+
+	assert.Equal(t, len(logs), 1)
+	assert.Equal(t, logs[0].ActorType, models.AuditActorAdminKey)
+	assert.Equal(t, logs[0].ActorID, "")
+	assert.Equal(t, logs[0].Before["role"], models.UserRoleUser)
+	assert.Equal(t, logs[0].After["role"], models.UserRoleModerator)
+
+	// Admins only
+
+	assert.Equal(t, userRequest("GET", "/v1/audit_logs", "", "", moderatorToken).Code, 403)
+
+	// Export
+
+	w = userRequest("GET", "/v1/audit_logs/export?format=csv&actor_id="+moderator.UniqueID, "", "", adminToken)
+	assert.Equal(t, w.Code, 200)
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(records), 3)
+	assert.Equal(t, records[0][3], "action")
+	assert.Equal(t, records[1][3], models.AuditActionDomainApprove)
+	assert.Equal(t, records[2][3], models.AuditActionCommentRemove)
+
+	w = userRequest("GET", "/v1/audit_logs/export?format=jsonl&actor_id="+moderator.UniqueID, "", "", adminToken)
+	assert.Equal(t, w.Code, 200)
+
+	scanner := bufio.NewScanner(strings.NewReader(w.Body.String()))
+	lines := 0
+
+	for scanner.Scan() {
+		entry := &auditLogEntry{}
+		assert.Equal(t, json.Unmarshal(scanner.Bytes(), entry), nil)
+		assert.Equal(t, entry.ActorID, moderator.UniqueID)
+		lines++
+	}
+
+	assert.Equal(t, lines, 2)
+
+	assert.Equal(t, userRequest("GET", "/v1/audit_logs/export?format=xml", "", "", adminToken).Code, 400)
+
+	// Audit logs can't be changed
+
+	auditLog := &models.AuditLog{}
+	db.GetDb().Where("target_id = ?", domain.Domain).First(auditLog)
+
+	auditLog.Action = models.AuditActionDomainReject
+	assert.Equal(t, db.GetDb().Save(auditLog).Error, models.ErrAuditLogAppendOnly)
+	assert.Equal(t, db.GetDb().Delete(auditLog).Error, models.ErrAuditLogAppendOnly)
+}
@@ -22,14 +22,17 @@ import (
 	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/http/middlewares"
 	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
+	"github.com/primasio/wormhole/util"
 	"strconv"
 )
 
 type DomainController struct{}
 
 type DomainForm struct {
-	Domain string `form:"domain" json:"domain" binding:"required"`
-	Title  string `form:"title" json:"title" binding:"required"`
+	Domain            string `form:"domain" json:"domain" binding:"required"`
+	Title             string `form:"title" json:"title" binding:"required"`
+	IncludeSubdomains bool   `form:"include_subdomains" json:"include_subdomains"`
 }
 
 func (ctrl *DomainController) Create(c *gin.Context) {
@@ -42,6 +45,13 @@ func (ctrl *DomainController) Create(c *gin.Context) {
 
 		cleanedDomain := models.CleanDomain(form.Domain)
 
+		host, _ := models.ParseDomainRule(cleanedDomain)
+
+		if models.IsPublicSuffix(host) {
+			Error(models.ErrPublicSuffixDomain.Error(), c)
+			return
+		}
+
 		// Check domain uniqueness
 		err, check := models.GetDomainByDomainName(cleanedDomain, db.GetDb(), false)
 
@@ -60,12 +70,22 @@ func (ctrl *DomainController) Create(c *gin.Context) {
 
 		domainModel.Domain = cleanedDomain
 		domainModel.Title = form.Title
+		domainModel.IncludeSubdomains = form.IncludeSubdomains
 		domainModel.HashKey = models.GetDomainHashKey(cleanedDomain)
 
 		userId, _ := c.Get(middlewares.AuthorizedUserId)
 		domainModel.UserID = userId.(uint)
+		domainModel.Votes = 1
+
+		tx := dbi.Begin()
 
-		dbi.Create(&domainModel)
+		if err := service.GetDomain().Create(tx, domainModel); err != nil {
+			tx.Rollback()
+			ErrorServer(err, c)
+			return
+		}
+
+		tx.Commit()
 
 		Success(domainModel, c)
 	}
@@ -110,14 +130,37 @@ func (ctrl *DomainController) List(c *gin.Context) {
 
 	offsetNum := page * pageSize
 
+	cursor, err := getCursor(c)
+
+	if err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
 	domainList := make([]models.Domain, 0)
 
 	dbi := db.GetDb()
 	query := dbi.Where("is_active = ?", urlType != "voting")
-	query = query.Order("created_at DESC").Offset(offsetNum).Limit(pageSize)
+
+	if cursor != nil {
+		query = applyCursor(query, cursor, "", uint(pageSize))
+	} else {
+		query = query.Order("created_at DESC").Offset(offsetNum).Limit(pageSize)
+	}
 
 	query.Find(&domainList)
 
+	if cursor != nil {
+		keys := make([]util.Cursor, len(domainList))
+
+		for i, domain := range domainList {
+			keys[i] = util.Cursor{CreatedAt: domain.CreatedAt, ID: domain.ID}
+		}
+
+		Success(util.CursorPaginate(cursor, uint(pageSize), keys, domainList), c)
+		return
+	}
+
 	Success(domainList, c)
 }
 
@@ -149,6 +192,11 @@ func (ctrl *DomainController) Vote(c *gin.Context) {
 		return
 	}
 
+	if status := domainModel.GetStatus(); status != models.DomainStatusPending {
+		Error("domain is "+status, c)
+		return
+	}
+
 	userId, _ := c.Get(middlewares.AuthorizedUserId)
 	userIdNum := userId.(uint)
 
@@ -211,11 +259,20 @@ func (ctrl *DomainController) Vote(c *gin.Context) {
 		return
 	}
 
+	if err := service.GetDomain().ActivateIfVoted(tx, lockedDomain); err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
 	tx.Commit()
 	Success(lockedDomain, c)
 }
 
-func (ctrl *DomainController) Approve(c *gin.Context) {
+// Withdraw takes back the vote of the user.
+// Active domains stay active since comments may have been made on them.
+func (ctrl *DomainController) Withdraw(c *gin.Context) {
+
 	domain := c.Query("domain")
 
 	if domain == "" {
@@ -223,28 +280,173 @@ func (ctrl *DomainController) Approve(c *gin.Context) {
 		return
 	}
 
-	err, domainModel := models.GetDomainByDomainName(domain, db.GetDb(), false)
+	userId, _ := c.Get(middlewares.AuthorizedUserId)
+	userIdNum := userId.(uint)
+
+	tx := db.GetDb().Begin()
+
+	err, lockedDomain := models.GetDomainByDomainName(models.CleanDomain(domain), tx, true)
 
 	if err != nil {
+		tx.Rollback()
 		ErrorServer(err, c)
 		return
 	}
 
-	if domainModel == nil {
+	if lockedDomain == nil {
+		tx.Rollback()
 		ErrorNotFound(errors.New("domain not found"), c)
 		return
 	}
 
-	if domainModel.IsActive {
-		Error("domain is already active", c)
+	if lockedDomain.UserID == userIdNum {
+		tx.Rollback()
+		Error("vote of the domain creator cannot be withdrawn", c)
+		return
+	}
+
+	vote := &models.DomainVote{}
+
+	tx.Where("user_id = ? AND domain_id = ?", userIdNum, lockedDomain.ID).First(vote)
+
+	if vote.ID == 0 {
+		tx.Rollback()
+		Error("user has not voted", c)
+		return
+	}
+
+	if err := tx.Delete(vote).Error; err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
 		return
 	}
 
-	domainModel.IsActive = true
+	if lockedDomain.Votes > 0 {
+		lockedDomain.Votes--
+	}
+
+	if err := tx.Save(lockedDomain).Error; err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	tx.Commit()
+	Success(lockedDomain, c)
+}
+
+func (ctrl *DomainController) Transitions(c *gin.Context) {
+
+	domain := c.Query("domain")
+
+	if domain == "" {
+		Error("missing query param domain", c)
+		return
+	}
 
 	dbi := db.GetDb()
 
-	dbi.Save(domainModel)
+	err, domainModel := models.GetDomainByDomainName(domain, dbi, false)
 
-	Success(domainModel, c)
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	if domainModel == nil {
+		ErrorNotFound(errors.New("domain not found"), c)
+		return
+	}
+
+	err, transitions := service.GetDomain().ListTransitions(domainModel, dbi)
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(transitions, c)
+}
+
+func (ctrl *DomainController) Approve(c *gin.Context) {
+	transitDomain(models.DomainStatusActive, models.AuditActionDomainApprove, false, c)
+}
+
+func (ctrl *DomainController) Reject(c *gin.Context) {
+	transitDomain(models.DomainStatusRejected, models.AuditActionDomainReject, true, c)
+}
+
+func (ctrl *DomainController) Deactivate(c *gin.Context) {
+	transitDomain(models.DomainStatusDeactivated, models.AuditActionDomainDeactivate, true, c)
+}
+
+// transitDomain changes the status of the domain on behalf of the moderator,
+// who is recorded in the transition and the audit log.
+func transitDomain(status, action string, reasonRequired bool, c *gin.Context) {
+	domain := c.Query("domain")
+
+	if domain == "" {
+		Error("missing query param domain", c)
+		return
+	}
+
+	reason := c.Query("reason")
+
+	if reasonRequired && reason == "" {
+		Error("missing query param reason", c)
+		return
+	}
+
+	userId, _ := c.Get(middlewares.AuthorizedUserId)
+
+	tx := db.GetDb().Begin()
+
+	err, lockedDomain := models.GetDomainByDomainName(domain, tx, true)
+
+	if err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	if lockedDomain == nil {
+		tx.Rollback()
+		ErrorNotFound(errors.New("domain not found"), c)
+		return
+	}
+
+	before := newDomainState(lockedDomain)
+
+	if err := service.GetDomain().Transit(tx, lockedDomain, status, models.DomainActorAdmin, reason, userId.(uint)); err != nil {
+		tx.Rollback()
+
+		if err == models.ErrInvalidDomainTransition {
+			Error("domain is already "+lockedDomain.GetStatus(), c)
+		} else {
+			ErrorServer(err, c)
+		}
+
+		return
+	}
+
+	auditLog := newAuditLog(action, models.AuditTargetDomain, lockedDomain.Domain, c)
+
+	if err := service.GetAudit().Record(tx, auditLog, before, newDomainState(lockedDomain)); err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	tx.Commit()
+	Success(lockedDomain, c)
+}
+
+type domainState struct {
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+	IsActive bool   `json:"is_active"`
+}
+
+func newDomainState(domain *models.Domain) *domainState {
+	return &domainState{Status: domain.GetStatus(), Reason: domain.StatusReason, IsActive: domain.IsActive}
 }
@@ -24,6 +24,7 @@ import (
 	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/http/token"
 	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
 	"github.com/primasio/wormhole/tests"
 	"github.com/primasio/wormhole/util"
 	"log"
@@ -138,7 +139,7 @@ func TestDomainController_Vote(t *testing.T) {
 	user, err := tests.CreateTestUser()
 	assert.Equal(t, err, nil)
 
-	err = user.SetUniqueID(dbi)
+	err = user.SetUniqueID()
 	assert.Equal(t, err, nil)
 	dbi.Create(&user)
 
@@ -263,7 +264,8 @@ func TestDomainController_Approve(t *testing.T) {
 	escaped := url.QueryEscape(domainModel.Domain)
 
 	req, _ := http.NewRequest("POST", "/v1/domains/domain/approval?domain="+escaped, nil)
-	req.Header.Add("Authorization", config.GetConfig().GetString("admin.key"))
+	_, moderatorToken := prepareRoleUser(t, models.UserRoleModerator)
+	req.Header.Add("Authorization", moderatorToken)
 
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -288,3 +290,291 @@ func getDomainListFromJsonString(jsonStr string, t *testing.T) []models.Domain {
 
 	return list
 }
+
+func domainRequest(method, path, domain, reason, authorization string) *httptest.ResponseRecorder {
+	query := url.Values{}
+	query.Set("domain", domain)
+
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+
+	req, _ := http.NewRequest(method, path+"?"+query.Encode(), nil)
+	req.Header.Add("Authorization", authorization)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+
+	return w
+}
+
+func prepareVoter(t *testing.T) string {
+	dbi := db.GetDb()
+
+	user, err := tests.CreateTestUser()
+	assert.Equal(t, err, nil)
+
+	err = user.SetUniqueID()
+	assert.Equal(t, err, nil)
+	dbi.Create(&user)
+
+	err, userToken := token.IssueToken(user.ID, false)
+	assert.Equal(t, err, nil)
+
+	return userToken.Token
+}
+
+func TestDomainController_VoteThreshold(t *testing.T) {
+	PrepareAuthToken(t)
+
+	domain := "threshold" + util.RandStringUppercase(8) + ".io"
+
+	data := url.Values{}
+	data.Set("domain", domain)
+	data.Set("title", "Title of the Domain")
+
+	req, _ := http.NewRequest("POST", "/v1/domains", strings.NewReader(data.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
+	req.Header.Add("Authorization", authToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 200)
+
+	threshold := int(config.GetConfig().GetInt("domain.vote_threshold"))
+	voters := make([]string, threshold-1)
+
+	for i := range voters {
+		voters[i] = prepareVoter(t)
+	}
+
+	// Withdraw a vote before the threshold is reached
+
+	w = domainRequest("PUT", "/v1/domains/domain", domain, "", voters[0])
+	assert.Equal(t, w.Code, 200)
+
+	w = domainRequest("DELETE", "/v1/domains/domain", domain, "", voters[0])
+	assert.Equal(t, w.Code, 200)
+
+	w = domainRequest("DELETE", "/v1/domains/domain", domain, "", voters[0])
+	assert.Equal(t, w.Code, 400)
+
+	w = domainRequest("DELETE", "/v1/domains/domain", domain, "", authToken)
+	assert.Equal(t, w.Code, 400)
+
+	// Reaching the threshold activates the domain
+
+	for _, voter := range voters {
+		w = domainRequest("PUT", "/v1/domains/domain", domain, "", voter)
+		assert.Equal(t, w.Code, 200)
+	}
+
+	err, domainModel := models.GetDomainByDomainName(domain, db.GetDb(), false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domainModel.IsActive, true)
+	assert.Equal(t, domainModel.Status, models.DomainStatusActive)
+	assert.Equal(t, domainModel.Votes, uint(threshold))
+
+	// Active domains stay active after withdrawal
+
+	w = domainRequest("DELETE", "/v1/domains/domain", domain, "", voters[0])
+	assert.Equal(t, w.Code, 200)
+
+	err, domainModel = models.GetDomainByDomainName(domain, db.GetDb(), false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domainModel.IsActive, true)
+
+	// Audit trail
+
+	w = domainRequest("GET", "/v1/domains/domain/transitions", domain, "", "")
+	assert.Equal(t, w.Code, 200)
+
+	var returnData map[string]*json.RawMessage
+
+	err = json.Unmarshal(w.Body.Bytes(), &returnData)
+	assert.Equal(t, err, nil)
+
+	var transitions []models.DomainTransition
+
+	err = json.Unmarshal(*returnData["data"], &transitions)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, len(transitions), 2)
+	assert.Equal(t, transitions[0].ToStatus, models.DomainStatusPending)
+	assert.Equal(t, transitions[1].FromStatus, models.DomainStatusPending)
+	assert.Equal(t, transitions[1].ToStatus, models.DomainStatusActive)
+	assert.Equal(t, transitions[1].Actor, models.DomainActorSystem)
+}
+
+func TestDomainController_RejectDeactivate(t *testing.T) {
+	PrepareAuthToken(t)
+
+	err, domainModel := PrepareDomain()
+	assert.Equal(t, err, nil)
+
+	moderator, moderatorToken := prepareRoleUser(t, models.UserRoleModerator)
+
+	// The admin key and users can't moderate domains
+
+	w := domainRequest("POST", "/v1/domains/domain/deactivation", domainModel.Domain, "spam", config.GetConfig().GetString("admin.key"))
+	assert.Equal(t, w.Code, 403)
+
+	w = domainRequest("POST", "/v1/domains/domain/deactivation", domainModel.Domain, "spam", prepareVoter(t))
+	assert.Equal(t, w.Code, 403)
+
+	// Deactivation requires a reason
+
+	w = domainRequest("POST", "/v1/domains/domain/deactivation", domainModel.Domain, "", moderatorToken)
+	assert.Equal(t, w.Code, 400)
+
+	w = domainRequest("POST", "/v1/domains/domain/deactivation", domainModel.Domain, "spam", moderatorToken)
+	assert.Equal(t, w.Code, 200)
+
+	err, domainModel = models.GetDomainByDomainName(domainModel.Domain, db.GetDb(), false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domainModel.IsActive, false)
+	assert.Equal(t, domainModel.Status, models.DomainStatusDeactivated)
+	assert.Equal(t, domainModel.StatusReason, "spam")
+
+	// Deactivated domains can't be voted or rejected
+
+	w = domainRequest("PUT", "/v1/domains/domain", domainModel.Domain, "", prepareVoter(t))
+	assert.Equal(t, w.Code, 400)
+
+	w = domainRequest("POST", "/v1/domains/domain/rejection", domainModel.Domain, "spam", moderatorToken)
+	assert.Equal(t, w.Code, 400)
+
+	// Approve again, then reject a new pending domain
+
+	w = domainRequest("POST", "/v1/domains/domain/approval", domainModel.Domain, "", moderatorToken)
+	assert.Equal(t, w.Code, 200)
+
+	err, pending := PrepareDomain()
+	assert.Equal(t, err, nil)
+
+	pending.IsActive = false
+	pending.Status = models.DomainStatusPending
+	db.GetDb().Save(pending)
+
+	w = domainRequest("POST", "/v1/domains/domain/rejection", pending.Domain, "adult", moderatorToken)
+	assert.Equal(t, w.Code, 200)
+
+	err, pending = models.GetDomainByDomainName(pending.Domain, db.GetDb(), false)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, pending.GetStatus(), models.DomainStatusRejected)
+
+	// The moderator is recorded in the transition
+
+	err, transitions := service.GetDomain().ListTransitions(pending, db.GetDb())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, transitions[len(transitions)-1].UserID, moderator.ID)
+
+	// Moderators only
+
+	w = domainRequest("POST", "/v1/domains/domain/rejection", pending.Domain, "adult", authToken)
+	assert.Equal(t, w.Code, 403)
+}
+
+func TestMatchDomainByURL(t *testing.T) {
+	PrepareSystemUser()
+
+	dbi := db.GetDb()
+	randStr := strings.ToLower(util.RandString(8))
+
+	create := func(domain string, includeSubdomains bool) *models.Domain {
+		domainModel := &models.Domain{
+			Domain:            domain,
+			UserID:            systemUser.ID,
+			IncludeSubdomains: includeSubdomains,
+		}
+
+		domainModel.HashKey = models.GetDomainHashKey(domain)
+		dbi.Create(domainModel)
+
+		return domainModel
+	}
+
+	host := "match" + randStr + ".co.uk"
+
+	wildcard := create(host, true)
+	exact := create("m."+host, false)
+	scoped := create(host+"/@author", false)
+
+	cases := map[string]uint{
+		"https://" + host + "/page":              wildcard.ID,
+		"https://blog." + host + "/page":         wildcard.ID,
+		"https://a.b." + host + "/page":          wildcard.ID,
+		"https://m." + host + "/page":            exact.ID,
+		"https://www." + host + "/@author/post":  scoped.ID,
+		"https://" + host + "/@author":           scoped.ID,
+		"https://" + host + "/@authorx":          wildcard.ID,
+		"https://blog." + host + "/@author/post": wildcard.ID,
+	}
+
+	for urlStr, id := range cases {
+		err, domain := models.MatchDomainByURL(urlStr, dbi)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, domain.ID, id, urlStr)
+	}
+
+	// Subdomains of the exact host are not covered
+
+	err, domain := models.MatchDomainByURL("https://x.m."+host+"/page", dbi)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domain.ID, wildcard.ID)
+
+	// Rules never reach across the registrable domain
+
+	err, domain = models.MatchDomainByURL("https://other"+randStr+".co.uk/page", dbi)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domain == nil, true)
+
+	// Pending rules don't shadow the active ones covering them
+
+	dbi.Model(wildcard).UpdateColumns(map[string]interface{}{"is_active": true, "status": models.DomainStatusActive})
+
+	err, domain = models.MatchDomainByURL("https://"+host+"/@author/post", dbi)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domain.ID, wildcard.ID)
+
+	dbi.Model(scoped).UpdateColumns(map[string]interface{}{"is_active": true, "status": models.DomainStatusActive})
+
+	err, domain = models.MatchDomainByURL("https://"+host+"/@author/post", dbi)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domain.ID, scoped.ID)
+
+	// Deactivated rules still shadow the active ones covering them
+
+	dbi.Model(scoped).UpdateColumns(map[string]interface{}{"is_active": false, "status": models.DomainStatusDeactivated})
+
+	err, domain = models.MatchDomainByURL("https://"+host+"/@author/post", dbi)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, domain.ID, scoped.ID)
+
+	_, isNotApproved := service.GetDomain().CheckURL("https://"+host+"/@author/post", dbi).(*service.DomainNotApprovedError)
+	assert.Equal(t, isNotApproved, true)
+	assert.Equal(t, service.GetDomain().CheckURL("https://"+host+"/page", dbi), nil)
+
+	// Public suffixes can't be registered
+
+	PrepareAuthToken(t)
+
+	data := url.Values{}
+	data.Set("domain", "github.io")
+	data.Set("title", "Title of the Domain")
+	data.Set("include_subdomains", "true")
+
+	req, _ := http.NewRequest("POST", "/v1/domains", strings.NewReader(data.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
+	req.Header.Add("Authorization", authToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+	assert.Equal(t, w.Code, 400)
+}
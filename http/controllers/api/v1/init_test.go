@@ -31,6 +31,7 @@ import (
 	"github.com/magiconair/properties/assert"
 	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/http/server"
+	"github.com/primasio/wormhole/http/token"
 	"github.com/primasio/wormhole/models"
 	"github.com/primasio/wormhole/tests"
 )
@@ -58,7 +59,7 @@ func PrepareSystemUser() {
 
 	dbi := db.GetDb()
 
-	if err := user.SetUniqueID(dbi); err != nil {
+	if err := user.SetUniqueID(); err != nil {
 		log.Fatal(err)
 	}
 
@@ -98,12 +99,12 @@ func PrepareAuthToken(t *testing.T) {
 	err := json.Unmarshal([]byte(responseStr), &returnData)
 	assert.Equal(t, err, nil)
 
-	var tokenStruct map[string]string
+	var tokenStruct token.Token
 
 	err = json.Unmarshal(*returnData["data"], &tokenStruct)
 	assert.Equal(t, err, nil)
 
-	authToken = tokenStruct["token"]
+	authToken = tokenStruct.Token
 
 	log.Println("token: " + authToken)
 }
@@ -116,6 +117,7 @@ func ResetDB() {
 		"url_content_comments",
 		"domain_votes",
 		"domains",
+		"domain_transitions",
 	}
 
 	dbi := db.GetDb()
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/util"
+)
+
+type LoginLockoutController struct{}
+
+type LoginLockoutListForm struct {
+	Scope    string `form:"scope"`
+	Username string `form:"username"`
+	IP       string `form:"ip"`
+	Page     uint   `form:"page,omitempty"`
+	PageSize uint   `form:"page_size,omitempty"`
+}
+
+// List returns the lockouts of failed logins to the admins, newest first.
+func (ctrl *LoginLockoutController) List(c *gin.Context) {
+	var form LoginLockoutListForm
+
+	if err := c.ShouldBindQuery(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	query := db.GetDb().Model(&models.LoginLockout{})
+
+	if form.Scope != "" {
+		query = query.Where("scope = ?", form.Scope)
+	}
+
+	if form.Username != "" {
+		query = query.Where("username = ?", form.Username)
+	}
+
+	if form.IP != "" {
+		query = query.Where("ip = ?", form.IP)
+	}
+
+	var count uint
+
+	if err := query.Count(&count).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	page, pageSize := util.PurePageArgs(form.Page, form.PageSize)
+
+	if !util.CanPaginate(page, pageSize, count) {
+		Success(util.EmptyPagination(page, pageSize), c)
+		return
+	}
+
+	lockouts := make([]*models.LoginLockout, 0)
+
+	offset := (page - 1) * pageSize
+
+	if err := query.Order("id DESC").Offset(offset).Limit(pageSize).Find(&lockouts).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(util.Paginate(page, pageSize, count, lockouts), c)
+}
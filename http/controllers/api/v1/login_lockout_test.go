@@ -0,0 +1,222 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/http/controllers/api/v1"
+	"github.com/primasio/wormhole/models"
+)
+
+// authFrom logs in from the given address with an optional captcha token
+func authFrom(username, password, captchaToken, remoteAddr string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("username", username)
+	form.Set("password", password)
+
+	if captchaToken != "" {
+		form.Set("captcha", captchaToken)
+	}
+
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", "/v1/users/auth", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = remoteAddr
+
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func parseResponse(t *testing.T, w *httptest.ResponseRecorder) (string, string) {
+	var response struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+
+	assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &response), nil)
+
+	return response.Code, response.Message
+}
+
+func TestUserController_AuthLoginGuard(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	recaptcha := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":      r.PostFormValue("response") == "passed",
+			"challenge_ts": time.Now().Add(-time.Second * 5).Format(time.RFC3339),
+		})
+	}))
+	defer recaptcha.Close()
+
+	c := config.GetConfig()
+
+	c.Set("recaptcha.secret", "test_secret")
+	c.Set("recaptcha.endpoint", recaptcha.URL)
+	c.Set("login_guard.backoff_base", "1ms")
+	c.Set("login_guard.backoff_max", "1ms")
+
+	defer func() {
+		c.Set("recaptcha.secret", "")
+		c.Set("recaptcha.endpoint", "")
+		c.Set("login_guard.backoff_base", "1s")
+		c.Set("login_guard.backoff_max", "1m")
+		c.Set("login_guard.lockout_after", 10)
+	}()
+
+	remoteAddr := "10.20.30.40:1234"
+
+	// Unknown usernames and wrong passwords get the same error
+
+	w := authFrom("unknown_"+user.Username, "PrimasGoGoGo", "", remoteAddr)
+	assert.Equal(t, w.Code, 401)
+	_, unknownMessage := parseResponse(t, w)
+
+	for i := 0; i < 3; i++ {
+		w = authFrom(user.Username, "wrong", "", remoteAddr)
+		assert.Equal(t, w.Code, 401)
+
+		_, message := parseResponse(t, w)
+		assert.Equal(t, message, unknownMessage)
+	}
+
+	// The backoff of the third failure is over after a millisecond
+
+	time.Sleep(time.Millisecond * 10)
+
+	w = authFrom(user.Username, "PrimasGoGoGo", "", remoteAddr)
+	assert.Equal(t, w.Code, 401)
+
+	code, _ := parseResponse(t, w)
+	assert.Equal(t, code, v1.ErrCodeCaptchaRequired)
+
+	w = authFrom(user.Username, "PrimasGoGoGo", "failed", remoteAddr)
+	assert.Equal(t, w.Code, 401)
+
+	code, _ = parseResponse(t, w)
+	assert.Equal(t, code, v1.ErrCodeCaptchaRequired)
+
+	assert.Equal(t, authFrom(user.Username, "PrimasGoGoGo", "passed", remoteAddr).Code, 200)
+
+	// Success resets the failures of the username
+
+	assert.Equal(t, authFrom(user.Username, "PrimasGoGoGo", "", remoteAddr).Code, 200)
+
+	// Successful logins are not counted as failures of the ip
+
+	c.Set("login_guard.ip_captcha_after", 2)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, authFrom(user.Username, "PrimasGoGoGo", "", "10.20.30.42:1234").Code, 200)
+	}
+
+	c.Set("login_guard.ip_captcha_after", 10)
+
+	// Lockout
+
+	c.Set("login_guard.lockout_after", 2)
+
+	assert.Equal(t, authFrom(user.Username, "wrong", "", remoteAddr).Code, 401)
+	assert.Equal(t, authFrom(user.Username, "wrong", "", remoteAddr).Code, 401)
+
+	w = authFrom(user.Username, "PrimasGoGoGo", "", "10.20.30.41:1234")
+	assert.Equal(t, w.Code, 429)
+	assert.Equal(t, w.Header().Get("Retry-After") != "", true)
+
+	code, _ = parseResponse(t, w)
+	assert.Equal(t, code, v1.ErrCodeLoginLocked)
+
+	// Lockouts are listed to admins
+
+	path := "/v1/users/lockouts?scope=username&username=" + url.QueryEscape(user.Username)
+
+	other, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, userRequest("GET", path, "", "", login(t, other, "").Token).Code, 403)
+
+	_, adminToken := prepareRoleUser(t, models.UserRoleAdmin)
+
+	w = userRequest("GET", path, "", "", adminToken)
+	assert.Equal(t, w.Code, 200)
+
+	var page struct {
+		Total uint                   `json:"total"`
+		Data  []*models.LoginLockout `json:"data"`
+	}
+
+	parseData(t, w, &page)
+
+	assert.Equal(t, page.Total, uint(1))
+	assert.Equal(t, page.Data[0].Username, user.Username)
+	assert.Equal(t, page.Data[0].IP, "10.20.30.40")
+	assert.Equal(t, page.Data[0].Failures, uint(2))
+}
+
+func TestUserController_AuthLoginGuardParallel(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	c := config.GetConfig()
+
+	c.Set("login_guard.lockout_after", 3)
+	defer c.Set("login_guard.lockout_after", 10)
+
+	// Parallel guesses are counted before the passwords are verified
+
+	codes := make(chan int, 10)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			codes <- authFrom(user.Username, "wrong", "", "10.20.31.40:1234").Code
+		}()
+	}
+
+	wg.Wait()
+	close(codes)
+
+	verified := 0
+
+	for code := range codes {
+		if code == 401 {
+			verified++
+		} else {
+			assert.Equal(t, code, 429)
+		}
+	}
+
+	assert.Equal(t, verified <= 3, true)
+	assert.Equal(t, authFrom(user.Username, "PrimasGoGoGo", "", "10.20.31.41:1234").Code, 429)
+}
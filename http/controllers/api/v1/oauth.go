@@ -18,51 +18,225 @@ package v1
 
 import (
 	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
 	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
 	"github.com/primasio/wormhole/cache"
+	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/http/oauth"
 	"github.com/primasio/wormhole/http/token"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
 	"github.com/primasio/wormhole/util"
-	"time"
+)
+
+const oauthStatePurpose = "oauth_state"
+const oauthStateTTL = time.Minute * 30
+
+// Codes returned with response_type=code are exchanged for tokens right away
+const oauthCodePurpose = "oauth_code"
+const oauthCodeTTL = time.Minute * 5
+
+// Codes of linked accounts are confirmed by the user who started linking
+const oauthLinkPurpose = "oauth_link"
+const oauthLinkTTL = time.Minute * 5
+
+// How the token is returned to the redirect uri
+const (
+	OAuthResponseToken = "token"
+	OAuthResponseCode  = "code"
 )
 
 type OAuthController struct{}
 
-func (ctrl *OAuthController) GoogleAuth(c *gin.Context) {
+// oauthState is remembered between the redirect to the provider and the callback,
+// and the id of the user when an account is linked to the user.
+type oauthState struct {
+	RedirectURI   string `json:"redirect_uri"`
+	Verifier      string `json:"verifier"`
+	LinkUserID    uint   `json:"link_user_id"`
+	ResponseType  string `json:"response_type"`
+	CodeChallenge string `json:"code_challenge"`
+}
+
+// oauthCode is remembered for the code returned to the redirect uri
+type oauthCode struct {
+	UserID        uint   `json:"user_id"`
+	CodeChallenge string `json:"code_challenge"`
+}
+
+// oauthLink is remembered for the code of an account to link,
+// the account is linked when the same user confirms the code.
+type oauthLink struct {
+	UserID     uint   `json:"user_id"`
+	VendorType uint   `json:"vendor_type"`
+	VendorID   string `json:"vendor_id"`
+}
+
+// OAuthAuthForm starts the sign in. Clients which can't keep the token out of
+// the url history, like the browser extension, use response_type=code with PKCE.
+type OAuthAuthForm struct {
+	RedirectURI         string `form:"redirect_uri" binding:"required"`
+	ResponseType        string `form:"response_type"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+}
+
+type OAuthTokenForm struct {
+	Code         string `form:"code" json:"code" binding:"required"`
+	CodeVerifier string `form:"code_verifier" json:"code_verifier"`
+	Device       string `form:"device" json:"device"`
+}
+
+type OAuthLinkForm struct {
+	RedirectURI string `form:"redirect_uri" json:"redirect_uri" binding:"required"`
+}
+
+type OAuthLinkConfirmForm struct {
+	Code string `form:"code" json:"code" binding:"required"`
+}
+
+// OAuthIdentity is an account of a provider linked to the user
+type OAuthIdentity struct {
+	Provider string `json:"provider"`
+	LinkedAt uint   `json:"linked_at"`
+}
+
+func getOAuthProvider(c *gin.Context, param string) oauth.Provider {
+
+	provider := oauth.GetProvider(c.Param(param))
+
+	if provider == nil {
+		ErrorNotFound(errors.New("oauth provider not found"), c)
+	}
+
+	return provider
+}
+
+// withQuery appends the query param to the redirect uri
+func withQuery(redirectURI, key, value string) string {
+
+	u, err := url.Parse(redirectURI)
+
+	if err != nil {
+		return redirectURI
+	}
+
+	query := u.Query()
+	query.Set(key, value)
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
+
+// withFragment adds the params as the fragment of the redirect uri,
+// which is kept by the browser and not sent to servers.
+func withFragment(redirectURI string, params url.Values) string {
+	return redirectURI + "#" + params.Encode()
+}
+
+func (ctrl *OAuthController) Auth(c *gin.Context) {
 
-	redirectURI := c.Query("redirect_uri")
+	provider := getOAuthProvider(c, "provider")
 
-	if redirectURI == "" {
-		Error("missing query param redirect_uri", c)
+	if provider == nil {
 		return
 	}
 
-	// State is used to prevent attack
-	// also as a session key to remember the source of request
-	state := util.RandString(8)
+	var form OAuthAuthForm
+
+	if err := c.ShouldBindQuery(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	// The uri is checked before redirecting to the provider,
+	// so tokens and codes are only sent to trusted places
+	if err := oauth.ValidateRedirectURI(form.RedirectURI); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	state := &oauthState{RedirectURI: form.RedirectURI, ResponseType: form.ResponseType}
+
+	if form.CodeChallenge != "" {
+		if err := oauth.ValidateCodeChallenge(form.CodeChallenge, form.CodeChallengeMethod); err != nil {
+			Error(err.Error(), c)
+			return
+		}
+
+		state.CodeChallenge = form.CodeChallenge
+
+		if state.ResponseType == "" {
+			state.ResponseType = OAuthResponseCode
+		}
+	}
+
+	if state.ResponseType == "" {
+		state.ResponseType = OAuthResponseToken
+	}
+
+	if state.ResponseType != OAuthResponseToken && state.ResponseType != OAuthResponseCode {
+		Error("response_type must be token or code", c)
+		return
+	}
+
+	if state.CodeChallenge != "" && state.ResponseType != OAuthResponseCode {
+		Error("code_challenge requires response_type code", c)
+		return
+	}
+
+	err, authURL := getOAuthAuthURL(provider, state)
 
-	if err := cache.GetCache().Set("oauth_state_"+state, redirectURI, time.Hour*2); err != nil {
+	if err != nil {
 		ErrorServer(err, c)
 		return
 	}
 
-	redirectUrl := oauth.HandleGoogleAuth(state)
-	c.Redirect(301, redirectUrl)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// getOAuthAuthURL remembers the state and returns the url of the provider
+func getOAuthAuthURL(provider oauth.Provider, state *oauthState) (error, string) {
+
+	verifier, err := util.RandToken(64)
+
+	if err != nil {
+		return err, ""
+	}
+
+	state.Verifier = verifier
+
+	// State is used to prevent attack
+	// also as a session key to remember the source of request
+	err, stateToken := cache.OneTimeTokenIssue(oauthStatePurpose+"_"+provider.Name(), state, oauthStateTTL)
+
+	if err != nil {
+		return err, ""
+	}
+
+	return nil, provider.AuthCodeURL(stateToken, verifier)
 }
 
-func (ctrl *OAuthController) GoogleAuthCallback(c *gin.Context) {
+func (ctrl *OAuthController) Callback(c *gin.Context) {
 
-	code := c.Query("code")
-	state := c.Query("state")
-	googleError := c.Query("error")
+	provider := getOAuthProvider(c, "callback_provider")
 
-	var redirectUri string
+	if provider == nil {
+		return
+	}
 
 	// Check state
 
-	if err := cache.GetCache().Get("oauth_state_"+state, &redirectUri); err != nil {
+	state := &oauthState{}
+
+	if err := cache.OneTimeTokenConsume(oauthStatePurpose+"_"+provider.Name(), c.Query("state"), state); err != nil {
 
-		if err != cache.ErrCacheMiss && err != cache.ErrNotStored {
+		if err == cache.ErrOneTimeTokenNotFound {
 			ErrorUnauthorized("state expired", c)
 		} else {
 			ErrorServer(err, c)
@@ -71,35 +245,344 @@ func (ctrl *OAuthController) GoogleAuthCallback(c *gin.Context) {
 		return
 	}
 
-	if redirectUri == "" {
-		ErrorServer(errors.New("redirect uri not found"), c)
+	// Check provider return error
+
+	if providerError := c.Query("error"); providerError != "" {
+		c.Redirect(http.StatusFound, withQuery(state.RedirectURI, "error", providerError))
+		return
+	}
+
+	if state.LinkUserID != 0 {
+		ctrl.linkCallback(provider, state, c)
+		return
+	}
+
+	err, userId := oauth.HandleCallback(provider, c.Query("code"), state.Verifier)
+
+	if err != nil {
+		glog.Error("oauth ", provider.Name(), ": ", err)
+		c.Redirect(http.StatusFound, withQuery(state.RedirectURI, "error", "server_error"))
 		return
 	}
 
-	// Check google return error
+	// Redirect to where it begins
+
+	if state.ResponseType == OAuthResponseCode {
+		err, code := cache.OneTimeTokenIssue(oauthCodePurpose, &oauthCode{UserID: userId, CodeChallenge: state.CodeChallenge}, oauthCodeTTL)
+
+		if err != nil {
+			ErrorServer(err, c)
+			return
+		}
 
-	if googleError != "" {
-		c.Redirect(301, redirectUri+"?error="+googleError)
+		c.Redirect(http.StatusFound, withQuery(state.RedirectURI, "code", code))
 		return
 	}
 
-	err, userId := oauth.HandleGoogleAuthCallback(code)
+	err, accessToken, challenge := issueOAuthToken(userId, "", c)
 
 	if err != nil {
 		ErrorServer(err, c)
 		return
 	}
 
-	err, accessToken := token.IssueToken(userId, false)
+	params := url.Values{}
+
+	if challenge != nil {
+		// The client finishes the sign in with a code at /v1/users/auth/2fa
+		params.Set("two_factor_required", "true")
+		params.Set("challenge_token", challenge.ChallengeToken)
+		params.Set("expires_in", strconv.FormatInt(challenge.ExpiresIn, 10))
+	} else {
+		params.Set("token", accessToken.Token)
+		params.Set("session_id", accessToken.SessionID)
+
+		if accessToken.RefreshToken != "" {
+			params.Set("refresh_token", accessToken.RefreshToken)
+		}
+
+		if accessToken.ExpiresIn != 0 {
+			params.Set("expires_in", strconv.FormatInt(accessToken.ExpiresIn, 10))
+		}
+	}
+
+	c.Redirect(http.StatusFound, withFragment(state.RedirectURI, params))
+}
+
+// Token exchanges a code returned with response_type=code for a token,
+// the code verifier is required if the sign in was started with a code challenge.
+func (ctrl *OAuthController) Token(c *gin.Context) {
+
+	var form OAuthTokenForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	code := &oauthCode{}
+
+	if err := cache.OneTimeTokenConsume(oauthCodePurpose, form.Code, code); err != nil {
+
+		if err == cache.ErrOneTimeTokenNotFound {
+			ErrorUnauthorized("invalid or expired code", c)
+		} else {
+			ErrorServer(err, c)
+		}
+
+		return
+	}
+
+	if code.CodeChallenge != "" && !oauth.VerifyCodeVerifier(code.CodeChallenge, form.CodeVerifier) {
+		ErrorUnauthorized("invalid code verifier", c)
+		return
+	}
+
+	err, accessToken, challenge := issueOAuthToken(code.UserID, form.Device, c)
 
 	if err != nil {
 		ErrorServer(err, c)
 		return
 	}
 
-	// Redirect to where it begins
+	if challenge != nil {
+		Success(challenge, c)
+		return
+	}
+
+	Success(accessToken, c)
+}
+
+// issueOAuthToken issues the token of a user signed in with a provider,
+// users with the second factor enabled get a challenge instead as in Auth.
+func issueOAuthToken(userId uint, device string, c *gin.Context) (error, *token.Token, *TwoFactorChallenge) {
+
+	user := &models.User{}
+	db.GetDb().Where("id = ?", userId).First(user)
+
+	if user.ID == 0 {
+		return errors.New("oauth: user not found"), nil, nil
+	}
+
+	if user.TOTPEnabled {
+		err, challengeToken, ttl := service.GetTwoFactor().IssueChallenge(user, true, device, false)
+
+		if err != nil {
+			return err, nil, nil
+		}
+
+		return nil, nil, &TwoFactorChallenge{
+			TwoFactorRequired: true,
+			ChallengeToken:    challengeToken,
+			ExpiresIn:         int64(ttl.Seconds()),
+		}
+	}
+
+	err, accessToken := token.IssueSessionToken(user.ID, false, getClient(device, c))
+
+	return err, accessToken, nil
+}
+
+// linkCallback returns a code to the redirect uri instead of linking the account,
+// the browser coming back may not be the one of the user who started linking.
+func (ctrl *OAuthController) linkCallback(provider oauth.Provider, state *oauthState, c *gin.Context) {
+
+	result, err := oauth.HandleLinkCallback(provider, c.Query("code"), state.Verifier)
+
+	if err != nil {
+		glog.Error("oauth link ", provider.Name(), ": ", err)
+		c.Redirect(http.StatusFound, withQuery(state.RedirectURI, "error", "server_error"))
+		return
+	}
+
+	err, code := cache.OneTimeTokenIssue(oauthLinkPurpose+"_"+provider.Name(), &oauthLink{UserID: state.LinkUserID, VendorType: result.Type, VendorID: result.Id}, oauthLinkTTL)
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	c.Redirect(http.StatusFound, withQuery(state.RedirectURI, "link_code", code))
+}
+
+// ConfirmLink links the account of the code returned to the redirect uri,
+// the code is only accepted from the user who started linking.
+func (ctrl *OAuthController) ConfirmLink(c *gin.Context) {
+
+	provider := getOAuthProvider(c, "provider")
+
+	if provider == nil {
+		return
+	}
+
+	var form OAuthLinkConfirmForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	user := getAuthorizedUser(db.GetDb(), c)
+
+	if user == nil {
+		return
+	}
+
+	link := &oauthLink{}
+
+	if err := cache.OneTimeTokenConsume(oauthLinkPurpose+"_"+provider.Name(), form.Code, link); err != nil {
+
+		if err == cache.ErrOneTimeTokenNotFound {
+			ErrorUnauthorized("invalid or expired code", c)
+		} else {
+			ErrorServer(err, c)
+		}
+
+		return
+	}
+
+	if link.UserID != user.ID {
+		ErrorUnauthorized("invalid or expired code", c)
+		return
+	}
+
+	result := &oauth.OAuthResult{Type: link.VendorType, Id: link.VendorID}
+
+	switch err := result.Link(user.ID); err {
+	case nil:
+		Success(gin.H{"linked": provider.Name()}, c)
+	case oauth.ErrIdentityLinked, oauth.ErrProviderLinked:
+		Error(err.Error(), c)
+	default:
+		ErrorServer(err, c)
+	}
+}
+
+// Link returns the url of the provider to link an account to the current user,
+// the client opens it and the provider redirects to the callback as in sign in.
+// The callback returns a link_code to the redirect uri, which the client
+// confirms with ConfirmLink.
+func (ctrl *OAuthController) Link(c *gin.Context) {
+
+	provider := getOAuthProvider(c, "provider")
+
+	if provider == nil {
+		return
+	}
+
+	var form OAuthLinkForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	if err := oauth.ValidateRedirectURI(form.RedirectURI); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	user := getAuthorizedUser(db.GetDb(), c)
+
+	if user == nil {
+		return
+	}
+
+	err, authURL := getOAuthAuthURL(provider, &oauthState{RedirectURI: form.RedirectURI, LinkUserID: user.ID})
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(gin.H{"url": authURL}, c)
+}
+
+// Identities lists the accounts of providers linked to the current user.
+func (ctrl *OAuthController) Identities(c *gin.Context) {
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	var userOAuths []models.UserOAuth
+
+	if err := dbi.Where("user_id = ?", user.ID).Order("id").Find(&userOAuths).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	identities := make([]OAuthIdentity, 0, len(userOAuths))
+
+	for _, userOAuth := range userOAuths {
+		identities = append(identities, OAuthIdentity{
+			Provider: oauth.GetProviderName(userOAuth.VendorType),
+			LinkedAt: userOAuth.CreatedAt,
+		})
+	}
+
+	Success(identities, c)
+}
+
+// Unlink removes the account of the provider from the current user,
+// it's refused if the user would have no way to sign in left.
+func (ctrl *OAuthController) Unlink(c *gin.Context) {
+
+	vendorType := oauth.GetVendorType(c.Param("provider"))
+
+	if vendorType == 0 {
+		ErrorNotFound(errors.New("oauth provider not found"), c)
+		return
+	}
+
+	tx := db.GetDb().Begin()
+
+	user := getAuthorizedUser(tx, c)
+
+	if user == nil {
+		tx.Rollback()
+		return
+	}
+
+	var userOAuths []models.UserOAuth
+
+	if err := db.ForUpdate(tx).Where("user_id = ?", user.ID).Find(&userOAuths).Error; err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	var unlinked *models.UserOAuth
+
+	for i := range userOAuths {
+		if userOAuths[i].VendorType == vendorType {
+			unlinked = &userOAuths[i]
+		}
+	}
+
+	if unlinked == nil {
+		tx.Rollback()
+		ErrorNotFound(errors.New("oauth account not linked"), c)
+		return
+	}
+
+	if user.Password == "" && len(userOAuths) == 1 {
+		tx.Rollback()
+		Error("the only login method of the user can't be unlinked", c)
+		return
+	}
+
+	if err := tx.Delete(unlinked).Error; err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
 
-	// TODO: The redirect URL must be pre-registered ones to avoid attack
+	tx.Commit()
 
-	c.Redirect(301, redirectUri+"?token="+accessToken.Token)
+	Success(nil, c)
 }
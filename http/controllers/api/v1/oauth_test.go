@@ -0,0 +1,387 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/http/controllers/api/v1"
+	"github.com/primasio/wormhole/http/oauth"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/util"
+)
+
+// stubProvider signs in the account of the id given as the code
+type stubProvider struct {
+	verifier string
+}
+
+func (p *stubProvider) Name() string {
+	return "stub"
+}
+
+func (p *stubProvider) AuthCodeURL(state, verifier string) string {
+	p.verifier = verifier
+	return "https://provider.example.com/authorize?state=" + url.QueryEscape(state)
+}
+
+func (p *stubProvider) Exchange(ctx context.Context, code, verifier string) (*oauth.OAuthResult, error) {
+	if code == "invalid" || verifier != p.verifier {
+		return nil, errors.New("invalid code")
+	}
+
+	return &oauth.OAuthResult{Type: models.OAuthGitHub, Id: code, Name: "Stub User"}, nil
+}
+
+func oauthRequest(path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", path, nil)
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func oauthState(t *testing.T, redirectURI string) string {
+	return oauthStateWithQuery(t, url.Values{"redirect_uri": {redirectURI}})
+}
+
+func oauthStateWithQuery(t *testing.T, query url.Values) string {
+	w := oauthRequest("/v1/oauth/stub?" + query.Encode())
+	assert.Equal(t, w.Code, 302)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, location.Host, "provider.example.com")
+
+	return location.Query().Get("state")
+}
+
+// oauthCallback follows the callback of the state and returns the redirect location
+func oauthCallback(t *testing.T, state, code string) *url.URL {
+	w := oauthRequest("/v1/oauth/callback/stub?code=" + code + "&state=" + url.QueryEscape(state))
+	assert.Equal(t, w.Code, 302)
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert.Equal(t, err, nil)
+
+	return location
+}
+
+// fragmentToken returns the access token in the fragment of the location
+func fragmentToken(t *testing.T, location *url.URL) string {
+	params, err := url.ParseQuery(location.Fragment)
+	assert.Equal(t, err, nil)
+
+	return params.Get("token")
+}
+
+// oauthLink starts linking the stub provider and returns the state
+func oauthLink(t *testing.T, authorization string) string {
+	w := userRequest("POST", "/v1/users/oauth/stub", "redirect_uri=https%3A%2F%2Fwormhole.im%2Fsettings", "application/x-www-form-urlencoded", authorization)
+	assert.Equal(t, w.Code, 200)
+
+	var returnData struct {
+		Data struct {
+			URL string `json:"url"`
+		} `json:"data"`
+	}
+
+	assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &returnData), nil)
+
+	location, err := url.Parse(returnData.Data.URL)
+	assert.Equal(t, err, nil)
+
+	return location.Query().Get("state")
+}
+
+// confirmLink follows the callback of the state and confirms the returned link code
+func confirmLink(t *testing.T, state, code, authorization string) *httptest.ResponseRecorder {
+	location := oauthCallback(t, state, code)
+
+	linkCode := location.Query().Get("link_code")
+	assert.Equal(t, linkCode == "", false)
+
+	return userRequest("POST", "/v1/users/oauth/stub/confirmation", "code="+url.QueryEscape(linkCode), "application/x-www-form-urlencoded", authorization)
+}
+
+func listIdentities(t *testing.T, authorization string) []v1.OAuthIdentity {
+	w := sessionRequest("GET", "/v1/users/oauth", authorization)
+	assert.Equal(t, w.Code, 200)
+
+	var returnData struct {
+		Data []v1.OAuthIdentity `json:"data"`
+	}
+
+	assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &returnData), nil)
+
+	return returnData.Data
+}
+
+func TestOAuthController_Callback(t *testing.T) {
+	id := util.RandString(16)
+	oauth.RegisterProvider(&stubProvider{})
+
+	assert.Equal(t, oauthRequest("/v1/oauth/unknown?redirect_uri=https://wormhole.im").Code, 404)
+	assert.Equal(t, oauthRequest("/v1/oauth/invalid/stub").Code, 404)
+
+	// Sign in and come back to the redirect uri
+
+	state := oauthState(t, "https://wormhole.im/signin?from=oauth")
+
+	location := oauthCallback(t, state, id)
+	assert.Equal(t, location.Path, "/signin")
+	assert.Equal(t, location.Query().Get("from"), "oauth")
+	assert.Equal(t, sessionRequest("GET", "/v1/users", fragmentToken(t, location)).Code, 200)
+
+	// The state is single-use
+
+	assert.Equal(t, oauthRequest("/v1/oauth/callback/stub?code="+id+"&state="+url.QueryEscape(state)).Code, 401)
+
+	// Errors are passed back to the redirect uri
+
+	state = oauthState(t, "https://wormhole.im/signin")
+
+	w := oauthRequest("/v1/oauth/callback/stub?error=access_denied&state=" + url.QueryEscape(state))
+	assert.Equal(t, w.Code, 302)
+	assert.Equal(t, w.Header().Get("Location"), "https://wormhole.im/signin?error=access_denied")
+
+	state = oauthState(t, "https://wormhole.im/signin")
+
+	assert.Equal(t, oauthCallback(t, state, "invalid").String(), "https://wormhole.im/signin?error=server_error")
+}
+
+func TestOAuthController_Link(t *testing.T) {
+	oauth.RegisterProvider(&stubProvider{})
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	userToken := login(t, user, "laptop").Token
+	assert.Equal(t, len(listIdentities(t, userToken)), 0)
+
+	// Link an account and sign in with it
+
+	id := util.RandString(16)
+
+	assert.Equal(t, confirmLink(t, oauthLink(t, userToken), id, userToken).Code, 200)
+
+	identities := listIdentities(t, userToken)
+	assert.Equal(t, len(identities), 1)
+	assert.Equal(t, identities[0].Provider, oauth.ProviderGitHub)
+
+	location := oauthCallback(t, oauthState(t, "https://wormhole.im/signin"), id)
+
+	var profile struct {
+		Data v1.UserProfile `json:"data"`
+	}
+
+	w := sessionRequest("GET", "/v1/users", fragmentToken(t, location))
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &profile), nil)
+	assert.Equal(t, profile.Data.UniqueID, user.UniqueID)
+
+	// Accounts of other users and a second account of the provider can't be linked
+
+	other, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	otherToken := login(t, other, "laptop").Token
+
+	w = confirmLink(t, oauthLink(t, otherToken), id, otherToken)
+	assert.Equal(t, w.Code, 400)
+
+	_, message := parseResponse(t, w)
+	assert.Equal(t, message, oauth.ErrIdentityLinked.Error())
+
+	w = confirmLink(t, oauthLink(t, userToken), util.RandString(16), userToken)
+	assert.Equal(t, w.Code, 400)
+
+	_, message = parseResponse(t, w)
+	assert.Equal(t, message, oauth.ErrProviderLinked.Error())
+
+	// Links started by another user are not confirmed,
+	// so a link url can't be completed in someone else's browser
+
+	otherID := util.RandString(16)
+
+	assert.Equal(t, confirmLink(t, oauthLink(t, otherToken), otherID, userToken).Code, 401)
+	assert.Equal(t, len(listIdentities(t, otherToken)), 0)
+
+	// Unlink
+
+	assert.Equal(t, sessionRequest("DELETE", "/v1/users/oauth/unknown", userToken).Code, 404)
+	assert.Equal(t, sessionRequest("DELETE", "/v1/users/oauth/github", otherToken).Code, 404)
+	assert.Equal(t, sessionRequest("DELETE", "/v1/users/oauth/github", userToken).Code, 200)
+	assert.Equal(t, len(listIdentities(t, userToken)), 0)
+
+	// The only login method of an OAuth user stays
+
+	location = oauthCallback(t, oauthState(t, "https://wormhole.im/signin"), util.RandString(16))
+	oauthToken := fragmentToken(t, location)
+
+	assert.Equal(t, sessionRequest("DELETE", "/v1/users/oauth/github", oauthToken).Code, 400)
+	assert.Equal(t, len(listIdentities(t, oauthToken)), 1)
+}
+
+func TestOAuthController_RedirectURI(t *testing.T) {
+	oauth.RegisterProvider(&stubProvider{})
+
+	allowed := []string{
+		"https://wormhole.im",
+		"https://wormhole.im/",
+		"https://wormhole.im/signin?from=oauth",
+		"https://app.wormhole.im/oauth/callback",
+		"https://app.wormhole.im/oauth/?next=/",
+		"chrome-extension://abcdefghijklmnopabcdefghijklmnop/oauth.html",
+	}
+
+	for _, redirectURI := range allowed {
+		oauthState(t, redirectURI)
+	}
+
+	denied := []string{
+		"https://evil.com",
+		"https://wormhole.im.evil.com/signin",
+		"https://wormhole.im@evil.com/signin",
+		"http://wormhole.im/signin",
+		"https://wormhole.im/signin#token=",
+		"https://evil.com/.wormhole.im/oauth/callback",
+		"https://a.b.wormhole.im/oauth/callback",
+		"https://app.wormhole.im/signin",
+		"chrome-extension://abcdefghijklmnopabcdefghijklmnop/other.html",
+		"/signin",
+	}
+
+	for _, redirectURI := range denied {
+		w := oauthRequest("/v1/oauth/stub?redirect_uri=" + url.QueryEscape(redirectURI))
+		assert.Equal(t, w.Code, 400, redirectURI)
+	}
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	w := userRequest("POST", "/v1/users/oauth/stub", "redirect_uri=https%3A%2F%2Fevil.com", "application/x-www-form-urlencoded", login(t, user, "laptop").Token)
+	assert.Equal(t, w.Code, 400)
+}
+
+func exchangeCode(code, verifier string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("code", code)
+	form.Set("code_verifier", verifier)
+
+	return userRequest("POST", "/v1/oauth/token", form.Encode(), "application/x-www-form-urlencoded", "")
+}
+
+func TestOAuthController_Token(t *testing.T) {
+	oauth.RegisterProvider(&stubProvider{})
+
+	redirectURI := "chrome-extension://abcdefghijklmnopabcdefghijklmnop/oauth.html"
+	verifier := util.RandString(64)
+
+	query := url.Values{}
+	query.Set("redirect_uri", redirectURI)
+	query.Set("code_challenge", oauth.CodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	// Only S256 challenges are accepted, and only with codes
+
+	invalid := url.Values{"redirect_uri": {redirectURI}, "code_challenge": {verifier}, "code_challenge_method": {"plain"}}
+	assert.Equal(t, oauthRequest("/v1/oauth/stub?"+invalid.Encode()).Code, 400)
+
+	invalid = url.Values{"redirect_uri": {redirectURI}, "code_challenge": {oauth.CodeChallenge(verifier)}, "code_challenge_method": {"S256"}, "response_type": {"token"}}
+	assert.Equal(t, oauthRequest("/v1/oauth/stub?"+invalid.Encode()).Code, 400)
+
+	// The code is returned instead of the token
+
+	location := oauthCallback(t, oauthStateWithQuery(t, query), util.RandString(16))
+	assert.Equal(t, location.Fragment, "")
+
+	code := location.Query().Get("code")
+	assert.Equal(t, code == "", false)
+
+	// A wrong verifier burns the code
+
+	assert.Equal(t, exchangeCode(code, util.RandString(64)).Code, 401)
+	assert.Equal(t, exchangeCode(code, verifier).Code, 401)
+
+	location = oauthCallback(t, oauthStateWithQuery(t, query), util.RandString(16))
+	code = location.Query().Get("code")
+
+	w := exchangeCode(code, verifier)
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", parseToken(t, w).Token).Code, 200)
+
+	assert.Equal(t, exchangeCode(code, verifier).Code, 401)
+
+	// Codes without a challenge are exchanged without a verifier
+
+	location = oauthCallback(t, oauthStateWithQuery(t, url.Values{"redirect_uri": {"https://wormhole.im"}, "response_type": {"code"}}), util.RandString(16))
+	assert.Equal(t, exchangeCode(location.Query().Get("code"), "").Code, 200)
+}
+
+func TestOAuthController_TwoFactor(t *testing.T) {
+	id := util.RandString(16)
+	oauth.RegisterProvider(&stubProvider{})
+
+	userToken := fragmentToken(t, oauthCallback(t, oauthState(t, "https://wormhole.im"), id))
+
+	w := userRequest("POST", "/v1/users/2fa", "", "application/x-www-form-urlencoded", userToken)
+	assert.Equal(t, w.Code, 200)
+
+	enrollment := &v1.TwoFactorEnrollment{}
+	parseData(t, w, enrollment)
+
+	w = twoFactorRequest("POST", "/v1/users/2fa/confirmation", totpCode(t, enrollment.Secret, 0), userToken)
+	assert.Equal(t, w.Code, 200)
+
+	var recovery struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	parseData(t, w, &recovery)
+
+	// Sign in with the token in the fragment needs the second step
+
+	params, err := url.ParseQuery(oauthCallback(t, oauthState(t, "https://wormhole.im"), id).Fragment)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, params.Get("token"), "")
+	assert.Equal(t, params.Get("two_factor_required"), "true")
+
+	w = redeemChallenge(params.Get("challenge_token"), totpCode(t, enrollment.Secret, 1))
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", parseToken(t, w).Token).Code, 200)
+
+	// And so does the exchange of a code
+
+	location := oauthCallback(t, oauthStateWithQuery(t, url.Values{"redirect_uri": {"https://wormhole.im"}, "response_type": {"code"}}), id)
+
+	w = exchangeCode(location.Query().Get("code"), "")
+	assert.Equal(t, w.Code, 200)
+
+	challenge := &v1.TwoFactorChallenge{}
+	parseData(t, w, challenge)
+
+	assert.Equal(t, challenge.TwoFactorRequired, true)
+	assert.Equal(t, redeemChallenge(challenge.ChallengeToken, recovery.RecoveryCodes[0]).Code, 200)
+}
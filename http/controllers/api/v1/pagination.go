@@ -0,0 +1,48 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/util"
+)
+
+// getCursor returns the cursor of the request if cursor pagination is used.
+// Listings switch to cursor pagination when the cursor query param is present,
+// an empty cursor stands for the first page.
+func getCursor(c *gin.Context) (*util.Cursor, error) {
+
+	cursorStr, exists := c.GetQuery("cursor")
+
+	if !exists {
+		return nil, nil
+	}
+
+	return util.ParseCursor(cursorStr)
+}
+
+// applyCursor limits the query to the page after (or before) the cursor.
+// One more item than the page size is fetched to tell whether there are more.
+func applyCursor(query *gorm.DB, cursor *util.Cursor, prefix string, pageSize uint) *gorm.DB {
+
+	if sql, args := cursor.Condition(prefix); sql != "" {
+		query = query.Where(sql, args...)
+	}
+
+	return query.Order(cursor.Order(prefix)).Limit(pageSize + 1)
+}
@@ -39,6 +39,21 @@ func ErrorUnauthorized(msg string, c *gin.Context) {
 	c.JSON(http.StatusUnauthorized, gin.H{"success": false, "message": msg})
 }
 
+func ErrorForbidden(msg string, c *gin.Context) {
+	c.JSON(http.StatusForbidden, gin.H{"success": false, "message": msg})
+}
+
 func ErrorNotFound(err error, c *gin.Context) {
 	c.JSON(http.StatusNotFound, gin.H{"success": false, "message": err.Error()})
 }
+
+// Error codes for the clients to handle the errors
+const (
+	ErrCodeDomainNotApproved = "DOMAIN_NOT_APPROVED"
+	ErrCodeLoginLocked       = "LOGIN_LOCKED"
+	ErrCodeCaptchaRequired   = "CAPTCHA_REQUIRED"
+)
+
+func ErrorWithCode(status int, code string, msg string, data interface{}, c *gin.Context) {
+	c.JSON(status, gin.H{"success": false, "code": code, "message": msg, "data": data})
+}
@@ -0,0 +1,183 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/http/middlewares"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
+	"github.com/primasio/wormhole/util"
+)
+
+type RoleController struct{}
+
+type RoleForm struct {
+	UserID string `form:"user_id" json:"user_id" binding:"required"`
+	Role   string `form:"role" json:"role"`
+}
+
+type RoleListForm struct {
+	Page     uint `form:"page,omitempty"`
+	PageSize uint `form:"page_size,omitempty"`
+}
+
+// RoleUser is the public profile of a user with the role and its permissions.
+type RoleUser struct {
+	*PublicUser
+	Role        string   `json:"role"`
+	Permissions []string `json:"permissions"`
+}
+
+func NewRoleUser(user *models.User) *RoleUser {
+	return &RoleUser{
+		PublicUser:  NewPublicUser(user),
+		Role:        user.GetRole(),
+		Permissions: models.GetRolePermissions(user.Role),
+	}
+}
+
+// List returns the users with roles other than the default one.
+func (ctrl *RoleController) List(c *gin.Context) {
+	var form RoleListForm
+
+	if err := c.ShouldBindQuery(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	query := db.GetDb().Model(&models.User{}).
+		Where("role IN (?) AND is_deleted = ?", []string{models.UserRoleModerator, models.UserRoleAdmin}, false)
+
+	var count uint
+
+	if err := query.Count(&count).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	page, pageSize := util.PurePageArgs(form.Page, form.PageSize)
+
+	if !util.CanPaginate(page, pageSize, count) {
+		Success(util.EmptyPagination(page, pageSize), c)
+		return
+	}
+
+	users := make([]*models.User, 0)
+
+	offset := (page - 1) * pageSize
+
+	if err := query.Order("id ASC").Offset(offset).Limit(pageSize).Find(&users).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	data := make([]*RoleUser, len(users))
+
+	for i, user := range users {
+		data[i] = NewRoleUser(user)
+	}
+
+	Success(util.Paginate(page, pageSize, count, data), c)
+}
+
+// Grant gives the role to the user.
+func (ctrl *RoleController) Grant(c *gin.Context) {
+	var form RoleForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	role, err := models.CleanRole(form.Role)
+
+	if err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	setRole(form.UserID, role, models.AuditActionRoleGrant, c)
+}
+
+// Revoke takes the role of the user back to the default one.
+func (ctrl *RoleController) Revoke(c *gin.Context) {
+	var form RoleForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	setRole(form.UserID, models.UserRoleUser, models.AuditActionRoleRevoke, c)
+}
+
+func setRole(userID, role, action string, c *gin.Context) {
+
+	tx := db.GetDb().Begin()
+
+	user := &models.User{}
+	db.ForUpdate(tx).Where("unique_id = ?", userID).First(user)
+
+	if user.ID == 0 || user.IsDeleted {
+		tx.Rollback()
+		ErrorNotFound(errors.New("user not found"), c)
+		return
+	}
+
+	// Admins can't lock themselves out, another admin or the admin key is needed
+	operator := "admin key"
+
+	if operatorId, exists := c.Get(middlewares.AuthorizedUserId); exists {
+		if operatorId.(uint) == user.ID {
+			tx.Rollback()
+			ErrorForbidden("can't change the role of yourself", c)
+			return
+		}
+
+		operator = "user " + strconv.FormatUint(uint64(operatorId.(uint)), 10)
+	}
+
+	previous := user.GetRole()
+
+	if previous != role {
+		if err := tx.Model(user).UpdateColumn("role", role).Error; err != nil {
+			tx.Rollback()
+			ErrorServer(err, c)
+			return
+		}
+
+		auditLog := newAuditLog(action, models.AuditTargetUser, user.UniqueID, c)
+
+		if err := service.GetAudit().Record(tx, auditLog, gin.H{"role": previous}, gin.H{"role": role}); err != nil {
+			tx.Rollback()
+			ErrorServer(err, c)
+			return
+		}
+
+		glog.Infof("role of user %d changed from %s to %s by %s", user.ID, previous, role, operator)
+	}
+
+	tx.Commit()
+
+	Success(NewRoleUser(user), c)
+}
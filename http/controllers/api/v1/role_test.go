@@ -0,0 +1,133 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/http/controllers/api/v1"
+	"github.com/primasio/wormhole/http/token"
+	"github.com/primasio/wormhole/models"
+)
+
+// prepareRoleUser creates a user with the role and returns it with a token
+func prepareRoleUser(t *testing.T, role string) (*models.User, string) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	if role != models.UserRoleUser {
+		assert.Equal(t, db.GetDb().Model(user).UpdateColumn("role", role).Error, nil)
+	}
+
+	err, userToken := token.IssueToken(user.ID, false)
+	assert.Equal(t, err, nil)
+
+	return user, userToken.Token
+}
+
+func TestRoleController(t *testing.T) {
+	adminKey := config.GetConfig().GetString("admin.key")
+
+	first, firstToken := prepareRoleUser(t, models.UserRoleUser)
+	second, secondToken := prepareRoleUser(t, models.UserRoleUser)
+
+	grant := func(user *models.User, role, authorization string) int {
+		form := url.Values{}
+		form.Set("user_id", user.UniqueID)
+		form.Set("role", role)
+
+		return userRequest("POST", "/v1/users/roles", form.Encode(), "application/x-www-form-urlencoded", authorization).Code
+	}
+
+	revoke := func(user *models.User, authorization string) int {
+		return userRequest("DELETE", "/v1/users/roles?user_id="+user.UniqueID, "", "", authorization).Code
+	}
+
+	// Users can't grant roles
+
+	assert.Equal(t, grant(second, models.UserRoleAdmin, firstToken), 403)
+	assert.Equal(t, grant(second, models.UserRoleAdmin, ""), 401)
+
+	// The admin key grants the first admin
+
+	assert.Equal(t, grant(first, "root", adminKey), 400)
+	assert.Equal(t, grant(&models.User{UniqueID: "unknown"}, models.UserRoleAdmin, adminKey), 404)
+	assert.Equal(t, grant(first, models.UserRoleAdmin, adminKey), 200)
+
+	// The key is refused once the bootstrap is turned off
+
+	config.GetConfig().Set("admin.bootstrap", false)
+	assert.Equal(t, grant(second, models.UserRoleModerator, adminKey), 401)
+	config.GetConfig().Set("admin.bootstrap", true)
+
+	// but nothing else
+
+	form := url.Values{}
+	form.Set("source_id", second.UniqueID)
+	form.Set("target_id", first.UniqueID)
+
+	assert.Equal(t, userRequest("POST", "/v1/users/merge", form.Encode(), "application/x-www-form-urlencoded", adminKey).Code, 403)
+
+	// Admins grant roles to others
+
+	assert.Equal(t, grant(second, models.UserRoleModerator, firstToken), 200)
+	assert.Equal(t, grant(first, models.UserRoleUser, firstToken), 403)
+
+	w := userRequest("GET", "/v1/users/roles?page_size=100", "", "", firstToken)
+	assert.Equal(t, w.Code, 200)
+
+	var page struct {
+		Data []*v1.RoleUser `json:"data"`
+	}
+
+	parseData(t, w, &page)
+
+	roles := make(map[string]*v1.RoleUser)
+
+	for _, user := range page.Data {
+		roles[user.ID] = user
+	}
+
+	assert.Equal(t, roles[first.UniqueID].Role, models.UserRoleAdmin)
+	assert.Equal(t, roles[second.UniqueID].Role, models.UserRoleModerator)
+	assert.Equal(t, roles[second.UniqueID].Permissions, []string{models.PermissionModerateComments, models.PermissionManageDomains})
+
+	// Moderators can't manage roles or users
+
+	assert.Equal(t, userRequest("GET", "/v1/users/roles", "", "", secondToken).Code, 403)
+	assert.Equal(t, userRequest("GET", "/v1/users/lockouts", "", "", secondToken).Code, 403)
+	assert.Equal(t, grant(first, models.UserRoleUser, secondToken), 403)
+
+	// Revoke
+
+	assert.Equal(t, revoke(second, firstToken), 200)
+
+	updated := &models.User{}
+	db.GetDb().Where("id = ?", second.ID).First(updated)
+	assert.Equal(t, updated.Role, models.UserRoleUser)
+
+	assert.Equal(t, userRequest("GET", "/v1/users/roles", "", "", secondToken).Code, 403)
+
+	// The admin key can take the role of an admin back
+
+	assert.Equal(t, revoke(first, adminKey), 200)
+	assert.Equal(t, userRequest("GET", "/v1/users/roles", "", "", firstToken).Code, 403)
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/primasio/wormhole/cache"
+	"github.com/primasio/wormhole/http/middlewares"
+	"github.com/primasio/wormhole/http/token"
+)
+
+type SessionController struct{}
+
+type RefreshForm struct {
+	RefreshToken string `form:"refresh_token" json:"refresh_token" binding:"required"`
+}
+
+type SessionItem struct {
+	ID         string `json:"id"`
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreatedAt  int64  `json:"created_at"`
+	LastSeenAt int64  `json:"last_seen_at"`
+	ExpiresAt  int64  `json:"expires_at"`
+	Current    bool   `json:"current"`
+}
+
+func getClient(device string, c *gin.Context) *token.Client {
+	return &token.Client{Device: device, IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+func (ctrl *SessionController) Refresh(c *gin.Context) {
+
+	var form RefreshForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	err, newToken := token.Refresh(form.RefreshToken, getClient("", c))
+
+	if err == token.ErrInvalidRefreshToken || err == token.ErrRefreshTokenReused {
+		ErrorUnauthorized(err.Error(), c)
+		return
+	}
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(newToken, c)
+}
+
+// Logout ends the current session, or all sessions of the user if "all" is set.
+func (ctrl *SessionController) Logout(c *gin.Context) {
+
+	userId, _ := c.Get(middlewares.AuthorizedUserId)
+	sessionId := c.GetString(middlewares.AuthorizedSessionId)
+
+	var err error
+
+	if c.PostForm("all") != "" || c.Query("all") != "" {
+		err = token.RevokeUserSessions(userId.(uint), "")
+
+		if err == nil {
+			err = token.RevokeAccessToken(c.Request.Header.Get("Authorization"))
+		}
+	} else if sessionId != "" {
+		err = token.RevokeSession(userId.(uint), sessionId)
+	} else {
+		// Tokens issued without session
+		err = token.RevokeAccessToken(c.Request.Header.Get("Authorization"))
+	}
+
+	if err != nil && err != cache.ErrSessionNotFound {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(nil, c)
+}
+
+func (ctrl *SessionController) List(c *gin.Context) {
+
+	userId, _ := c.Get(middlewares.AuthorizedUserId)
+	sessionId := c.GetString(middlewares.AuthorizedSessionId)
+
+	err, sessions := token.ListSessions(userId.(uint))
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	items := make([]SessionItem, len(sessions))
+
+	for i, session := range sessions {
+		items[i] = SessionItem{
+			ID:         session.ID,
+			Device:     session.Device,
+			IP:         session.IP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == sessionId,
+		}
+	}
+
+	Success(items, c)
+}
+
+func (ctrl *SessionController) Delete(c *gin.Context) {
+
+	userId, _ := c.Get(middlewares.AuthorizedUserId)
+
+	err := token.RevokeSession(userId.(uint), c.Param("session_id"))
+
+	if err == cache.ErrSessionNotFound {
+		ErrorNotFound(errors.New("session not found"), c)
+		return
+	}
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(nil, c)
+}
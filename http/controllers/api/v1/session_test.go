@@ -0,0 +1,302 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1_test
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/http/controllers/api/v1"
+	"github.com/primasio/wormhole/http/token"
+	"github.com/primasio/wormhole/models"
+)
+
+func login(t *testing.T, user *models.User, device string) *token.Token {
+	w := httptest.NewRecorder()
+
+	form := url.Values{}
+	form.Set("username", user.Username)
+	form.Set("password", "PrimasGoGoGo")
+	form.Set("remember", "on")
+	form.Set("device", device)
+
+	req, _ := http.NewRequest("POST", "/v1/users/auth", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+	assert.Equal(t, w.Code, 200)
+
+	return parseToken(t, w)
+}
+
+func parseToken(t *testing.T, w *httptest.ResponseRecorder) *token.Token {
+	var returnData map[string]*json.RawMessage
+
+	err := json.Unmarshal(w.Body.Bytes(), &returnData)
+	assert.Equal(t, err, nil)
+
+	tokenStruct := &token.Token{}
+
+	err = json.Unmarshal(*returnData["data"], tokenStruct)
+	assert.Equal(t, err, nil)
+
+	return tokenStruct
+}
+
+func refresh(refreshToken string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	form := url.Values{}
+	form.Set("refresh_token", refreshToken)
+
+	req, _ := http.NewRequest("POST", "/v1/users/token/refresh", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+
+	return w
+}
+
+func sessionRequest(method, path, authorization string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest(method, path, nil)
+	req.Header.Add("Authorization", authorization)
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+
+	return w
+}
+
+func listSessions(t *testing.T, authorization string) []v1.SessionItem {
+	w := sessionRequest("GET", "/v1/users/sessions", authorization)
+	assert.Equal(t, w.Code, 200)
+
+	var returnData map[string]*json.RawMessage
+
+	err := json.Unmarshal(w.Body.Bytes(), &returnData)
+	assert.Equal(t, err, nil)
+
+	var items []v1.SessionItem
+
+	err = json.Unmarshal(*returnData["data"], &items)
+	assert.Equal(t, err, nil)
+
+	return items
+}
+
+func TestSessionController_Refresh(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	first := login(t, user, "laptop")
+	assert.Equal(t, first.RefreshToken == "", false)
+
+	// Rotation issues new tokens and revokes the old access token
+
+	w := refresh(first.RefreshToken)
+	assert.Equal(t, w.Code, 200)
+
+	second := parseToken(t, w)
+	assert.Equal(t, second.SessionID, first.SessionID)
+	assert.Equal(t, second.RefreshToken == first.RefreshToken, false)
+
+	assert.Equal(t, sessionRequest("GET", "/v1/users", first.Token).Code, 401)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", second.Token).Code, 200)
+
+	// Unknown tokens are rejected without touching the session
+
+	assert.Equal(t, refresh(first.SessionID+".unknown").Code, 401)
+	assert.Equal(t, refresh("invalid").Code, 401)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", second.Token).Code, 200)
+
+	// Reusing a rotated refresh token revokes the session
+
+	assert.Equal(t, refresh(first.RefreshToken).Code, 401)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", second.Token).Code, 401)
+	assert.Equal(t, refresh(second.RefreshToken).Code, 401)
+}
+
+func TestSessionController_RefreshConcurrent(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	// The same refresh token is accepted once, the other
+	// presentation counts as reuse and revokes the session
+
+	for round := 0; round < 10; round++ {
+		first := login(t, user, "laptop")
+
+		codes := make([]int, 2)
+		tokens := make([]*token.Token, 2)
+
+		start := make(chan bool)
+
+		var wg sync.WaitGroup
+
+		for i := range codes {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+				<-start
+
+				w := refresh(first.RefreshToken)
+				codes[i] = w.Code
+
+				if w.Code == 200 {
+					tokens[i] = parseToken(t, w)
+				}
+			}(i)
+		}
+
+		close(start)
+		wg.Wait()
+
+		accepted := 0
+
+		for i, code := range codes {
+			if code == 200 {
+				accepted++
+				assert.Equal(t, sessionRequest("GET", "/v1/users", tokens[i].Token).Code, 401)
+			}
+		}
+
+		assert.Equal(t, accepted, 1)
+	}
+
+	// A refresh racing a revocation doesn't bring the session back
+
+	laptop := login(t, user, "laptop")
+
+	for round := 0; round < 10; round++ {
+		phone := login(t, user, "phone")
+
+		start := make(chan bool)
+
+		var wg sync.WaitGroup
+
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			<-start
+			refresh(phone.RefreshToken)
+		}()
+
+		go func() {
+			defer wg.Done()
+			<-start
+			sessionRequest("DELETE", "/v1/users/sessions/"+phone.SessionID, laptop.Token)
+		}()
+
+		close(start)
+		wg.Wait()
+
+		sessions := listSessions(t, laptop.Token)
+		assert.Equal(t, len(sessions), 1)
+		assert.Equal(t, sessions[0].ID, laptop.SessionID)
+	}
+}
+
+func TestSessionController_Sessions(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	laptop := login(t, user, "laptop")
+	phone := login(t, user, "phone")
+
+	sessions := listSessions(t, laptop.Token)
+	assert.Equal(t, len(sessions), 2)
+	assert.Equal(t, sessions[0].Device, "laptop")
+	assert.Equal(t, sessions[0].Current, true)
+	assert.Equal(t, sessions[1].Current, false)
+
+	// Revoke the phone from the laptop
+
+	assert.Equal(t, sessionRequest("DELETE", "/v1/users/sessions/"+phone.SessionID, laptop.Token).Code, 200)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", phone.Token).Code, 401)
+	assert.Equal(t, sessionRequest("DELETE", "/v1/users/sessions/"+phone.SessionID, laptop.Token).Code, 404)
+
+	// Sessions of other users can't be revoked
+
+	other, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	otherToken := login(t, other, "desktop")
+	assert.Equal(t, sessionRequest("DELETE", "/v1/users/sessions/"+laptop.SessionID, otherToken.Token).Code, 404)
+
+	// Logout
+
+	assert.Equal(t, sessionRequest("POST", "/v1/users/logout", laptop.Token).Code, 200)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", laptop.Token).Code, 401)
+	assert.Equal(t, refresh(laptop.RefreshToken).Code, 401)
+}
+
+func TestSessionController_LogoutEverywhere(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	tokens := []*token.Token{login(t, user, "a"), login(t, user, "b"), login(t, user, "c")}
+
+	assert.Equal(t, sessionRequest("POST", "/v1/users/logout?all=1", tokens[0].Token).Code, 200)
+
+	for _, tokenStruct := range tokens {
+		assert.Equal(t, sessionRequest("GET", "/v1/users", tokenStruct.Token).Code, 401)
+		assert.Equal(t, refresh(tokenStruct.RefreshToken).Code, 401)
+	}
+}
+
+func TestSessionController_JWT(t *testing.T) {
+	config.GetConfig().Set("token.mode", token.ModeJWT)
+	defer config.GetConfig().Set("token.mode", token.ModeSession)
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	tokenStruct := login(t, user, "a")
+	assert.Equal(t, token.IsJWT(tokenStruct.Token), true)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", tokenStruct.Token).Code, 200)
+
+	// The replaced access token is denied after refresh
+	w := refresh(tokenStruct.RefreshToken)
+	assert.Equal(t, w.Code, 200)
+
+	refreshed := parseToken(t, w)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", tokenStruct.Token).Code, 401)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", refreshed.Token).Code, 200)
+
+	assert.Equal(t, sessionRequest("POST", "/v1/users/logout", refreshed.Token).Code, 200)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", refreshed.Token).Code, 401)
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/http/token"
+	"github.com/primasio/wormhole/service"
+)
+
+type TwoFactorController struct{}
+
+type TwoFactorCodeForm struct {
+	Code string `form:"code" json:"code" binding:"required"`
+}
+
+type TwoFactorLoginForm struct {
+	ChallengeToken string `form:"challenge_token" json:"challenge_token" binding:"required"`
+	Code           string `form:"code" json:"code" binding:"required"`
+}
+
+// TwoFactorEnrollment is shown to the user to set up the authenticator
+type TwoFactorEnrollment struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+}
+
+// TwoFactorChallenge is returned by the password login of users with
+// the factor enabled instead of the token
+type TwoFactorChallenge struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int64  `json:"expires_in"`
+}
+
+// ErrorTwoFactor responds with the errors of service.TwoFactor
+func ErrorTwoFactor(err error, c *gin.Context) {
+	switch err {
+	case service.ErrInvalidTwoFactorCode, service.ErrTwoFactorEnabled, service.ErrTwoFactorNotEnabled, service.ErrTwoFactorNotEnrolled:
+		Error(err.Error(), c)
+	case service.ErrTooManyTwoFactorAttempts:
+		ErrorWithCode(http.StatusTooManyRequests, ErrCodeLoginLocked, err.Error(), nil, c)
+	default:
+		ErrorServer(err, c)
+	}
+}
+
+// Enroll starts the setup of the authenticator, an unconfirmed setup is replaced.
+func (ctrl *TwoFactorController) Enroll(c *gin.Context) {
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	err, secret, uri := service.GetTwoFactor().Enroll(dbi, user)
+
+	if err != nil {
+		ErrorTwoFactor(err, c)
+		return
+	}
+
+	Success(&TwoFactorEnrollment{Secret: secret, OTPAuthURI: uri}, c)
+}
+
+// Confirm enables the factor and returns the recovery codes.
+func (ctrl *TwoFactorController) Confirm(c *gin.Context) {
+
+	var form TwoFactorCodeForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	err, codes := service.GetTwoFactor().Confirm(dbi, user, form.Code, c.ClientIP())
+
+	if err != nil {
+		ErrorTwoFactor(err, c)
+		return
+	}
+
+	Success(gin.H{"recovery_codes": codes}, c)
+}
+
+func (ctrl *TwoFactorController) Disable(c *gin.Context) {
+
+	var form TwoFactorCodeForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	if err := service.GetTwoFactor().Disable(dbi, user, form.Code, c.ClientIP()); err != nil {
+		ErrorTwoFactor(err, c)
+		return
+	}
+
+	Success(nil, c)
+}
+
+// RecoveryCodes replaces the recovery codes, the old ones stop working.
+func (ctrl *TwoFactorController) RecoveryCodes(c *gin.Context) {
+
+	var form TwoFactorCodeForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	err, codes := service.GetTwoFactor().RegenerateRecoveryCodes(dbi, user, form.Code, c.ClientIP())
+
+	if err != nil {
+		ErrorTwoFactor(err, c)
+		return
+	}
+
+	Success(gin.H{"recovery_codes": codes}, c)
+}
+
+// Login is the second step of the password login, the challenge is
+// redeemed with a code from the authenticator or a recovery code.
+func (ctrl *TwoFactorController) Login(c *gin.Context) {
+
+	var form TwoFactorLoginForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	err, challenge, user := service.GetTwoFactor().RedeemChallenge(db.GetDb(), form.ChallengeToken, form.Code, c.ClientIP())
+
+	if err == service.ErrInvalidChallenge || err == service.ErrInvalidTwoFactorCode {
+		ErrorUnauthorized(err.Error(), c)
+		return
+	}
+
+	if err == service.ErrTooManyTwoFactorAttempts {
+		ErrorWithCode(http.StatusTooManyRequests, ErrCodeLoginLocked, err.Error(), nil, c)
+		return
+	}
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	err, accessToken := token.IssueSessionToken(user.ID, !challenge.Remember, getClient(challenge.Device, c))
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(accessToken, c)
+}
@@ -0,0 +1,272 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1_test
+
+import (
+	"encoding/base32"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/http/controllers/api/v1"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/util"
+)
+
+// totpCode returns the code of the step after the current one by the given steps
+func totpCode(t *testing.T, secret string, steps int64) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	assert.Equal(t, err, nil)
+
+	return util.HOTP(key, util.TOTPCounter(time.Now())+steps)
+}
+
+// twoFactorRequest sends the code as json, which is also read from DELETE requests
+func twoFactorRequest(method, path, code, authorization string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]string{"code": code})
+
+	return userRequest(method, path, string(body), "application/json", authorization)
+}
+
+func parseData(t *testing.T, w *httptest.ResponseRecorder, data interface{}) {
+	returnData := struct {
+		Data interface{} `json:"data"`
+	}{Data: data}
+
+	assert.Equal(t, json.Unmarshal(w.Body.Bytes(), &returnData), nil)
+}
+
+// loginChallenge runs the password step of a user with the factor enabled
+func loginChallenge(t *testing.T, user *models.User) string {
+	form := url.Values{}
+	form.Set("username", user.Username)
+	form.Set("password", "PrimasGoGoGo")
+	form.Set("remember", "on")
+
+	w := userRequest("POST", "/v1/users/auth", form.Encode(), "application/x-www-form-urlencoded", "")
+	assert.Equal(t, w.Code, 200)
+
+	challenge := &v1.TwoFactorChallenge{}
+	parseData(t, w, challenge)
+
+	assert.Equal(t, challenge.TwoFactorRequired, true)
+	assert.Equal(t, challenge.ChallengeToken == "", false)
+
+	return challenge.ChallengeToken
+}
+
+func redeemChallenge(challengeToken, code string) *httptest.ResponseRecorder {
+	form := url.Values{}
+	form.Set("challenge_token", challengeToken)
+	form.Set("code", code)
+
+	return userRequest("POST", "/v1/users/auth/2fa", form.Encode(), "application/x-www-form-urlencoded", "")
+}
+
+func TestTwoFactorController(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	userToken := login(t, user, "laptop").Token
+
+	// Enroll and confirm
+
+	assert.Equal(t, twoFactorRequest("POST", "/v1/users/2fa/confirmation", "123456", userToken).Code, 400)
+
+	w := userRequest("POST", "/v1/users/2fa", "", "application/x-www-form-urlencoded", userToken)
+	assert.Equal(t, w.Code, 200)
+
+	enrollment := &v1.TwoFactorEnrollment{}
+	parseData(t, w, enrollment)
+	assert.Equal(t, strings.HasPrefix(enrollment.OTPAuthURI, "otpauth://totp/Wormhole:"), true)
+
+	assert.Equal(t, twoFactorRequest("POST", "/v1/users/2fa/confirmation", totpCode(t, enrollment.Secret, 5), userToken).Code, 400)
+
+	w = twoFactorRequest("POST", "/v1/users/2fa/confirmation", totpCode(t, enrollment.Secret, 0), userToken)
+	assert.Equal(t, w.Code, 200)
+
+	var recovery struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	parseData(t, w, &recovery)
+	assert.Equal(t, len(recovery.RecoveryCodes), 10)
+
+	assert.Equal(t, userRequest("POST", "/v1/users/2fa", "", "application/x-www-form-urlencoded", userToken).Code, 400)
+
+	// Login needs the second step, the code of the confirmation can't be used again
+
+	challengeToken := loginChallenge(t, user)
+
+	assert.Equal(t, redeemChallenge(challengeToken, totpCode(t, enrollment.Secret, 0)).Code, 401)
+	assert.Equal(t, redeemChallenge("invalid", totpCode(t, enrollment.Secret, 1)).Code, 401)
+
+	w = redeemChallenge(challengeToken, totpCode(t, enrollment.Secret, 1))
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", parseToken(t, w).Token).Code, 200)
+
+	assert.Equal(t, redeemChallenge(challengeToken, recovery.RecoveryCodes[0]).Code, 401)
+
+	// Recovery codes work once, in upper case and without the dash as well
+
+	challengeToken = loginChallenge(t, user)
+	assert.Equal(t, redeemChallenge(challengeToken, strings.ToUpper(strings.Replace(recovery.RecoveryCodes[0], "-", "", 1))).Code, 200)
+
+	challengeToken = loginChallenge(t, user)
+	assert.Equal(t, redeemChallenge(challengeToken, recovery.RecoveryCodes[0]).Code, 401)
+	assert.Equal(t, redeemChallenge(challengeToken, recovery.RecoveryCodes[1]).Code, 200)
+
+	// New recovery codes replace the old ones
+
+	w = twoFactorRequest("POST", "/v1/users/2fa/recovery_codes", recovery.RecoveryCodes[2], userToken)
+	assert.Equal(t, w.Code, 200)
+
+	old := append([]string{}, recovery.RecoveryCodes...)
+	parseData(t, w, &recovery)
+	assert.Equal(t, len(recovery.RecoveryCodes), 10)
+
+	assert.Equal(t, twoFactorRequest("DELETE", "/v1/users/2fa", old[3], userToken).Code, 400)
+	assert.Equal(t, twoFactorRequest("DELETE", "/v1/users/2fa", recovery.RecoveryCodes[0], userToken).Code, 200)
+
+	// Login without the second step again
+
+	login(t, user, "laptop")
+}
+
+func TestTwoFactorController_LoginGuard(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	userToken := login(t, user, "").Token
+
+	w := userRequest("POST", "/v1/users/2fa", "", "application/x-www-form-urlencoded", userToken)
+	assert.Equal(t, w.Code, 200)
+
+	enrollment := &v1.TwoFactorEnrollment{}
+	parseData(t, w, enrollment)
+
+	assert.Equal(t, twoFactorRequest("POST", "/v1/users/2fa/confirmation", totpCode(t, enrollment.Secret, 0), userToken).Code, 200)
+
+	c := config.GetConfig()
+
+	c.Set("login_guard.backoff_after", 10)
+	c.Set("login_guard.lockout_after", 3)
+
+	defer func() {
+		c.Set("login_guard.backoff_after", 3)
+		c.Set("login_guard.lockout_after", 10)
+	}()
+
+	// Wrong codes count as failed logins of the username
+
+	challengeToken := loginChallenge(t, user)
+
+	assert.Equal(t, redeemChallenge(challengeToken, "000000").Code, 401)
+	assert.Equal(t, redeemChallenge(challengeToken, "000000").Code, 401)
+
+	form := url.Values{}
+	form.Set("username", user.Username)
+	form.Set("password", "PrimasGoGoGo")
+
+	assert.Equal(t, userRequest("POST", "/v1/users/auth", form.Encode(), "application/x-www-form-urlencoded", "").Code, 429)
+}
+
+func TestTwoFactorController_Attempts(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	userToken := login(t, user, "").Token
+
+	w := userRequest("POST", "/v1/users/2fa", "", "application/x-www-form-urlencoded", userToken)
+	assert.Equal(t, w.Code, 200)
+
+	enrollment := &v1.TwoFactorEnrollment{}
+	parseData(t, w, enrollment)
+
+	w = twoFactorRequest("POST", "/v1/users/2fa/confirmation", totpCode(t, enrollment.Secret, 0), userToken)
+	assert.Equal(t, w.Code, 200)
+
+	var recovery struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	parseData(t, w, &recovery)
+
+	c := config.GetConfig()
+
+	c.Set("login_guard.backoff_base", "1ms")
+	c.Set("login_guard.backoff_max", "1ms")
+
+	defer func() {
+		c.Set("login_guard.backoff_base", "1s")
+		c.Set("login_guard.backoff_max", "1m")
+	}()
+
+	// Codes are refused after too many wrong ones, new challenges don't allow more
+
+	challengeToken := loginChallenge(t, user)
+
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, redeemChallenge(challengeToken, "000000").Code, 401)
+	}
+
+	assert.Equal(t, redeemChallenge(challengeToken, recovery.RecoveryCodes[0]).Code, 401)
+
+	time.Sleep(time.Millisecond * 10)
+
+	challengeToken = loginChallenge(t, user)
+	assert.Equal(t, redeemChallenge(challengeToken, recovery.RecoveryCodes[0]).Code, 429)
+
+	// The codes of the signed in user are counted the same way,
+	// so a stolen access token can't guess the factor off
+
+	other, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	otherToken := login(t, other, "").Token
+
+	w = userRequest("POST", "/v1/users/2fa", "", "application/x-www-form-urlencoded", otherToken)
+	assert.Equal(t, w.Code, 200)
+
+	parseData(t, w, enrollment)
+
+	w = twoFactorRequest("POST", "/v1/users/2fa/confirmation", totpCode(t, enrollment.Secret, 0), otherToken)
+	assert.Equal(t, w.Code, 200)
+
+	parseData(t, w, &recovery)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, twoFactorRequest("DELETE", "/v1/users/2fa", "000000", otherToken).Code, 400)
+	}
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, twoFactorRequest("POST", "/v1/users/2fa/recovery_codes", "000000", otherToken).Code, 400)
+	}
+
+	assert.Equal(t, twoFactorRequest("DELETE", "/v1/users/2fa", recovery.RecoveryCodes[0], otherToken).Code, 429)
+	assert.Equal(t, twoFactorRequest("POST", "/v1/users/2fa/recovery_codes", recovery.RecoveryCodes[0], otherToken).Code, 429)
+
+	time.Sleep(time.Millisecond * 10)
+
+	challengeToken = loginChallenge(t, other)
+	assert.Equal(t, redeemChallenge(challengeToken, recovery.RecoveryCodes[0]).Code, 429)
+}
@@ -17,11 +17,14 @@
 package v1
 
 import (
+	"net/http"
+
 	"github.com/primasio/wormhole/util"
 
 	"github.com/gin-gonic/gin"
 	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
 )
 
 type URLContentController struct{}
@@ -31,6 +34,27 @@ type URLContentListForm struct {
 	PageSize uint `form:"page_size,omitempty" json:"page_size"`
 }
 
+// ErrorDomainCheck responds with the error of service.Domain.CheckURL,
+// clients are told which domain needs votes if it's not approved.
+func ErrorDomainCheck(err error, c *gin.Context) {
+
+	if notApproved, ok := err.(*service.DomainNotApprovedError); ok {
+		ErrorWithCode(http.StatusForbidden, ErrCodeDomainNotApproved, notApproved.Error(), gin.H{
+			"domain":     notApproved.Domain,
+			"registered": notApproved.Registered,
+			"votes":      notApproved.Votes,
+		}, c)
+		return
+	}
+
+	if err == service.ErrInvalidURLDomain {
+		Error(err.Error(), c)
+		return
+	}
+
+	ErrorServer(err, c)
+}
+
 func (ctrl *URLContentController) Get(c *gin.Context) {
 
 	url := c.Query("url")
@@ -46,10 +70,8 @@ func (ctrl *URLContentController) Get(c *gin.Context) {
 
 	cleanedUrl := models.CleanURL(url)
 
-	err, _ := models.ExtractDomainFromURL(cleanedUrl)
-
-	if err != nil {
-		Error(err.Error(), c)
+	if err := service.GetDomain().CheckURL(cleanedUrl, dbi); err != nil {
+		ErrorDomainCheck(err, c)
 		return
 	}
 
@@ -77,18 +99,46 @@ func (ctrl *URLContentController) List(c *gin.Context) {
 		return
 	}
 
-	dbi := db.GetDb()
+	cursor, err := getCursor(c)
 
-	count, err := models.GetURLContentCount(dbi)
 	if err != nil {
-		ErrorServer(err, c)
+		Error(err.Error(), c)
 		return
 	}
 
+	dbi := db.GetDb()
+
 	var data []*models.URLContent
 
 	page, pageSize := util.PurePageArgs(args.Page, args.PageSize)
 
+	if cursor != nil {
+		// Cursor pages are ordered by creation time
+		// so that new urls do not shift the pages
+
+		data = make([]*models.URLContent, 0)
+
+		if err := applyCursor(dbi.Model(&models.URLContent{}), cursor, "", pageSize).Find(&data).Error; err != nil {
+			ErrorServer(err, c)
+			return
+		}
+
+		keys := make([]util.Cursor, len(data))
+
+		for i, urlContent := range data {
+			keys[i] = util.Cursor{CreatedAt: urlContent.CreatedAt, ID: urlContent.ID}
+		}
+
+		Success(util.CursorPaginate(cursor, pageSize, keys, data), c)
+		return
+	}
+
+	count, err := models.GetURLContentCount(dbi)
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
 	if !util.CanPaginate(page, pageSize, count) {
 		Success(util.EmptyPagination(page, pageSize), c)
 		return
@@ -27,13 +27,15 @@ import (
 	"github.com/primasio/wormhole/http/middlewares"
 	"github.com/primasio/wormhole/models"
 	"github.com/primasio/wormhole/service"
+	"github.com/primasio/wormhole/util"
 )
 
 type URLContentCommentController struct{}
 
 type URLContentCommentForm struct {
-	URL     string `form:"url" json:"url" binding:"required"`
-	Content string `form:"content" json:"content" binding:"required"`
+	URL      string `form:"url" json:"url" binding:"required"`
+	Content  string `form:"content" json:"content" binding:"required"`
+	ParentID string `form:"parent_id" json:"parent_id"`
 }
 
 func (ctrl *URLContentCommentController) Create(c *gin.Context) {
@@ -43,10 +45,8 @@ func (ctrl *URLContentCommentController) Create(c *gin.Context) {
 		Error(err.Error(), c)
 	} else {
 
-		err, _ := models.ExtractDomainFromURL(form.URL)
-
-		if err != nil {
-			ErrorServer(err, c)
+		if err := service.GetDomain().CheckURL(form.URL, db.GetDb()); err != nil {
+			ErrorDomainCheck(err, c)
 			return
 		}
 
@@ -94,7 +94,43 @@ func (ctrl *URLContentCommentController) Create(c *gin.Context) {
 		comment.URLContentId = lockedUrlContent.ID
 		comment.Content = form.Content
 
-		if err := comment.SetUniqueID(tx); err != nil {
+		var parent *models.URLContentComment
+
+		if form.ParentID != "" {
+
+			// Reply to an existing comment
+
+			parent = &models.URLContentComment{}
+			tx.Where("unique_id = ?", form.ParentID).First(parent)
+
+			if parent.ID == 0 {
+				tx.Rollback()
+				ErrorNotFound(errors.New("parent comment not found"), c)
+				return
+			}
+
+			if parent.URLContentId != lockedUrlContent.ID {
+				tx.Rollback()
+				Error("parent comment does not belong to the url", c)
+				return
+			}
+
+			if parent.IsDeleted {
+				tx.Rollback()
+				Error("parent comment is deleted", c)
+				return
+			}
+
+			if parent.Depth+1 > service.GetURLContentComment().GetMaxReplyDepth() {
+				tx.Rollback()
+				Error("reply depth limit reached", c)
+				return
+			}
+
+			comment.SetParent(parent)
+		}
+
+		if err := comment.SetUniqueID(); err != nil {
 			tx.Rollback()
 			ErrorServer(err, c)
 			return
@@ -106,11 +142,101 @@ func (ctrl *URLContentCommentController) Create(c *gin.Context) {
 			return
 		}
 
+		if parent != nil {
+			if err := service.GetURLContentComment().AddReply(tx, parent); err != nil {
+				tx.Rollback()
+				ErrorServer(err, c)
+				return
+			}
+		}
+
 		tx.Commit()
 		Success(comment, c)
 	}
 }
 
+type URLContentCommentUpdateForm struct {
+	Content string `form:"content" json:"content" binding:"required"`
+}
+
+func (ctrl *URLContentCommentController) Update(c *gin.Context) {
+	var form URLContentCommentUpdateForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	commentId := c.Param("comment_id")
+	userId, _ := c.Get(middlewares.AuthorizedUserId)
+
+	tx := db.GetDb().Begin()
+
+	lockedComment := &models.URLContentComment{}
+	db.ForUpdate(tx).Where("unique_id = ?", commentId).First(lockedComment)
+
+	if lockedComment.ID == 0 || lockedComment.IsDeleted {
+		tx.Rollback()
+		ErrorNotFound(errors.New("comment not found"), c)
+		return
+	}
+
+	if lockedComment.UserID != userId.(uint) {
+		tx.Rollback()
+		ErrorForbidden("only the author can edit the comment", c)
+		return
+	}
+
+	if lockedComment.Content == form.Content {
+		tx.Rollback()
+		Success(lockedComment, c)
+		return
+	}
+
+	// Keep the previous version before editing
+
+	revision := lockedComment.Edit(form.Content)
+
+	if err := tx.Create(revision).Error; err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	if err := tx.Save(lockedComment).Error; err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	tx.Commit()
+	Success(lockedComment, c)
+}
+
+func (ctrl *URLContentCommentController) Revisions(c *gin.Context) {
+
+	commentId := c.Param("comment_id")
+
+	dbi := db.GetDb()
+
+	comment := &models.URLContentComment{}
+	dbi.Where("unique_id = ?", commentId).First(comment)
+
+	if comment.ID == 0 || comment.IsDeleted {
+		ErrorNotFound(errors.New("comment not found"), c)
+		return
+	}
+
+	revisions := make([]models.URLContentCommentRevision, 0)
+
+	if err := dbi.Where("url_content_comment_id = ?", comment.ID).Order("created_at DESC, id DESC").Find(&revisions).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(revisions, c)
+}
+
 func (ctrl *URLContentCommentController) Delete(c *gin.Context) {
 
 	commentId := c.Param("comment_id")
@@ -156,7 +282,35 @@ func (ctrl *URLContentCommentController) Delete(c *gin.Context) {
 		return
 	}
 
-	sql := "SELECT id, hash_key, total_comment FROM url_contents WHERE id = ?"
+	// Authors can delete their own comments,
+	// moderators can delete any comment with a reason
+
+	userId, _ := c.Get(middlewares.AuthorizedUserId)
+
+	operator := &models.User{}
+	tx.Where("id = ?", userId.(uint)).First(operator)
+
+	if operator.ID == 0 {
+		tx.Rollback()
+		ErrorUnauthorized("user not found", c)
+		return
+	}
+
+	if !comment.CanBeDeletedBy(operator) {
+		tx.Rollback()
+		ErrorForbidden("no permission to delete the comment", c)
+		return
+	}
+
+	reason := c.Query("reason")
+
+	if comment.UserID != operator.ID && reason == "" {
+		tx.Rollback()
+		Error("missing query param reason", c)
+		return
+	}
+
+	sql := "SELECT * FROM url_contents WHERE id = ?"
 
 	if db.GetDbType() != db.SQLITE {
 		sql = sql + " FOR UPDATE"
@@ -183,13 +337,36 @@ func (ctrl *URLContentCommentController) Delete(c *gin.Context) {
 		return
 	}
 
-	lockedComment.IsDeleted = true
+	if lockedComment.IsDeleted {
+		tx.Rollback()
+		Error("comment is already deleted", c)
+		return
+	}
+
+	// Replies are kept in place,
+	// the deleted comment is displayed as a tombstone while it has replies
+
+	lockedComment.MarkDeleted(operator, reason)
 	if err := tx.Save(lockedComment).Error; err != nil {
 		tx.Rollback()
 		ErrorServer(err, c)
 		return
 	}
 
+	// Integration earned through votes goes away with the comment
+
+	if err := service.GetURLContentCommentVote().ReverseCommentIntegration(tx, lockedComment); err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	if err := service.GetURLContentComment().RemoveReply(tx, lockedComment); err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
 	urlContent.TotalComment--
 
 	if err := tx.Save(&urlContent).Error; err != nil {
@@ -198,11 +375,58 @@ func (ctrl *URLContentCommentController) Delete(c *gin.Context) {
 		return
 	}
 
+	// Removals by moderators are kept for disputes
+
+	if lockedComment.UserID != operator.ID {
+		author := &models.User{}
+		tx.Where("id = ?", lockedComment.UserID).First(author)
+
+		before := &commentState{URL: urlContent.URL, AuthorID: author.UniqueID, Content: lockedComment.Content}
+
+		after := *before
+		after.IsDeleted = true
+		after.RemoveReason = reason
+
+		auditLog := newAuditLog(models.AuditActionCommentRemove, models.AuditTargetComment, lockedComment.UniqueID, c)
+
+		if err := service.GetAudit().Record(tx, auditLog, before, &after); err != nil {
+			tx.Rollback()
+			ErrorServer(err, c)
+			return
+		}
+	}
+
 	tx.Commit()
 
 	Success(nil, c)
 }
 
+// getCommentListOrder parses the sort mode and cursor of comment listings.
+// Cursor pagination only works with comments sorted by new,
+// since the ranking scores change over time.
+func getCommentListOrder(c *gin.Context, prefix string) (*util.Cursor, string, error) {
+
+	sort := c.Query("sort")
+
+	order, err := service.GetURLContentComment().GetSortOrder(sort, prefix)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	cursor, err := getCursor(c)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cursor != nil && sort != "" && sort != service.CommentSortNew {
+		return nil, "", errors.New("cursor pagination is only supported when sorting by new")
+	}
+
+	return cursor, order, nil
+}
+
 func (ctrl *URLContentCommentController) List(c *gin.Context) {
 
 	page, err := strconv.Atoi(c.Query("page"))
@@ -221,6 +445,13 @@ func (ctrl *URLContentCommentController) List(c *gin.Context) {
 		return
 	}
 
+	cursor, order, err := getCommentListOrder(c, "")
+
+	if err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
 	dbi := db.GetDb()
 	if err, urlContent := models.GetURLContentByURL(url, dbi, false); err != nil {
 		ErrorServer(err, c)
@@ -230,14 +461,64 @@ func (ctrl *URLContentCommentController) List(c *gin.Context) {
 		commentList := make([]models.URLContentComment, 0)
 
 		if urlContent != nil {
-			query := dbi.Where("url_content_id = ? AND is_deleted = 0", urlContent.ID)
-			query.Order("created_at DESC").Offset(offsetNum).Limit(pageSize).Preload("User").Find(&commentList)
+			// Only top level comments are listed, replies are loaded per thread
+			query := dbi.Where("url_content_id = ? AND parent_id = 0", urlContent.ID)
+			query = query.Where("is_deleted = 0 OR reply_count > 0")
+
+			if cursor != nil {
+				query = applyCursor(query, cursor, "", uint(pageSize))
+			} else {
+				query = query.Order(order).Offset(offsetNum).Limit(pageSize)
+			}
+
+			query.Preload("User").Find(&commentList)
+		}
+
+		for i := range commentList {
+			if commentList[i].IsDeleted {
+				commentList[i].Tombstone()
+			}
+		}
+
+		if cursor != nil {
+			keys := make([]util.Cursor, len(commentList))
+
+			for i, comment := range commentList {
+				keys[i] = util.Cursor{CreatedAt: comment.CreatedAt, ID: comment.ID}
+			}
+
+			Success(util.CursorPaginate(cursor, uint(pageSize), keys, commentList), c)
+			return
 		}
 
 		Success(commentList, c)
 	}
 }
 
+func (ctrl *URLContentCommentController) Replies(c *gin.Context) {
+
+	commentId := c.Param("comment_id")
+
+	dbi := db.GetDb()
+
+	comment := &models.URLContentComment{}
+	dbi.Where("unique_id = ?", commentId).Preload("User").First(comment)
+
+	if comment.ID == 0 || (comment.IsDeleted && !comment.IsTombstone()) {
+		ErrorNotFound(errors.New("comment not found"), c)
+		return
+	}
+
+	thread, err := service.GetURLContentComment().GetThread(dbi, comment)
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(thread, c)
+}
+
 func (ctrl *URLContentCommentController) ListWithVote(c *gin.Context) {
 	page, err := strconv.Atoi(c.Query("page"))
 
@@ -255,6 +536,13 @@ func (ctrl *URLContentCommentController) ListWithVote(c *gin.Context) {
 		return
 	}
 
+	cursor, order, err := getCommentListOrder(c, "url_content_comments.")
+
+	if err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
 	dbi := db.GetDb()
 	if err, urlContent := models.GetURLContentByURL(url, dbi, false); err != nil {
 		ErrorServer(err, c)
@@ -262,13 +550,25 @@ func (ctrl *URLContentCommentController) ListWithVote(c *gin.Context) {
 	} else {
 
 		if urlContent == nil {
-			Success(make([]interface{}, 0), c)
+			if cursor != nil {
+				Success(util.CursorPaginate(cursor, uint(pageSize), nil, make([]interface{}, 0)), c)
+			} else {
+				Success(make([]interface{}, 0), c)
+			}
 			return
 		}
 
 		userID, _ := c.Get(middlewares.AuthorizedUserId)
-		items := service.GetURLContentComment().ListWithVote(userID.(uint), urlContent, page, pageSize, offsetNum)
+		items := service.GetURLContentComment().ListWithVote(userID.(uint), urlContent, page, pageSize, offsetNum, order, cursor)
 
 		Success(items, c)
 	}
 }
+
+type commentState struct {
+	URL          string `json:"url"`
+	AuthorID     string `json:"author_id"`
+	Content      string `json:"content"`
+	IsDeleted    bool   `json:"is_deleted"`
+	RemoveReason string `json:"remove_reason,omitempty"`
+}
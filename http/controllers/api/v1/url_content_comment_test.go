@@ -17,6 +17,7 @@
 package v1_test
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
@@ -27,8 +28,12 @@ import (
 	"testing"
 
 	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/config"
 	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/http/controllers/api/v1"
+	"github.com/primasio/wormhole/http/token"
 	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
 	"github.com/primasio/wormhole/util"
 )
 
@@ -47,7 +52,7 @@ func PrepareURLContentComment(content *models.URLContent) (error, *models.URLCon
 		URLContentId: content.ID,
 	}
 
-	err := urlContentComment.SetUniqueID(dbi)
+	err := urlContentComment.SetUniqueID()
 
 	if err != nil {
 		return err, nil
@@ -73,7 +78,7 @@ func PrepareURLContentCommentWithContent(content *models.URLContent) (*models.UR
 		URLContentId: content.ID,
 	}
 
-	err := urlContentComment.SetUniqueID(dbi)
+	err := urlContentComment.SetUniqueID()
 
 	if err != nil {
 		return nil, err
@@ -219,3 +224,495 @@ func TestURLContentCommentController_Delete(t *testing.T) {
 	log.Println(w.Body.String())
 	assert.Equal(t, w2.Code, 200)
 }
+
+func postReply(urlStr, parentID string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	form := url.Values{}
+	form.Set("url", urlStr)
+	form.Set("content", "<p>The reply of a comment.</p>")
+	form.Set("parent_id", parentID)
+
+	req, _ := http.NewRequest("POST", "/v1/comments", strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+	req.Header.Add("Authorization", authToken)
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+
+	return w
+}
+
+func TestURLContentCommentController_Reply(t *testing.T) {
+	PrepareAuthToken(t)
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	err, comment := PrepareURLContentComment(urlContent)
+	assert.Equal(t, err, nil)
+
+	// Reply to the comment
+
+	w := postReply(urlContent.URL, comment.UniqueID)
+	assert.Equal(t, w.Code, 200)
+
+	dbi := db.GetDb()
+
+	reply := &models.URLContentComment{}
+	dbi.Where("parent_id = ?", comment.ID).First(reply)
+	assert.Equal(t, reply.RootID, comment.ID)
+	assert.Equal(t, reply.Depth, uint(1))
+
+	// Reply to the reply
+
+	w = postReply(urlContent.URL, reply.UniqueID)
+	assert.Equal(t, w.Code, 200)
+
+	root := &models.URLContentComment{}
+	dbi.Where("id = ?", comment.ID).First(root)
+	assert.Equal(t, root.ReplyCount, uint(1))
+	assert.Equal(t, root.ThreadReplyCount, uint(2))
+
+	// Reply to unknown comment
+
+	w = postReply(urlContent.URL, "NOTEXIST")
+	assert.Equal(t, w.Code, 404)
+
+	// Delete the first reply, the thread should be kept
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/v1/comments/"+reply.UniqueID, nil)
+	req.Header.Add("Authorization", authToken)
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 200)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/v1/comments/"+comment.UniqueID+"/replies", nil)
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+	assert.Equal(t, w.Code, 200)
+
+	var returnData map[string]*json.RawMessage
+
+	err = json.Unmarshal(w.Body.Bytes(), &returnData)
+	assert.Equal(t, err, nil)
+
+	var thread models.URLContentCommentThread
+
+	err = json.Unmarshal(*returnData["data"], &thread)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, len(thread.Replies), 1)
+	assert.Equal(t, thread.Replies[0].IsDeleted, true)
+	assert.Equal(t, thread.Replies[0].Content, "")
+	assert.Equal(t, len(thread.Replies[0].Replies), 1)
+
+	dbi.Where("id = ?", comment.ID).First(root)
+	assert.Equal(t, root.ReplyCount, uint(1))
+	assert.Equal(t, root.ThreadReplyCount, uint(1))
+}
+
+func TestURLContentCommentController_ReplyDepth(t *testing.T) {
+	PrepareAuthToken(t)
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	err, comment := PrepareURLContentComment(urlContent)
+	assert.Equal(t, err, nil)
+
+	comment.Depth = service.GetURLContentComment().GetMaxReplyDepth()
+	db.GetDb().Save(comment)
+
+	w := postReply(urlContent.URL, comment.UniqueID)
+	assert.Equal(t, w.Code, 400)
+}
+
+func TestURLContentCommentController_Update(t *testing.T) {
+	PrepareAuthToken(t)
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	err, comment := PrepareURLContentComment(urlContent)
+	assert.Equal(t, err, nil)
+
+	update := func(token string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+
+		form := url.Values{}
+		form.Set("content", "<p>Edited comment.</p>")
+
+		req, _ := http.NewRequest("PUT", "/v1/comments/"+comment.UniqueID, strings.NewReader(form.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+		req.Header.Add("Authorization", token)
+
+		router.ServeHTTP(w, req)
+
+		log.Println(w.Body.String())
+
+		return w
+	}
+
+	// Other users cannot edit the comment
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	err, userToken := token.IssueToken(user.ID, false)
+	assert.Equal(t, err, nil)
+
+	w := update(userToken.Token)
+	assert.Equal(t, w.Code, 403)
+
+	// Author edits the comment
+
+	w = update(authToken)
+	assert.Equal(t, w.Code, 200)
+
+	edited := &models.URLContentComment{}
+	db.GetDb().Where("id = ?", comment.ID).First(edited)
+	assert.Equal(t, edited.Content, "<p>Edited comment.</p>")
+	assert.Equal(t, edited.IsEdited, true)
+
+	// Same content does not create a new revision
+
+	w = update(authToken)
+	assert.Equal(t, w.Code, 200)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/comments/"+comment.UniqueID+"/revisions", nil)
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+	assert.Equal(t, w.Code, 200)
+
+	var returnData map[string]*json.RawMessage
+
+	err = json.Unmarshal(w.Body.Bytes(), &returnData)
+	assert.Equal(t, err, nil)
+
+	var revisions []models.URLContentCommentRevision
+
+	err = json.Unmarshal(*returnData["data"], &revisions)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, len(revisions), 1)
+	assert.Equal(t, revisions[0].Content, comment.Content)
+}
+
+func TestURLContentCommentController_DeletePermission(t *testing.T) {
+	PrepareAuthToken(t)
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	err, comment := PrepareURLContentComment(urlContent)
+	assert.Equal(t, err, nil)
+
+	deleteComment := func(token, reason string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/v1/comments/"+comment.UniqueID+"?reason="+url.QueryEscape(reason), nil)
+		req.Header.Add("Authorization", token)
+
+		router.ServeHTTP(w, req)
+
+		log.Println(w.Body.String())
+
+		return w
+	}
+
+	dbi := db.GetDb()
+
+	// Other users cannot delete the comment
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	err, userToken := token.IssueToken(user.ID, false)
+	assert.Equal(t, err, nil)
+
+	w := deleteComment(userToken.Token, "")
+	assert.Equal(t, w.Code, 403)
+
+	// Moderators must give a reason
+
+	admin, adminToken := prepareRoleUser(t, models.UserRoleModerator)
+
+	w = deleteComment(adminToken, "")
+	assert.Equal(t, w.Code, 400)
+
+	w = deleteComment(adminToken, "spam")
+	assert.Equal(t, w.Code, 200)
+
+	deleted := &models.URLContentComment{}
+	dbi.Where("id = ?", comment.ID).First(deleted)
+	assert.Equal(t, deleted.IsDeleted, true)
+	assert.Equal(t, deleted.RemovedByID, admin.ID)
+	assert.Equal(t, deleted.RemoveReason, "spam")
+}
+
+func TestURLContentCommentController_DeleteReverseIntegration(t *testing.T) {
+	PrepareAuthToken(t)
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	err, comment := PrepareURLContentComment(urlContent)
+	assert.Equal(t, err, nil)
+
+	dbi := db.GetDb()
+
+	owner := &models.User{}
+	dbi.Where("id = ?", systemUser.ID).First(owner)
+	before := owner.Integration
+
+	for i := 0; i < 2; i++ {
+		voter, err := PrepareTestUser()
+		assert.Equal(t, err, nil)
+
+		err = service.GetURLContentCommentVote().CreateVote(dbi, comment, voter, true)
+		assert.Equal(t, err, nil)
+	}
+
+	dbi.Where("id = ?", systemUser.ID).First(owner)
+	assert.Equal(t, owner.Integration, before+2*service.GetIntegration().GetURLContentCommentVoteScore(true))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/v1/comments/"+comment.UniqueID, nil)
+	req.Header.Add("Authorization", authToken)
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+	assert.Equal(t, w.Code, 200)
+
+	dbi.Where("id = ?", systemUser.ID).First(owner)
+	assert.Equal(t, owner.Integration, before)
+}
+
+func TestURLContentCommentController_ListCursor(t *testing.T) {
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	for i := 0; i < 30; i++ {
+		err, _ := PrepareURLContentComment(urlContent)
+		assert.Equal(t, err, nil)
+	}
+
+	listPage := func(cursor string) *util.CursorPagination {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/comments?url="+url.QueryEscape(urlContent.URL)+"&cursor="+cursor, nil)
+
+		router.ServeHTTP(w, req)
+
+		log.Println(w.Body.String())
+		assert.Equal(t, w.Code, 200)
+
+		var returnData map[string]*json.RawMessage
+
+		err := json.Unmarshal(w.Body.Bytes(), &returnData)
+		assert.Equal(t, err, nil)
+
+		var comments []models.URLContentComment
+
+		page := &util.CursorPagination{Data: &comments}
+
+		err = json.Unmarshal(*returnData["data"], page)
+		assert.Equal(t, err, nil)
+
+		return page
+	}
+
+	first := listPage("")
+	assert.Equal(t, len(*first.Data.(*[]models.URLContentComment)), 20)
+	assert.Equal(t, first.PrevCursor, "")
+
+	// A new comment must not shift the next page
+
+	err, _ = PrepareURLContentComment(urlContent)
+	assert.Equal(t, err, nil)
+
+	second := listPage(first.NextCursor)
+	assert.Equal(t, len(*second.Data.(*[]models.URLContentComment)), 10)
+	assert.Equal(t, second.NextCursor, "")
+
+	seen := make(map[string]bool)
+
+	for _, comment := range *first.Data.(*[]models.URLContentComment) {
+		seen[comment.UniqueID] = true
+	}
+
+	for _, comment := range *second.Data.(*[]models.URLContentComment) {
+		assert.Equal(t, seen[comment.UniqueID], false)
+	}
+
+	// Going back returns the first page
+
+	back := listPage(second.PrevCursor)
+	backComments := *back.Data.(*[]models.URLContentComment)
+	assert.Equal(t, len(backComments), 20)
+	assert.Equal(t, backComments[0].UniqueID, (*first.Data.(*[]models.URLContentComment))[0].UniqueID)
+
+	// Invalid cursor
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/comments?url="+url.QueryEscape(urlContent.URL)+"&cursor=invalid", nil)
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 400)
+}
+
+func TestURLContentCommentController_ListSort(t *testing.T) {
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	votes := [][2]uint{{1, 0}, {10, 2}, {5, 5}, {0, 3}}
+	comments := make([]*models.URLContentComment, len(votes))
+
+	for i, v := range votes {
+		err, comment := PrepareURLContentComment(urlContent)
+		assert.Equal(t, err, nil)
+
+		comment.CommentUpVotes = v[0]
+		comment.CommentDownVotes = v[1]
+		comment.UpdateScores()
+
+		db.GetDb().Save(comment)
+		comments[i] = comment
+	}
+
+	listSorted := func(sort string) []models.URLContentComment {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/v1/comments?url="+url.QueryEscape(urlContent.URL)+"&sort="+sort, nil)
+
+		router.ServeHTTP(w, req)
+
+		log.Println(w.Body.String())
+		assert.Equal(t, w.Code, 200)
+
+		var returnData map[string]*json.RawMessage
+
+		err := json.Unmarshal(w.Body.Bytes(), &returnData)
+		assert.Equal(t, err, nil)
+
+		var list []models.URLContentComment
+
+		err = json.Unmarshal(*returnData["data"], &list)
+		assert.Equal(t, err, nil)
+
+		return list
+	}
+
+	top := listSorted("top")
+	assert.Equal(t, top[0].UniqueID, comments[1].UniqueID)
+	assert.Equal(t, top[0].Score, int64(8))
+	assert.Equal(t, top[len(top)-1].UniqueID, comments[3].UniqueID)
+
+	best := listSorted("best")
+	assert.Equal(t, best[0].UniqueID, comments[1].UniqueID)
+
+	controversial := listSorted("controversial")
+	assert.Equal(t, controversial[0].UniqueID, comments[2].UniqueID)
+
+	// Invalid sort and cursor with ranking sort
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/comments?url="+url.QueryEscape(urlContent.URL)+"&sort=random", nil)
+
+	router.ServeHTTP(w, req)
+	assert.Equal(t, w.Code, 400)
+
+	w2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/v1/comments?url="+url.QueryEscape(urlContent.URL)+"&sort=top&cursor=", nil)
+
+	router.ServeHTTP(w2, req2)
+	assert.Equal(t, w2.Code, 400)
+}
+
+func TestURLContentCommentController_CreateDomainWhitelist(t *testing.T) {
+	PrepareAuthToken(t)
+
+	err, domain := PrepareDomain()
+	assert.Equal(t, err, nil)
+
+	domain.IsActive = false
+	db.GetDb().Save(domain)
+
+	postComment := func(urlStr string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+
+		form := url.Values{}
+		form.Set("url", urlStr)
+		form.Set("content", "<p>The comment of a URL.</p>")
+
+		req, _ := http.NewRequest("POST", "/v1/comments", strings.NewReader(form.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+		req.Header.Add("Authorization", authToken)
+
+		router.ServeHTTP(w, req)
+
+		log.Println(w.Body.String())
+
+		return w
+	}
+
+	parseError := func(w *httptest.ResponseRecorder) (string, map[string]interface{}) {
+		var returnData struct {
+			Code string                 `json:"code"`
+			Data map[string]interface{} `json:"data"`
+		}
+
+		err := json.Unmarshal(w.Body.Bytes(), &returnData)
+		assert.Equal(t, err, nil)
+
+		return returnData.Code, returnData.Data
+	}
+
+	// Registered domain waiting for votes
+
+	w := postComment("https://www." + domain.Domain + "/page")
+	assert.Equal(t, w.Code, 403)
+
+	code, data := parseError(w)
+	assert.Equal(t, code, v1.ErrCodeDomainNotApproved)
+	assert.Equal(t, data["domain"], models.CleanDomain(domain.Domain))
+	assert.Equal(t, data["registered"], true)
+
+	// Unknown domain
+
+	w = postComment("https://unknown" + util.RandStringUppercase(8) + ".io/page")
+	assert.Equal(t, w.Code, 403)
+
+	code, data = parseError(w)
+	assert.Equal(t, code, v1.ErrCodeDomainNotApproved)
+	assert.Equal(t, data["registered"], false)
+
+	// Open mode accepts any domain
+
+	config.GetConfig().Set("comment.domain_mode", service.DomainModeOpen)
+
+	w = postComment("https://unknown" + util.RandStringUppercase(8) + ".io/page")
+	assert.Equal(t, w.Code, 200)
+
+	config.GetConfig().Set("comment.domain_mode", service.DomainModeWhitelist)
+
+	// Approved domain
+
+	domain.IsActive = true
+	db.GetDb().Save(domain)
+
+	w = postComment("https://" + domain.Domain + "/page")
+	assert.Equal(t, w.Code, 200)
+}
@@ -17,11 +17,23 @@
 package v1
 
 import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+
 	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
 	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/http/captcha"
 	"github.com/primasio/wormhole/http/middlewares"
 	"github.com/primasio/wormhole/http/token"
 	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
+	"github.com/primasio/wormhole/util"
 )
 
 type UserController struct{}
@@ -30,12 +42,76 @@ type LoginForm struct {
 	Username string `form:"username" json:"username" binding:"required"`
 	Password string `form:"password" json:"password" binding:"required"`
 	Remember string `form:"remember" json:"remember"`
+	Device   string `form:"device" json:"device"`
+
+	// reCAPTCHA token, required after a few failed logins
+	Captcha string `form:"captcha" json:"captcha"`
 }
 
 type RegisterForm struct {
 	Username string `form:"username" json:"username" binding:"required"`
 	Password string `form:"password" json:"password" binding:"required"`
 	Nickname string `form:"nickname" json:"nickname" binding:"required"`
+	Email    string `form:"email" json:"email"`
+}
+
+type EmailForm struct {
+	Email string `form:"email" json:"email" binding:"required"`
+}
+
+type EmailVerificationForm struct {
+	Token string `form:"token" json:"token" binding:"required"`
+}
+
+type ResetPasswordForm struct {
+	Token    string `form:"token" json:"token" binding:"required"`
+	Password string `form:"password" json:"password" binding:"required"`
+}
+
+type ProfileForm struct {
+	Nickname  string `form:"nickname" json:"nickname"`
+	AvatarURL string `form:"avatar_url" json:"avatar_url"`
+}
+
+type ChangePasswordForm struct {
+	CurrentPassword string `form:"current_password" json:"current_password" binding:"required"`
+	NewPassword     string `form:"new_password" json:"new_password" binding:"required"`
+}
+
+type DeleteUserForm struct {
+	Password string `form:"password" json:"password"`
+}
+
+type MergeUserForm struct {
+	SourceID string `form:"source_id" json:"source_id" binding:"required"`
+	TargetID string `form:"target_id" json:"target_id" binding:"required"`
+}
+
+type IntegrationAdjustForm struct {
+	UserID string `form:"user_id" json:"user_id" binding:"required"`
+	Score  int64  `form:"score" json:"score" binding:"required"`
+	Reason string `form:"reason" json:"reason" binding:"required"`
+}
+
+// UserProfile is the user as seen by the user, with the private fields
+type UserProfile struct {
+	*models.User
+	Email            string   `json:"email"`
+	EmailVerified    bool     `json:"email_verified"`
+	TwoFactorEnabled bool     `json:"two_factor_enabled"`
+	Role             string   `json:"role"`
+	Permissions      []string `json:"permissions"`
+}
+
+func NewUserProfile(user *models.User) *UserProfile {
+	return &UserProfile{
+		User:             user,
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerified,
+		TwoFactorEnabled: user.TOTPEnabled,
+		Role:             user.GetRole(),
+		Permissions:      models.GetRolePermissions(user.Role),
+	}
 }
 
 func (ctrl *UserController) Create(c *gin.Context) {
@@ -64,16 +140,37 @@ func (ctrl *UserController) Create(c *gin.Context) {
 		user := &models.User{}
 		user.Username = form.Username
 		user.Password = form.Password
-		user.Nickname = form.Nickname
 
-		if err := user.SetUniqueID(dbi); err != nil {
+		nickname, err := models.CleanNickname(form.Nickname)
+
+		if err != nil {
+			Error(err.Error(), c)
+			return
+		}
+
+		user.Nickname = nickname
+
+		if form.Email != "" {
+			if err := service.GetUser().SetEmail(dbi, user, form.Email); err != nil {
+				ErrorUserEmail(err, c)
+				return
+			}
+		}
+
+		if err := user.SetUniqueID(); err != nil {
 			ErrorServer(err, c)
+			return
 		}
 
 		dbi2 := dbi.Create(&user)
 
 		if dbi2.Error != nil {
 			ErrorServer(dbi2.Error, c)
+			return
+		}
+
+		if err := service.GetUser().SendVerification(user); err != nil {
+			glog.Error(err)
 		}
 
 		Success(user, c)
@@ -90,12 +187,461 @@ func (ctrl *UserController) Get(c *gin.Context) {
 	dbi := db.GetDb()
 	dbi.First(&user)
 
-	if user.CreatedAt == 0 {
+	if user.CreatedAt == 0 || user.IsDeleted {
+		Error("User not found", c)
+		return
+	}
+
+	Success(NewUserProfile(user), c)
+}
+
+// PublicUser is the profile of a user seen by others
+type PublicUser struct {
+	ID               string `json:"id"`
+	Nickname         string `json:"nickname"`
+	AvatarURL        string `json:"avatar_url"`
+	Integration      int64  `json:"integration"`
+	CommentUpVotes   uint   `json:"comment_up_votes"`
+	CommentDownVotes uint   `json:"comment_down_votes"`
+	CreatedAt        uint   `json:"created_at"`
+}
+
+type UserCommentListForm struct {
+	Page     uint `form:"page,omitempty" json:"page"`
+	PageSize uint `form:"page_size,omitempty" json:"page_size"`
+}
+
+// NewPublicUser returns the profile of the user shown to others.
+func NewPublicUser(user *models.User) *PublicUser {
+	return &PublicUser{
+		ID:               user.UniqueID,
+		Nickname:         user.Nickname,
+		AvatarURL:        user.AvatarURL,
+		Integration:      user.Integration,
+		CommentUpVotes:   user.CommentUpVotes,
+		CommentDownVotes: user.CommentDownVotes,
+		CreatedAt:        user.CreatedAt,
+	}
+}
+
+// getPublicUser loads the user of the id param,
+// nil is returned after responding if the user is not found.
+func getPublicUser(dbi *gorm.DB, c *gin.Context) *models.User {
+
+	user := &models.User{}
+	dbi.Where("unique_id = ?", c.Param("id")).First(user)
+
+	if user.ID == 0 || user.IsDeleted {
+		ErrorNotFound(errors.New("user not found"), c)
+		return nil
+	}
+
+	return user
+}
+
+func (ctrl *UserController) GetPublic(c *gin.Context) {
+
+	user := getPublicUser(db.GetDb(), c)
+
+	if user == nil {
+		return
+	}
+
+	Success(NewPublicUser(user), c)
+}
+
+// Comments lists the comments of the user with their urls, newest first.
+func (ctrl *UserController) Comments(c *gin.Context) {
+
+	var args UserCommentListForm
+
+	if err := c.ShouldBindQuery(&args); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	cursor, err := getCursor(c)
+
+	if err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	dbi := db.GetDb()
+
+	user := getPublicUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	commentService := service.GetURLContentComment()
+
+	page, pageSize := util.PurePageArgs(args.Page, args.PageSize)
+
+	if cursor != nil {
+		data, err := commentService.ListByUser(dbi, user.ID, 0, pageSize, cursor)
+
+		if err != nil {
+			ErrorServer(err, c)
+			return
+		}
+
+		Success(data, c)
+		return
+	}
+
+	count, err := commentService.CountByUser(dbi, user.ID)
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	if !util.CanPaginate(page, pageSize, count) {
+		Success(util.EmptyPagination(page, pageSize), c)
+		return
+	}
+
+	data, err := commentService.ListByUser(dbi, user.ID, (page-1)*pageSize, pageSize, nil)
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(util.Paginate(page, pageSize, count, data), c)
+}
+
+// getAuthorizedUser loads the user of the request,
+// nil is returned after responding if the user is not found.
+func getAuthorizedUser(dbi *gorm.DB, c *gin.Context) *models.User {
+
+	userId, _ := c.Get(middlewares.AuthorizedUserId)
+
+	user := &models.User{}
+	dbi.Where("id = ?", userId.(uint)).First(user)
+
+	if user.ID == 0 || user.IsDeleted {
 		Error("User not found", c)
+		return nil
+	}
+
+	return user
+}
+
+// Update changes the profile fields given in the request,
+// fields not given or empty are left unchanged.
+func (ctrl *UserController) Update(c *gin.Context) {
+
+	var form ProfileForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	if form.Nickname != "" {
+		nickname, err := models.CleanNickname(form.Nickname)
+
+		if err != nil {
+			Error(err.Error(), c)
+			return
+		}
+
+		user.Nickname = nickname
+	}
+
+	// Avatar urls given by users are hot-linked only, the server
+	// does not fetch them on behalf of the user
+	if form.AvatarURL != "" && form.AvatarURL != user.AvatarURL {
+		avatarURL, err := models.CleanAvatarURL(form.AvatarURL)
+
+		if err != nil {
+			Error(err.Error(), c)
+			return
+		}
+
+		if err := service.GetAvatar().Remove(user); err != nil {
+			glog.Error(err)
+		}
+
+		user.AvatarURL = avatarURL
+		user.AvatarKey = ""
+	}
+
+	if err := dbi.Save(user).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(NewUserProfile(user), c)
+}
+
+// UploadAvatar stores the image in the avatar field of the multipart form
+// as the avatar of the user, resized into the standard sizes.
+func (ctrl *UserController) UploadAvatar(c *gin.Context) {
+
+	avatarService := service.GetAvatar()
+	maxSize := avatarService.GetMaxSize()
+
+	// Leave some room for the rest of the multipart form
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+64*1024)
+
+	file, header, err := c.Request.FormFile("avatar")
+
+	if err != nil {
+		Error("missing avatar file or avatar is too large", c)
+		return
+	}
+
+	defer file.Close()
+
+	if header.Size > maxSize {
+		Error(service.ErrAvatarTooLarge.Error(), c)
+		return
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxSize+1))
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	if err := avatarService.Save(dbi, user, data); err != nil {
+		if err == service.ErrAvatarTooLarge || err == service.ErrAvatarType {
+			Error(err.Error(), c)
+		} else {
+			ErrorServer(err, c)
+		}
+
+		return
+	}
+
+	Success(gin.H{"avatar_url": user.AvatarURL, "avatar_urls": avatarService.URLs(user)}, c)
+}
+
+// ChangePassword sets the new password and logs the user out of the other sessions.
+func (ctrl *UserController) ChangePassword(c *gin.Context) {
+
+	var form ChangePasswordForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	if err := service.GetUser().ChangePassword(dbi, user, form.CurrentPassword, form.NewPassword); err != nil {
+		if err == service.ErrIncorrectPassword {
+			Error(err.Error(), c)
+		} else {
+			ErrorServer(err, c)
+		}
+
+		return
+	}
+
+	if err := token.RevokeUserSessions(user.ID, c.GetString(middlewares.AuthorizedSessionId)); err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(nil, c)
+}
+
+// Delete removes the account of the user, the password is required if the user has one.
+// Comments of the user are kept with an anonymous author.
+func (ctrl *UserController) Delete(c *gin.Context) {
+
+	var form DeleteUserForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
 		return
 	}
 
-	Success(user, c)
+	tx := db.GetDb().Begin()
+
+	user := getAuthorizedUser(tx, c)
+
+	if user == nil {
+		tx.Rollback()
+		return
+	}
+
+	if user.Password != "" && !user.VerifyPassword(form.Password) {
+		tx.Rollback()
+		Error(service.ErrIncorrectPassword.Error(), c)
+		return
+	}
+
+	if err := service.GetUser().Delete(tx, user); err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	tx.Commit()
+
+	if err := token.RevokeUserSessions(user.ID, ""); err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(nil, c)
+}
+
+// ErrorUserEmail responds with the error of service.User.SetEmail
+func ErrorUserEmail(err error, c *gin.Context) {
+	if err == models.ErrInvalidEmail || err == service.ErrEmailExists {
+		Error(err.Error(), c)
+	} else {
+		ErrorServer(err, c)
+	}
+}
+
+// UpdateEmail changes the email of the user and sends the verification mail.
+func (ctrl *UserController) UpdateEmail(c *gin.Context) {
+
+	var form EmailForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	dbi := db.GetDb()
+
+	user := getAuthorizedUser(dbi, c)
+
+	if user == nil {
+		return
+	}
+
+	if err := service.GetUser().SetEmail(dbi, user, form.Email); err != nil {
+		ErrorUserEmail(err, c)
+		return
+	}
+
+	if err := dbi.Save(user).Error; err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	if err := service.GetUser().SendVerification(user); err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(NewUserProfile(user), c)
+}
+
+func (ctrl *UserController) VerifyEmail(c *gin.Context) {
+
+	var form EmailVerificationForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	if err, _ := service.GetUser().VerifyEmail(db.GetDb(), form.Token); err != nil {
+		if err == service.ErrInvalidUserToken {
+			Error(err.Error(), c)
+		} else {
+			ErrorServer(err, c)
+		}
+
+		return
+	}
+
+	Success(nil, c)
+}
+
+// ForgotPassword sends the password reset mail.
+// The response is the same whether the email is registered or not.
+func (ctrl *UserController) ForgotPassword(c *gin.Context) {
+
+	var form EmailForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	if err := service.GetUser().SendPasswordReset(db.GetDb(), form.Email); err != nil {
+		if err == models.ErrInvalidEmail {
+			Error(err.Error(), c)
+			return
+		}
+
+		glog.Error(err)
+	}
+
+	Success(nil, c)
+}
+
+// ResetPassword sets the new password and logs the user out of all sessions.
+func (ctrl *UserController) ResetPassword(c *gin.Context) {
+
+	var form ResetPasswordForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	err, user := service.GetUser().ResetPassword(db.GetDb(), form.Token, form.Password)
+
+	if err == service.ErrInvalidUserToken {
+		Error(err.Error(), c)
+		return
+	}
+
+	if err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	if err := token.RevokeUserSessions(user.ID, ""); err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(nil, c)
+}
+
+// errorLoginLocked tells the client when to retry a login that may not go ahead.
+func errorLoginLocked(check *service.LoginCheck, c *gin.Context) {
+	retryAfter := int64(math.Ceil(check.RetryAfter.Seconds()))
+
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	ErrorWithCode(http.StatusTooManyRequests, ErrCodeLoginLocked, "too many failed logins, try again later", gin.H{"retry_after": retryAfter}, c)
 }
 
 func (ctrl *UserController) Auth(c *gin.Context) {
@@ -106,22 +652,108 @@ func (ctrl *UserController) Auth(c *gin.Context) {
 		Error(err.Error(), c)
 	} else {
 
-		user := &models.User{Username: login.Username}
+		guard := service.GetLoginGuard()
+		ip := c.ClientIP()
+
+		check, err := guard.Check(login.Username, ip)
+
+		if err != nil {
+			ErrorServer(err, c)
+			return
+		}
+
+		if !check.Allowed() {
+			errorLoginLocked(check, c)
+			return
+		}
+
+		if check.CaptchaRequired && captcha.IsRecaptchaEnabled() {
+			if login.Captcha == "" {
+				ErrorWithCode(http.StatusUnauthorized, ErrCodeCaptchaRequired, "captcha required", nil, c)
+				return
+			}
+
+			err, passed := captcha.VerifyRecaptchaToken(login.Captcha)
+
+			if err != nil {
+				ErrorServer(err, c)
+				return
+			}
+
+			if !passed {
+				ErrorWithCode(http.StatusUnauthorized, ErrCodeCaptchaRequired, "captcha verification failed", nil, c)
+				return
+			}
+		}
 
 		dbi := db.GetDb()
+
+		// The attempt is counted before the password is verified
+		// so parallel attempts can't get past the limits
+
+		check, err = guard.Reserve(dbi, login.Username, ip)
+
+		if err != nil {
+			ErrorServer(err, c)
+			return
+		}
+
+		if !check.Allowed() {
+			errorLoginLocked(check, c)
+			return
+		}
+
+		user := &models.User{Username: login.Username}
 		dbi.Where("username = ?", user.Username).First(&user)
 
+		// Unknown usernames and wrong passwords get the same response
+
 		if user.ID == 0 {
-			ErrorUnauthorized("User not found", c)
-			return
+			guard.VerifyDummyPassword(login.Password)
 		}
 
-		if !user.VerifyPassword(login.Password) {
-			ErrorUnauthorized("Incorrect password", c)
+		if user.ID == 0 || !user.VerifyPassword(login.Password) {
+			if err := guard.Fail(dbi, check); err != nil {
+				glog.Error(err)
+			}
+
+			ErrorUnauthorized("Incorrect username or password", c)
 		} else {
 
+			// Upgrade the hash of legacy or outdated passwords
+
+			if user.NeedsPasswordRehash() {
+				if err := user.SetPassword(login.Password); err != nil {
+					glog.Error(err)
+				} else {
+					dbi.Model(user).UpdateColumns(map[string]interface{}{"password": user.Password, "salt": user.Salt})
+				}
+			}
+
+			// The token is issued after the second factor if it's enabled,
+			// the failures of the username are reset after it as well
+
+			if user.TOTPEnabled {
+				err, challengeToken, ttl := service.GetTwoFactor().IssueChallenge(user, login.Remember != "", login.Device, true)
+
+				if err != nil {
+					ErrorServer(err, c)
+					return
+				}
+
+				Success(&TwoFactorChallenge{
+					TwoFactorRequired: true,
+					ChallengeToken:    challengeToken,
+					ExpiresIn:         int64(ttl.Seconds()),
+				}, c)
+
+				return
+			}
+
+			guard.Succeed(login.Username, ip)
+
 			// Login success, generate token
-			err, accessToken := token.IssueToken(user.ID, login.Remember == "")
+			err, accessToken := token.IssueSessionToken(user.ID, login.Remember == "", getClient(login.Device, c))
 
 			if err != nil {
 				ErrorServer(err, c)
@@ -132,3 +764,118 @@ func (ctrl *UserController) Auth(c *gin.Context) {
 		}
 	}
 }
+
+// Merge moves everything of the source user to the target user and deletes the source user,
+// it's used by admins for people who signed up twice with different login methods.
+func (ctrl *UserController) Merge(c *gin.Context) {
+
+	var form MergeUserForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	if form.SourceID == form.TargetID {
+		Error(service.ErrMergeSameUser.Error(), c)
+		return
+	}
+
+	tx := db.GetDb().Begin()
+
+	source := &models.User{}
+	target := &models.User{}
+
+	db.ForUpdate(tx).Where("unique_id = ?", form.SourceID).First(source)
+	db.ForUpdate(tx).Where("unique_id = ?", form.TargetID).First(target)
+
+	if source.ID == 0 || source.IsDeleted || target.ID == 0 || target.IsDeleted {
+		tx.Rollback()
+		ErrorNotFound(errors.New("user not found"), c)
+		return
+	}
+
+	// The unique id of the source is replaced when it's deleted
+	before := gin.H{
+		"source_id":          source.UniqueID,
+		"source_integration": source.Integration,
+		"target_integration": target.Integration,
+	}
+
+	if err := service.GetUser().Merge(tx, source, target); err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	auditLog := newAuditLog(models.AuditActionUserMerge, models.AuditTargetUser, target.UniqueID, c)
+
+	if err := service.GetAudit().Record(tx, auditLog, before, gin.H{"target_integration": target.Integration}); err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	tx.Commit()
+
+	if err := token.RevokeUserSessions(source.ID, ""); err != nil {
+		ErrorServer(err, c)
+		return
+	}
+
+	Success(NewPublicUser(target), c)
+}
+
+// AdjustIntegration adds or takes integration of the user on behalf of admins,
+// e.g. to settle disputes over removed comments.
+func (ctrl *UserController) AdjustIntegration(c *gin.Context) {
+
+	var form IntegrationAdjustForm
+
+	if err := c.ShouldBind(&form); err != nil {
+		Error(err.Error(), c)
+		return
+	}
+
+	tx := db.GetDb().Begin()
+
+	user := &models.User{}
+	db.ForUpdate(tx).Where("unique_id = ?", form.UserID).First(user)
+
+	if user.ID == 0 || user.IsDeleted {
+		tx.Rollback()
+		ErrorNotFound(errors.New("user not found"), c)
+		return
+	}
+
+	before := gin.H{"integration": user.Integration}
+
+	err, history := service.GetIntegration().Adjust(tx, user, form.Score, form.Reason)
+
+	if err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	auditLog := newAuditLog(models.AuditActionIntegrationAdjust, models.AuditTargetUser, user.UniqueID, c)
+
+	after := gin.H{
+		"integration":            user.Integration,
+		"score":                  form.Score,
+		"reason":                 form.Reason,
+		"integration_history_id": history.UniqueID,
+	}
+
+	if err := service.GetAudit().Record(tx, auditLog, before, after); err != nil {
+		tx.Rollback()
+		ErrorServer(err, c)
+		return
+	}
+
+	tx.Commit()
+
+	history.User = *user
+
+	Success(history, c)
+}
@@ -17,18 +17,31 @@
 package v1_test
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/config"
 	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/mail"
 	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
 	"github.com/primasio/wormhole/tests"
+	"github.com/primasio/wormhole/util"
+	"golang.org/x/crypto/sha3"
 )
 
 func PrepareTestUser() (*models.User, error) {
@@ -40,7 +53,7 @@ func PrepareTestUser() (*models.User, error) {
 
 	dbi := db.GetDb()
 
-	if err := user.SetUniqueID(dbi); err != nil {
+	if err := user.SetUniqueID(); err != nil {
 		log.Fatal(err)
 	}
 
@@ -158,3 +171,577 @@ func TestUserController_Get(t *testing.T) {
 	log.Println(w4.Body.String())
 	assert.Equal(t, w4.Code, 401)
 }
+
+func TestUserController_AuthRehashLegacyPassword(t *testing.T) {
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	// Store the password as the legacy sha3 hash
+
+	salt := "AbCd1234"
+
+	hash := sha3.New256()
+	finalByteStr := append(hash.Sum([]byte("PrimasGoGoGo")), []byte(salt)...)
+	hash.Reset()
+	legacyHash := base64.StdEncoding.EncodeToString(hash.Sum(finalByteStr))
+
+	db.GetDb().Model(user).UpdateColumns(map[string]interface{}{"password": legacyHash, "salt": salt})
+
+	auth := func(password string) int {
+		w := httptest.NewRecorder()
+
+		login := url.Values{}
+		login.Set("username", user.Username)
+		login.Set("password", password)
+
+		req, _ := http.NewRequest("POST", "/v1/users/auth", strings.NewReader(login.Encode()))
+		req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Add("Content-Length", strconv.Itoa(len(login.Encode())))
+
+		router.ServeHTTP(w, req)
+
+		log.Println(w.Body.String())
+
+		return w.Code
+	}
+
+	// Wrong password keeps the legacy hash
+
+	assert.Equal(t, auth("wrong_password"), 401)
+
+	stored := &models.User{}
+	db.GetDb().Where("id = ?", user.ID).First(stored)
+	assert.Equal(t, stored.Password, legacyHash)
+
+	// Successful login upgrades the hash
+
+	assert.Equal(t, auth("PrimasGoGoGo"), 200)
+
+	stored = &models.User{}
+	db.GetDb().Where("id = ?", user.ID).First(stored)
+	assert.Equal(t, util.IdentifyPasswordHash(stored.Password), util.PasswordAlgorithmArgon2id)
+	assert.Equal(t, stored.Salt, "")
+	assert.Equal(t, stored.NeedsPasswordRehash(), false)
+
+	assert.Equal(t, auth("PrimasGoGoGo"), 200)
+	assert.Equal(t, auth("wrong_password"), 401)
+}
+
+func postForm(path string, form url.Values, authorization string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Content-Length", strconv.Itoa(len(form.Encode())))
+
+	if authorization != "" {
+		req.Header.Add("Authorization", authorization)
+	}
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+
+	return w
+}
+
+// lastMailToken returns the token in the last mail sent to the address
+func lastMailToken(t *testing.T, to string) string {
+	sent := mail.GetMailer().(*mail.FileMailer).Sent()
+
+	for i := len(sent) - 1; i >= 0; i-- {
+		if sent[i].To == to {
+			match := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(sent[i].Body)
+			assert.Equal(t, len(match), 2)
+			return match[1]
+		}
+	}
+
+	t.Fatal("no mail sent to " + to)
+
+	return ""
+}
+
+func TestUserController_VerifyEmail(t *testing.T) {
+	user, err := tests.CreateTestUser()
+	assert.Equal(t, err, nil)
+
+	email := strings.ToLower(user.Username) + "@example.com"
+
+	form := url.Values{}
+	form.Set("username", user.Username)
+	form.Set("password", user.Password)
+	form.Set("nickname", user.Nickname)
+	form.Set("email", "invalid")
+
+	assert.Equal(t, postForm("/v1/users", form, "").Code, 400)
+
+	form.Set("email", strings.ToUpper(email))
+	assert.Equal(t, postForm("/v1/users", form, "").Code, 200)
+
+	// Emails are unique
+	other, _ := tests.CreateTestUser()
+	form.Set("username", other.Username)
+	assert.Equal(t, postForm("/v1/users", form, "").Code, 400)
+
+	verification := url.Values{}
+	verification.Set("token", lastMailToken(t, email))
+
+	assert.Equal(t, postForm("/v1/users/email/verification", verification, "").Code, 200)
+
+	// Tokens are single use
+	assert.Equal(t, postForm("/v1/users/email/verification", verification, "").Code, 400)
+
+	created := &models.User{}
+	db.GetDb().Where("username = ?", user.Username).First(created)
+
+	assert.Equal(t, created.Email, email)
+	assert.Equal(t, created.EmailVerified, true)
+
+	// Changing the email needs another verification
+	tokenStruct := login(t, created, "")
+
+	w := httptest.NewRecorder()
+
+	update := url.Values{}
+	update.Set("email", "new-"+email)
+
+	req, _ := http.NewRequest("PUT", "/v1/users/email", strings.NewReader(update.Encode()))
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Authorization", tokenStruct.Token)
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, strings.Contains(w.Body.String(), `"email_verified":false`), true)
+
+	verification.Set("token", lastMailToken(t, "new-"+email))
+	assert.Equal(t, postForm("/v1/users/email/verification", verification, "").Code, 200)
+}
+
+func TestUserController_ResetPassword(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	email := strings.ToLower(user.Username) + "@example.com"
+	db.GetDb().Model(user).UpdateColumn("email", email)
+
+	tokenStruct := login(t, user, "")
+
+	// Unknown emails get the same response
+	forgot := url.Values{}
+	forgot.Set("email", "unknown-"+email)
+	assert.Equal(t, postForm("/v1/users/password/forgot", forgot, "").Code, 200)
+
+	forgot.Set("email", email)
+	assert.Equal(t, postForm("/v1/users/password/forgot", forgot, "").Code, 200)
+
+	reset := url.Values{}
+	reset.Set("token", "invalid")
+	reset.Set("password", "NewPassword")
+	assert.Equal(t, postForm("/v1/users/password/reset", reset, "").Code, 400)
+
+	reset.Set("token", lastMailToken(t, email))
+	assert.Equal(t, postForm("/v1/users/password/reset", reset, "").Code, 200)
+	assert.Equal(t, postForm("/v1/users/password/reset", reset, "").Code, 400)
+
+	// Sessions are revoked and the new password works
+	assert.Equal(t, sessionRequest("GET", "/v1/users", tokenStruct.Token).Code, 401)
+
+	auth := url.Values{}
+	auth.Set("username", user.Username)
+	auth.Set("password", "PrimasGoGoGo")
+	assert.Equal(t, postForm("/v1/users/auth", auth, "").Code, 401)
+
+	auth.Set("password", "NewPassword")
+	assert.Equal(t, postForm("/v1/users/auth", auth, "").Code, 200)
+
+	updated := &models.User{}
+	db.GetDb().Where("id = ?", user.ID).First(updated)
+	assert.Equal(t, updated.EmailVerified, true)
+}
+
+func userRequest(method, path string, body string, contentType string, authorization string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Add("Content-Type", contentType)
+	req.Header.Add("Authorization", authorization)
+
+	router.ServeHTTP(w, req)
+
+	log.Println(w.Body.String())
+
+	return w
+}
+
+func TestUserController_Update(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	tokenStruct := login(t, user, "")
+
+	form := url.Values{}
+	form.Set("nickname", "  ")
+	assert.Equal(t, userRequest("PATCH", "/v1/users", form.Encode(), "application/x-www-form-urlencoded", tokenStruct.Token).Code, 400)
+
+	form = url.Values{}
+	form.Set("avatar_url", "javascript:alert(1)")
+	assert.Equal(t, userRequest("PATCH", "/v1/users", form.Encode(), "application/x-www-form-urlencoded", tokenStruct.Token).Code, 400)
+
+	form = url.Values{}
+	form.Set("nickname", " New Nickname ")
+	form.Set("avatar_url", "https://example.com/avatar.png")
+	assert.Equal(t, userRequest("PATCH", "/v1/users", form.Encode(), "application/x-www-form-urlencoded", tokenStruct.Token).Code, 200)
+
+	// Fields not given are unchanged
+	w := userRequest("PATCH", "/v1/users", `{"nickname":"Another"}`, "application/json", tokenStruct.Token)
+	assert.Equal(t, w.Code, 200)
+
+	updated := &models.User{}
+	db.GetDb().Where("id = ?", user.ID).First(updated)
+	assert.Equal(t, updated.Nickname, "Another")
+	assert.Equal(t, updated.AvatarURL, "https://example.com/avatar.png")
+}
+
+func TestUserController_ChangePassword(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	current := login(t, user, "a")
+	other := login(t, user, "b")
+
+	form := url.Values{}
+	form.Set("current_password", "WrongPassword")
+	form.Set("new_password", "NewPassword")
+	assert.Equal(t, userRequest("PUT", "/v1/users/password", form.Encode(), "application/x-www-form-urlencoded", current.Token).Code, 400)
+
+	form.Set("current_password", "PrimasGoGoGo")
+	assert.Equal(t, userRequest("PUT", "/v1/users/password", form.Encode(), "application/x-www-form-urlencoded", current.Token).Code, 200)
+
+	// Only the other sessions are revoked
+	assert.Equal(t, sessionRequest("GET", "/v1/users", current.Token).Code, 200)
+	assert.Equal(t, sessionRequest("GET", "/v1/users", other.Token).Code, 401)
+
+	auth := url.Values{}
+	auth.Set("username", user.Username)
+	auth.Set("password", "NewPassword")
+	assert.Equal(t, postForm("/v1/users/auth", auth, "").Code, 200)
+}
+
+func TestUserController_Delete(t *testing.T) {
+	ResetDB()
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	urlContent.UserID = user.ID
+
+	comment, err := PrepareURLContentCommentWithContent(urlContent)
+	assert.Equal(t, err, nil)
+
+	db.GetDb().Create(&models.UserOAuth{UserID: user.ID, VendorType: models.OAuthGoogle, VendorID: "google-" + user.Username})
+
+	tokenStruct := login(t, user, "")
+
+	assert.Equal(t, userRequest("DELETE", "/v1/users", `{"password":"WrongPassword"}`, "application/json", tokenStruct.Token).Code, 400)
+	assert.Equal(t, userRequest("DELETE", "/v1/users", `{"password":"PrimasGoGoGo"}`, "application/json", tokenStruct.Token).Code, 200)
+
+	assert.Equal(t, sessionRequest("GET", "/v1/users", tokenStruct.Token).Code, 401)
+
+	deleted := &models.User{}
+	db.GetDb().Where("id = ?", user.ID).First(deleted)
+
+	assert.Equal(t, deleted.IsDeleted, true)
+	assert.Equal(t, deleted.Username, "")
+	assert.Equal(t, deleted.Nickname, "")
+	assert.Equal(t, deleted.Password, "")
+	assert.Equal(t, deleted.UniqueID != user.UniqueID, true)
+
+	// The comment stays with the anonymized author
+	kept := &models.URLContentComment{}
+	db.GetDb().Where("id = ?", comment.ID).First(kept)
+
+	assert.Equal(t, kept.UserID, user.ID)
+	assert.Equal(t, kept.Content, comment.Content)
+
+	var count int
+	db.GetDb().Model(&models.UserOAuth{}).Where("user_id = ?", user.ID).Count(&count)
+	assert.Equal(t, count, 0)
+}
+
+func TestUserController_GetPublic(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	w := sessionRequest("GET", "/v1/users/"+user.UniqueID, "")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, strings.Contains(w.Body.String(), `"nickname":"`+user.Nickname+`"`), true)
+	assert.Equal(t, strings.Contains(w.Body.String(), user.Username), false)
+
+	assert.Equal(t, sessionRequest("GET", "/v1/users/UNKNOWN", "").Code, 404)
+
+	// Deleted users have no public profile
+	db.GetDb().Model(user).UpdateColumn("is_deleted", true)
+	assert.Equal(t, sessionRequest("GET", "/v1/users/"+user.UniqueID, "").Code, 404)
+
+	// The session list is still behind authentication
+	assert.Equal(t, sessionRequest("GET", "/v1/users/sessions", "").Code, 401)
+}
+
+func TestUserController_Comments(t *testing.T) {
+	ResetDB()
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	err, urlContent := PrepareURLContent()
+	assert.Equal(t, err, nil)
+
+	urlContent.UserID = user.ID
+
+	for i := 0; i < 3; i++ {
+		_, err := PrepareURLContentCommentWithContent(urlContent)
+		assert.Equal(t, err, nil)
+	}
+
+	deleted, err := PrepareURLContentCommentWithContent(urlContent)
+	assert.Equal(t, err, nil)
+	db.GetDb().Model(deleted).UpdateColumn("is_deleted", true)
+
+	w := sessionRequest("GET", "/v1/users/"+user.UniqueID+"/comments?page_size=2", "")
+	assert.Equal(t, w.Code, 200)
+
+	var returnData struct {
+		Data struct {
+			Total uint                      `json:"total"`
+			Data  []service.UserCommentItem `json:"data"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(w.Body.Bytes(), &returnData)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, returnData.Data.Total, uint(3))
+	assert.Equal(t, len(returnData.Data.Data), 2)
+	assert.Equal(t, returnData.Data.Data[0].URL, urlContent.URL)
+
+	w = sessionRequest("GET", "/v1/users/"+user.UniqueID+"/comments?cursor=", "")
+	assert.Equal(t, w.Code, 200)
+	assert.Equal(t, strings.Contains(w.Body.String(), deleted.UniqueID), false)
+}
+
+// testAvatar encodes a non-square png with a transparent half
+func testAvatar(t *testing.T) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 200))
+
+	for x := 0; x < 150; x++ {
+		for y := 0; y < 200; y++ {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	assert.Equal(t, png.Encode(&buf, img), nil)
+
+	return buf.Bytes()
+}
+
+func uploadAvatar(t *testing.T, data []byte, authorization string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("avatar", "avatar.png")
+	assert.Equal(t, err, nil)
+
+	part.Write(data)
+	writer.Close()
+
+	return userRequest("POST", "/v1/users/avatar", body.String(), writer.FormDataContentType(), authorization)
+}
+
+func TestUserController_UploadAvatar(t *testing.T) {
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	tokenStruct := login(t, user, "")
+
+	assert.Equal(t, uploadAvatar(t, []byte("not an image"), tokenStruct.Token).Code, 400)
+
+	w := uploadAvatar(t, testAvatar(t), tokenStruct.Token)
+	assert.Equal(t, w.Code, 200)
+
+	var returnData struct {
+		Data struct {
+			AvatarURL  string            `json:"avatar_url"`
+			AvatarURLs map[string]string `json:"avatar_urls"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(w.Body.Bytes(), &returnData)
+	assert.Equal(t, err, nil)
+
+	assert.Equal(t, len(returnData.Data.AvatarURLs), 3)
+	assert.Equal(t, returnData.Data.AvatarURL, returnData.Data.AvatarURLs["256"])
+
+	// Avatars of the local storage are served by the api server
+	avatarPath := strings.TrimPrefix(returnData.Data.AvatarURLs["64"], "http://127.0.0.1:8080")
+
+	w = sessionRequest("GET", avatarPath, "")
+	assert.Equal(t, w.Code, 200)
+
+	resized, format, err := image.Decode(w.Body)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, format, "jpeg")
+	assert.Equal(t, resized.Bounds().Dx(), 64)
+	assert.Equal(t, resized.Bounds().Dy(), 64)
+
+	// The previous avatar is removed after another upload
+	assert.Equal(t, uploadAvatar(t, testAvatar(t), tokenStruct.Token).Code, 200)
+	assert.Equal(t, sessionRequest("GET", avatarPath, "").Code, 404)
+}
+
+func TestAvatarMirror(t *testing.T) {
+	avatar := testAvatar(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(avatar)
+	}))
+	defer server.Close()
+
+	user, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	sourceURL := server.URL + "/picture.png"
+	db.GetDb().Model(user).UpdateColumn("avatar_url", sourceURL)
+
+	// The test server listens on a loopback address
+	err = service.GetAvatar().Mirror(db.GetDb(), user.ID, sourceURL)
+	assert.Equal(t, err != nil, true)
+
+	config.GetConfig().Set("avatar.mirror_private_networks", true)
+	defer config.GetConfig().Set("avatar.mirror_private_networks", false)
+
+	// Avatars changed in the meantime are not mirrored
+	err = service.GetAvatar().Mirror(db.GetDb(), user.ID, server.URL+"/old.png")
+	assert.Equal(t, err, nil)
+
+	err = service.GetAvatar().Mirror(db.GetDb(), user.ID, sourceURL)
+	assert.Equal(t, err, nil)
+
+	mirrored := &models.User{}
+	db.GetDb().Where("id = ?", user.ID).First(mirrored)
+
+	assert.Equal(t, mirrored.AvatarKey != "", true)
+	assert.Equal(t, strings.HasPrefix(mirrored.AvatarURL, "http://127.0.0.1:8080/blobs/avatars/"+user.UniqueID+"/"), true)
+	assert.Equal(t, service.GetAvatar().NeedsMirror(mirrored), false)
+}
+
+func TestUserController_Merge(t *testing.T) {
+	PrepareSystemUser()
+
+	dbi := db.GetDb()
+	voteService := service.GetURLContentCommentVote()
+
+	source, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	target, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	author, err := PrepareTestUser()
+	assert.Equal(t, err, nil)
+
+	sourceToken := login(t, source, "laptop").Token
+
+	// A comment of the source voted by the author
+
+	content, err := PrepareURLContentWithUser(source)
+	assert.Equal(t, err, nil)
+
+	sourceComment, err := PrepareURLContentCommentWithContent(content)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, voteService.CreateVote(dbi, sourceComment, author, true), nil)
+
+	// Comments of the author voted by both users and by the source only
+
+	content, err = PrepareURLContentWithUser(author)
+	assert.Equal(t, err, nil)
+
+	bothVoted, err := PrepareURLContentCommentWithContent(content)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, voteService.CreateVote(dbi, bothVoted, source, true), nil)
+	assert.Equal(t, voteService.CreateVote(dbi, bothVoted, target, true), nil)
+
+	sourceVoted, err := PrepareURLContentCommentWithContent(content)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, voteService.CreateVote(dbi, sourceVoted, source, false), nil)
+
+	dbi.Where("id = ?", author.ID).First(author)
+	authorIntegration := author.Integration
+
+	dbi.Where("id = ?", source.ID).First(source)
+	sourceIntegration := source.Integration
+
+	// Merge
+
+	form := url.Values{}
+	form.Set("source_id", source.UniqueID)
+	form.Set("target_id", target.UniqueID)
+
+	assert.Equal(t, userRequest("POST", "/v1/users/merge", form.Encode(), "application/x-www-form-urlencoded", sourceToken).Code, 403)
+
+	_, adminKey := prepareRoleUser(t, models.UserRoleAdmin)
+
+	sameForm := url.Values{}
+	sameForm.Set("source_id", target.UniqueID)
+	sameForm.Set("target_id", target.UniqueID)
+
+	assert.Equal(t, userRequest("POST", "/v1/users/merge", sameForm.Encode(), "application/x-www-form-urlencoded", adminKey).Code, 400)
+	assert.Equal(t, userRequest("POST", "/v1/users/merge", form.Encode(), "application/x-www-form-urlencoded", adminKey).Code, 200)
+
+	// The source is deleted and its sessions are revoked
+
+	assert.Equal(t, sessionRequest("GET", "/v1/users", sourceToken).Code, 401)
+	assert.Equal(t, userRequest("POST", "/v1/users/merge", form.Encode(), "application/x-www-form-urlencoded", adminKey).Code, 404)
+
+	// Comments and the integration earned on them belong to the target
+
+	dbi.Where("id = ?", sourceComment.ID).First(sourceComment)
+	assert.Equal(t, sourceComment.UserID, target.ID)
+
+	dbi.Where("id = ?", target.ID).First(target)
+	assert.Equal(t, target.Integration, sourceIntegration)
+	assert.Equal(t, target.CommentUpVotes, uint(1))
+
+	var histories int
+	dbi.Model(&models.IntegrationHistory{}).Where("user_id = ?", source.ID).Count(&histories)
+	assert.Equal(t, histories, 0)
+
+	// The duplicate vote is dropped, the other one is moved
+
+	dbi.Where("id = ?", bothVoted.ID).First(bothVoted)
+	assert.Equal(t, bothVoted.CommentUpVotes, uint(1))
+
+	var votes []models.URLContentCommentVote
+	dbi.Where("user_id IN (?)", []uint{source.ID, target.ID}).Order("id").Find(&votes)
+	assert.Equal(t, len(votes), 2)
+	assert.Equal(t, votes[0].UserID, target.ID)
+	assert.Equal(t, votes[1].URLContentCommentID, sourceVoted.ID)
+	assert.Equal(t, votes[1].UserID, target.ID)
+
+	dbi.Where("id = ?", author.ID).First(author)
+	assert.Equal(t, author.CommentUpVotes, uint(1))
+	assert.Equal(t, author.CommentDownVotes, uint(1))
+	assert.Equal(t, author.Integration, authorIntegration-service.GetIntegration().GetURLContentCommentVoteScore(true))
+
+	// The moved vote can still be changed by the target
+
+	assert.Equal(t, voteService.UpdateVote(dbi, sourceVoted, target, true), nil)
+}
@@ -19,93 +19,67 @@ package middlewares
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
-	"github.com/primasio/wormhole/cache"
-	"net/http"
+	"github.com/primasio/wormhole/http/token"
 	"strconv"
-	"time"
 )
 
 const AuthorizedUserId = "UserId"
+const AuthorizedSessionId = "SessionId"
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-
-		reqToken := c.Request.Header.Get("Authorization")
-
-		if reqToken == "" {
-			c.AbortWithStatus(401)
-			return
-		}
-
-		// Check token validity
-
-		if err, userId := cache.SessionGet(reqToken); err != nil {
-
-			glog.Error("token not exist", err)
-			c.AbortWithStatus(500)
-
-		} else {
-
-			if userId == "" {
-				c.AbortWithStatus(401)
-			} else {
-
-				userIdNum, err := strconv.Atoi(userId)
-
-				if err != nil {
-					glog.Error(err)
-					c.AbortWithStatus(500)
-					return
-				}
-
-				c.Set(AuthorizedUserId, uint(userIdNum))
-
-				// User account based access rate limit
-
-				err, reached := rateLimitReached(userId)
-
-				if err != nil {
-					glog.Error(err)
-					c.AbortWithStatus(http.StatusInternalServerError)
-				} else {
-					if reached {
-						c.AbortWithStatus(http.StatusBadRequest)
-					} else {
-						c.Next()
-					}
-				}
-			}
+		if authenticate(c) {
+			c.Next()
 		}
 	}
 }
 
-func rateLimitReached(userId string) (error, bool) {
+// authenticate sets the user of the token in the context,
+// the request is aborted if the token is not valid.
+func authenticate(c *gin.Context) bool {
 
-	cacheType := cache.GetCacheType()
+	reqToken := c.Request.Header.Get("Authorization")
 
-	if cacheType == "memory" {
-		return nil, false
+	if reqToken == "" {
+		c.AbortWithStatus(401)
+		return false
 	}
 
-	// API access for a single user is limited to 10 times per minute
+	// Check token validity
 
-	currentMinute := int(time.Now().Unix() / 60)
+	err, userId, sessionId := token.Authenticate(reqToken)
 
-	slotId := "auth_rate_limit_" + userId + "_" + strconv.Itoa(currentMinute)
+	if err != nil {
+		glog.Error("token not exist", err)
+		c.AbortWithStatus(500)
+		return false
+	}
 
-	cache := cache.GetCache()
+	if userId == "" {
+		c.AbortWithStatus(401)
+		return false
+	}
 
-	count, err := cache.Increment(slotId, 1)
+	userIdNum, err := strconv.Atoi(userId)
 
 	if err != nil {
-		return err, false
+		glog.Error(err)
+		c.AbortWithStatus(500)
+		return false
 	}
 
-	if count >= 10 {
-		return nil, true
-	} else {
-		cache.Expire(slotId, time.Minute)
+	c.Set(AuthorizedUserId, uint(userIdNum))
+
+	if sessionId != "" {
+		c.Set(AuthorizedSessionId, sessionId)
+	}
+
+	// JWTs are verified without the session store
+	if sessionId != "" && token.GetMode() == token.ModeSession {
+		if err := token.Touch(sessionId, c.ClientIP(), c.Request.UserAgent()); err != nil {
+			glog.Error(err)
+		}
 	}
 
-	return nil, false
+	return true
 }
@@ -18,8 +18,6 @@ package middlewares_test
 
 import (
 	"github.com/magiconair/properties/assert"
-	"github.com/primasio/wormhole/http/server"
-	"github.com/primasio/wormhole/tests"
 	"log"
 	"net/http"
 	"net/http/httptest"
@@ -30,9 +28,6 @@ import (
 )
 
 func TestCors(t *testing.T) {
-	tests.InitTestEnv("../../config/")
-	router := server.NewRouter()
-
 	w := httptest.NewRecorder()
 
 	form := url.Values{}
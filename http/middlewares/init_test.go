@@ -14,31 +14,22 @@
  * limitations under the License.
  */
 
-package middlewares
+package middlewares_test
 
 import (
+	"os"
+	"testing"
+
 	"github.com/gin-gonic/gin"
-	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/http/server"
+	"github.com/primasio/wormhole/tests"
 )
 
-func AdminAuthMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-
-		reqToken := c.Request.Header.Get("Authorization")
-
-		if reqToken == "" {
-			c.AbortWithStatus(401)
-			return
-		}
-
-		// Check admin token validity
+var router *gin.Engine
 
-		adminToken := config.GetConfig().GetString("admin.key")
+func TestMain(m *testing.M) {
+	tests.InitTestEnv("../../config/")
+	router = server.NewRouter()
 
-		if adminToken == "" || reqToken != adminToken {
-			c.AbortWithStatus(401)
-		} else {
-			c.Next()
-		}
-	}
+	os.Exit(m.Run())
 }
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/models"
+)
+
+// AuthorizedAdminKey is set in the context of requests made with the static admin key
+const AuthorizedAdminKey = "AdminKey"
+
+// The static admin key is only accepted to grant the first roles while
+// admin.bootstrap is set, everything else is done by named accounts.
+var adminKeyPermissions = []string{models.PermissionManageRoles}
+
+// RequirePermission authenticates the user and checks the role
+// of the user has all the permissions.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		if IsAdminKey(c) {
+			if !hasAll(adminKeyPermissions, permissions) {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+
+			c.Set(AuthorizedAdminKey, true)
+			c.Next()
+			return
+		}
+
+		if !authenticate(c) {
+			return
+		}
+
+		userId, _ := c.Get(AuthorizedUserId)
+
+		user := &models.User{}
+
+		if err := db.GetDb().Where("id = ?", userId.(uint)).First(user).Error; err != nil {
+			glog.Error(err)
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if user.IsDeleted || !hasAll(models.GetRolePermissions(user.Role), permissions) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// IsAdminKey tells whether the request is authorized by the static admin.key,
+// the key is refused unless admin.bootstrap is set.
+func IsAdminKey(c *gin.Context) bool {
+	conf := config.GetConfig()
+	adminKey := conf.GetString("admin.key")
+
+	return conf.GetBool("admin.bootstrap") && adminKey != "" && c.Request.Header.Get("Authorization") == adminKey
+}
+
+func hasAll(granted, required []string) bool {
+	for _, r := range required {
+		found := false
+
+		for _, g := range granted {
+			if g == r {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
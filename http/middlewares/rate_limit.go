@@ -0,0 +1,145 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middlewares
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/primasio/wormhole/cache"
+	"github.com/primasio/wormhole/config"
+)
+
+// Identities the requests are limited by
+const (
+	RateLimitIdentityUser   = "user"
+	RateLimitIdentityAPIKey = "api_key"
+	RateLimitIdentityIP     = "ip"
+)
+
+const rateLimitDefaultGroup = "default"
+
+// RateLimitMiddleware limits the requests of each identity to the routes of the group
+// with the policy of rate_limit.groups.<group>, missing values are taken from the
+// default group. It follows AuthMiddleware on authorized routes so users are limited
+// by their id, anonymous requests are limited by ip. Groups can be stacked on a route,
+// the headers tell the most restrictive of them.
+func RateLimitMiddleware(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		conf := config.GetConfig()
+
+		if !conf.GetBool("rate_limit.enabled") {
+			c.Next()
+			return
+		}
+
+		identity, id := rateLimitIdentity(c)
+
+		limit := rateLimitInt64(group, identity)
+
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		window := rateLimitDuration(group, "window")
+
+		if window <= 0 {
+			window = time.Minute
+		}
+
+		key := "rate_limit_" + group + "_" + identity + "_" + id
+
+		result, err := cache.SlidingWindowLimit(key, limit, window, time.Now())
+
+		if err != nil {
+			glog.Error(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		setRateLimitHeaders(c, result)
+
+		if !result.Allowed() {
+			c.Header("Retry-After", strconv.FormatInt(ceilSeconds(result.RetryAfter), 10))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"success": false, "message": "Too Many Requests"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func rateLimitIdentity(c *gin.Context) (string, string) {
+
+	if userId, exists := c.Get(AuthorizedUserId); exists {
+		return RateLimitIdentityUser, strconv.FormatUint(uint64(userId.(uint)), 10)
+	}
+
+	// The key itself is not written to the cache
+	if IsAdminKey(c) {
+		sum := sha256.Sum256([]byte(config.GetConfig().GetString("admin.key")))
+		return RateLimitIdentityAPIKey, hex.EncodeToString(sum[:8])
+	}
+
+	return RateLimitIdentityIP, c.ClientIP()
+}
+
+func setRateLimitHeaders(c *gin.Context, result *cache.RateLimit) {
+
+	// A previous group of the route may be closer to its limit
+	if remaining := c.Writer.Header().Get("RateLimit-Remaining"); remaining != "" {
+		if n, err := strconv.ParseInt(remaining, 10, 64); err == nil && n <= result.Remaining && result.Allowed() {
+			return
+		}
+	}
+
+	c.Header("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	c.Header("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	c.Header("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.Reset), 10))
+}
+
+func rateLimitInt64(group, key string) int64 {
+	conf := config.GetConfig()
+
+	if k := "rate_limit.groups." + group + "." + key; conf.IsSet(k) {
+		return conf.GetInt64(k)
+	}
+
+	return conf.GetInt64("rate_limit.groups." + rateLimitDefaultGroup + "." + key)
+}
+
+func rateLimitDuration(group, key string) time.Duration {
+	conf := config.GetConfig()
+
+	if k := "rate_limit.groups." + group + "." + key; conf.IsSet(k) {
+		return conf.GetDuration(k)
+	}
+
+	return conf.GetDuration("rate_limit.groups." + rateLimitDefaultGroup + "." + key)
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
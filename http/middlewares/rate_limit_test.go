@@ -0,0 +1,86 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middlewares_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/config"
+)
+
+func rateLimitRequest(remoteAddr, authorization string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+
+	req, _ := http.NewRequest("GET", "/v1/comments", nil)
+	req.RemoteAddr = remoteAddr
+	req.Header.Add("Authorization", authorization)
+
+	router.ServeHTTP(w, req)
+
+	return w
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	c := config.GetConfig()
+
+	c.Set("rate_limit.groups.comments.ip", 3)
+	c.Set("rate_limit.groups.comments.api_key", 0)
+
+	defer func() {
+		c.Set("rate_limit.groups.comments.ip", 100000)
+		c.Set("rate_limit.groups.comments.api_key", 100000)
+	}()
+
+	// Anonymous requests are limited by ip
+
+	for i := 0; i < 3; i++ {
+		w := rateLimitRequest("10.0.0.1:1234", "")
+
+		assert.Equal(t, w.Code != http.StatusTooManyRequests, true)
+		assert.Equal(t, w.Header().Get("RateLimit-Limit"), "3")
+		assert.Equal(t, w.Header().Get("RateLimit-Remaining"), strconv.Itoa(2-i))
+	}
+
+	w := rateLimitRequest("10.0.0.1:1234", "")
+
+	assert.Equal(t, w.Code, http.StatusTooManyRequests)
+	assert.Equal(t, w.Header().Get("RateLimit-Remaining"), "0")
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	assert.Equal(t, err, nil)
+	// The current window may need to fade out in the next one
+	assert.Equal(t, retryAfter > 0 && retryAfter <= 120, true)
+
+	// Other identities have their own limits
+
+	assert.Equal(t, rateLimitRequest("10.0.0.2:1234", "").Code != http.StatusTooManyRequests, true)
+
+	w = rateLimitRequest("10.0.0.1:1234", c.GetString("admin.key"))
+	assert.Equal(t, w.Code != http.StatusTooManyRequests, true)
+	assert.Equal(t, w.Header().Get("RateLimit-Limit"), "")
+
+	// Rejected requests are not counted
+
+	c.Set("rate_limit.groups.comments.ip", 4)
+
+	assert.Equal(t, rateLimitRequest("10.0.0.1:1234", "").Code != http.StatusTooManyRequests, true)
+	assert.Equal(t, rateLimitRequest("10.0.0.1:1234", "").Code, http.StatusTooManyRequests)
+}
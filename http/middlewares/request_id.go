@@ -0,0 +1,55 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middlewares
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/glog"
+	"github.com/primasio/wormhole/util"
+)
+
+const RequestId = "RequestId"
+
+const RequestIdHeader = "X-Request-ID"
+
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestIDMiddleware keeps the request id given by the proxy or generates one,
+// it's sent back in the response and recorded in the audit log.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		requestId := c.Request.Header.Get(RequestIdHeader)
+
+		if !requestIdPattern.MatchString(requestId) {
+			id, err := util.NewID()
+
+			if err != nil {
+				glog.Error(err)
+			}
+
+			requestId = id
+		}
+
+		c.Set(RequestId, requestId)
+		c.Header(RequestIdHeader, requestId)
+
+		c.Next()
+	}
+}
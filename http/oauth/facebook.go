@@ -0,0 +1,70 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/primasio/wormhole/models"
+	"golang.org/x/oauth2/facebook"
+)
+
+const ProviderFacebook = "facebook"
+
+type FacebookUserResponse struct {
+	Id      string `json:"id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Picture struct {
+		Data struct {
+			URL          string `json:"url"`
+			IsSilhouette bool   `json:"is_silhouette"`
+		} `json:"data"`
+	} `json:"picture"`
+}
+
+func NewFacebookProvider(c *ProviderConfig) Provider {
+
+	provider := newOAuth2Provider(ProviderFacebook, c, facebook.Endpoint, []string{"public_profile", "email"}, "https://graph.facebook.com")
+	provider.fetchUser = fetchFacebookUser
+
+	return provider
+}
+
+func fetchFacebookUser(ctx context.Context, client *http.Client, apiURL string) (*OAuthResult, error) {
+
+	user := &FacebookUserResponse{}
+
+	if err := getJSON(ctx, client, apiURL+"/me?fields=id,name,email,picture.type(large)", user); err != nil {
+		return nil, err
+	}
+
+	result := &OAuthResult{
+		Type:  models.OAuthFacebook,
+		Id:    user.Id,
+		Email: user.Email,
+		Name:  user.Name,
+	}
+
+	// The default silhouette is not used as an avatar
+	if !user.Picture.Data.IsSilhouette {
+		result.AvatarURL = user.Picture.Data.URL
+	}
+
+	return result, nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/http/oauth"
+	"github.com/primasio/wormhole/models"
+)
+
+func facebookUser(isSilhouette bool) map[string]interface{} {
+	return map[string]interface{}{
+		"id":    "10215783512345",
+		"name":  "Chen Zhao",
+		"email": "chen@example.com",
+		"picture": map[string]interface{}{
+			"data": map[string]interface{}{
+				"url":           "https://platform-lookaside.fbsbx.com/platform/profilepic/?asid=10215783512345",
+				"is_silhouette": isSilhouette,
+			},
+		},
+	}
+}
+
+func TestFacebookProvider(t *testing.T) {
+	fake := newFakeProvider(t, map[string]interface{}{"/me": facebookUser(false)})
+	defer fake.Close()
+
+	provider := oauth.NewFacebookProvider(fake.Config())
+	assert.Equal(t, provider.Name(), oauth.ProviderFacebook)
+
+	result := fake.Exchange(provider, "")
+
+	assert.Equal(t, result.Type, uint(models.OAuthFacebook))
+	assert.Equal(t, result.Id, "10215783512345")
+	assert.Equal(t, result.Name, "Chen Zhao")
+	assert.Equal(t, result.Email, "chen@example.com")
+	assert.Equal(t, result.AvatarURL, "https://platform-lookaside.fbsbx.com/platform/profilepic/?asid=10215783512345")
+}
+
+func TestFacebookProvider_Silhouette(t *testing.T) {
+	fake := newFakeProvider(t, map[string]interface{}{"/me": facebookUser(true)})
+	defer fake.Close()
+
+	result := fake.Exchange(oauth.NewFacebookProvider(fake.Config()), "")
+	assert.Equal(t, result.AvatarURL, "")
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/primasio/wormhole/models"
+	"golang.org/x/oauth2/github"
+)
+
+const ProviderGitHub = "github"
+
+type GitHubUserResponse struct {
+	Id        int64  `json:"id"`
+	Login     string `json:"login"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+}
+
+type GitHubEmailResponse struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+func NewGitHubProvider(c *ProviderConfig) Provider {
+
+	provider := newOAuth2Provider(ProviderGitHub, c, github.Endpoint, []string{"read:user", "user:email"}, "https://api.github.com")
+	provider.fetchUser = fetchGitHubUser
+
+	return provider
+}
+
+func fetchGitHubUser(ctx context.Context, client *http.Client, apiURL string) (*OAuthResult, error) {
+
+	user := &GitHubUserResponse{}
+
+	if err := getJSON(ctx, client, apiURL+"/user", user); err != nil {
+		return nil, err
+	}
+
+	result := &OAuthResult{
+		Type:      models.OAuthGitHub,
+		Id:        strconv.FormatInt(user.Id, 10),
+		Email:     user.Email,
+		Name:      user.Name,
+		AvatarURL: user.AvatarURL,
+	}
+
+	if result.Name == "" {
+		result.Name = user.Login
+	}
+
+	if result.Email == "" {
+		// Emails are only in the profile if the user made them public
+		var emails []GitHubEmailResponse
+
+		if err := getJSON(ctx, client, apiURL+"/user/emails", &emails); err != nil {
+			return nil, err
+		}
+
+		for _, email := range emails {
+			if email.Primary && email.Verified {
+				result.Email = email.Email
+			}
+		}
+	}
+
+	return result, nil
+}
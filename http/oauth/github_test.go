@@ -0,0 +1,55 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/http/oauth"
+	"github.com/primasio/wormhole/models"
+)
+
+func TestGitHubProvider(t *testing.T) {
+	fake := newFakeProvider(t, map[string]interface{}{
+		"/user": map[string]interface{}{
+			"id":         583231,
+			"login":      "octocat",
+			"name":       "",
+			"email":      nil,
+			"avatar_url": "https://avatars.githubusercontent.com/u/583231?v=4",
+		},
+		"/user/emails": []map[string]interface{}{
+			{"email": "old@example.com", "primary": false, "verified": true},
+			{"email": "unverified@example.com", "primary": true, "verified": false},
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+		},
+	})
+	defer fake.Close()
+
+	provider := oauth.NewGitHubProvider(fake.Config())
+	assert.Equal(t, provider.Name(), oauth.ProviderGitHub)
+
+	result := fake.Exchange(provider, "")
+	assert.Equal(t, fake.tokenForm.Get("code_verifier"), "")
+
+	assert.Equal(t, result.Type, uint(models.OAuthGitHub))
+	assert.Equal(t, result.Id, "583231")
+	assert.Equal(t, result.Name, "octocat")
+	assert.Equal(t, result.Email, "octocat@example.com")
+	assert.Equal(t, result.AvatarURL, "https://avatars.githubusercontent.com/u/583231?v=4")
+}
@@ -21,8 +21,13 @@ import (
 
 	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/service"
+	"github.com/primasio/wormhole/worker"
 )
 
+var ErrIdentityLinked = errors.New("the account of the provider is linked to another user")
+var ErrProviderLinked = errors.New("an account of the provider is already linked")
+
 type OAuthResult struct {
 	Type      uint
 	Id        string
@@ -49,7 +54,16 @@ func (oauthResult *OAuthResult) Process() (err error, userId uint) {
 	dbi.Where(&userOAuth).First(&userOAuth)
 
 	if userOAuth.ID != 0 {
-		// User exists
+		// User exists, avatars of the provider hot-linked before mirroring
+		// was added are mirrored now, urls set by the user are not fetched
+
+		user := &models.User{}
+		dbi.Where("id = ?", userOAuth.UserID).First(user)
+
+		if service.GetAvatar().NeedsMirror(user) && user.AvatarURL == oauthResult.AvatarURL {
+			worker.GetAvatarMirrorWorker().Enqueue(user.ID, user.AvatarURL)
+		}
+
 		return nil, userOAuth.UserID
 	}
 
@@ -66,7 +80,7 @@ func (oauthResult *OAuthResult) Process() (err error, userId uint) {
 		user.Nickname = oauthResult.Email
 	}
 
-	if err := user.SetUniqueID(dbi); err != nil {
+	if err := user.SetUniqueID(); err != nil {
 		return err, 0
 	}
 
@@ -89,8 +103,54 @@ func (oauthResult *OAuthResult) Process() (err error, userId uint) {
 	tx.Commit()
 
 	if oauthResult.AvatarURL != "" {
-		// TODO: Async downloading avatar if exists
+		// Stop hot-linking the avatar of the provider
+		worker.GetAvatarMirrorWorker().Enqueue(user.ID, oauthResult.AvatarURL)
 	}
 
 	return nil, user.ID
 }
+
+// Link adds the account of the provider as a login method of the user,
+// each user can link one account of a provider.
+func (oauthResult *OAuthResult) Link(userId uint) error {
+
+	if oauthResult.Id == "" || oauthResult.Type == 0 {
+		return errors.New("missing id or type in oauth response")
+	}
+
+	tx := db.GetDb().Begin()
+
+	userOAuth := &models.UserOAuth{}
+	db.ForUpdate(tx).Where("vendor_type = ? AND vendor_id = ?", oauthResult.Type, oauthResult.Id).First(userOAuth)
+
+	if userOAuth.ID != 0 {
+		tx.Rollback()
+
+		if userOAuth.UserID == userId {
+			return nil
+		}
+
+		return ErrIdentityLinked
+	}
+
+	linked := &models.UserOAuth{}
+	tx.Where("user_id = ? AND vendor_type = ?", userId, oauthResult.Type).First(linked)
+
+	if linked.ID != 0 {
+		tx.Rollback()
+		return ErrProviderLinked
+	}
+
+	userOAuth = &models.UserOAuth{
+		UserID:     userId,
+		VendorType: oauthResult.Type,
+		VendorID:   oauthResult.Id,
+	}
+
+	if err := tx.Create(userOAuth).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
@@ -0,0 +1,278 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/models"
+	"golang.org/x/oauth2"
+)
+
+// Time limit of the code exchange and the user info requests
+const callbackTimeout = time.Second * 10
+
+// Provider is an OAuth identity provider
+type Provider interface {
+	Name() string
+
+	// AuthCodeURL returns the url of the provider to redirect the user to.
+	// The verifier is the PKCE code verifier, providers without PKCE ignore it.
+	AuthCodeURL(state, verifier string) string
+
+	// Exchange trades the code for an access token and loads the user info.
+	Exchange(ctx context.Context, code, verifier string) (*OAuthResult, error)
+}
+
+// ProviderConfig holds the client credentials of a provider.
+// Endpoints default to the ones of the provider and are overridden in tests.
+type ProviderConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	AuthURL  string
+	TokenURL string
+	APIURL   string
+}
+
+var providerFactories = map[string]func(*ProviderConfig) Provider{
+	ProviderGoogle:   NewGoogleProvider,
+	ProviderGitHub:   NewGitHubProvider,
+	ProviderFacebook: NewFacebookProvider,
+	ProviderTwitter:  NewTwitterProvider,
+}
+
+var providers = make(map[string]Provider)
+var providersMutex sync.RWMutex
+var providersOnce sync.Once
+
+// GetProvider returns the provider of the name, or nil if it's not enabled.
+// Providers are enabled by setting their client_id in config:
+//
+//	oauth:
+//	  github:
+//	    client_id:
+//	    client_secret:
+func GetProvider(name string) Provider {
+	providersOnce.Do(loadProviders)
+
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	return providers[name]
+}
+
+// RegisterProvider enables the provider, replacing the one of the same name.
+func RegisterProvider(provider Provider) {
+	providersOnce.Do(loadProviders)
+
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+
+	providers[provider.Name()] = provider
+}
+
+func loadProviders() {
+	c := config.GetConfig()
+
+	if c == nil {
+		return
+	}
+
+	scheme := c.GetString("application.scheme")
+	domain := c.GetString("application.domain")
+
+	for name, factory := range providerFactories {
+		prefix := "oauth." + name + "."
+
+		if c.GetString(prefix+"client_id") == "" {
+			continue
+		}
+
+		providers[name] = factory(&ProviderConfig{
+			ClientID:     c.GetString(prefix + "client_id"),
+			ClientSecret: c.GetString(prefix + "client_secret"),
+			RedirectURL:  scheme + "://" + domain + "/v1/oauth/callback/" + name,
+			Scopes:       c.GetStringSlice(prefix + "scopes"),
+			AuthURL:      c.GetString(prefix + "auth_url"),
+			TokenURL:     c.GetString(prefix + "token_url"),
+			APIURL:       c.GetString(prefix + "api_url"),
+		})
+	}
+}
+
+// Names of the providers of the vendor types
+var providerNames = map[uint]string{
+	models.OAuthGoogle:   ProviderGoogle,
+	models.OAuthGitHub:   ProviderGitHub,
+	models.OAuthFacebook: ProviderFacebook,
+	models.OAuthTwitter:  ProviderTwitter,
+}
+
+// GetProviderName returns the name of the provider of the vendor type.
+func GetProviderName(vendorType uint) string {
+	return providerNames[vendorType]
+}
+
+// GetVendorType returns the vendor type of the provider name, or 0 if it's unknown.
+func GetVendorType(name string) uint {
+	for vendorType, providerName := range providerNames {
+		if providerName == name {
+			return vendorType
+		}
+	}
+
+	return 0
+}
+
+// HandleCallback signs in the user of the code, the user is created on the first sign in.
+func HandleCallback(provider Provider, code, verifier string) (err error, userId uint) {
+
+	result, err := exchange(provider, code, verifier)
+
+	if err != nil {
+		return err, 0
+	}
+
+	return result.Process()
+}
+
+// HandleLinkCallback returns the account of the code,
+// it's linked after the user confirms it.
+func HandleLinkCallback(provider Provider, code, verifier string) (*OAuthResult, error) {
+	return exchange(provider, code, verifier)
+}
+
+func exchange(provider Provider, code, verifier string) (*OAuthResult, error) {
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), callbackTimeout)
+	defer cancelFn()
+
+	return provider.Exchange(ctx, code, verifier)
+}
+
+// oauth2Provider implements the authorization code flow of OAuth 2.0,
+// providers differ in endpoints and in how the user info is loaded.
+type oauth2Provider struct {
+	name   string
+	config *oauth2.Config
+	apiURL string
+	pkce   bool
+
+	fetchUser func(ctx context.Context, client *http.Client, apiURL string) (*OAuthResult, error)
+}
+
+func newOAuth2Provider(name string, c *ProviderConfig, endpoint oauth2.Endpoint, scopes []string, apiURL string) *oauth2Provider {
+
+	if c.AuthURL != "" {
+		endpoint.AuthURL = c.AuthURL
+	}
+
+	if c.TokenURL != "" {
+		endpoint.TokenURL = c.TokenURL
+	}
+
+	if c.APIURL != "" {
+		apiURL = c.APIURL
+	}
+
+	if len(c.Scopes) > 0 {
+		scopes = c.Scopes
+	}
+
+	return &oauth2Provider{
+		name:   name,
+		apiURL: apiURL,
+		config: &oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			Endpoint:     endpoint,
+			Scopes:       scopes,
+			RedirectURL:  c.RedirectURL,
+		},
+	}
+}
+
+func (p *oauth2Provider) Name() string {
+	return p.name
+}
+
+func (p *oauth2Provider) AuthCodeURL(state, verifier string) string {
+
+	if !p.pkce {
+		return p.config.AuthCodeURL(state)
+	}
+
+	return p.config.AuthCodeURL(state,
+		oauth2.SetAuthURLParam("code_challenge", CodeChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", CodeChallengeMethodS256),
+	)
+}
+
+func (p *oauth2Provider) Exchange(ctx context.Context, code, verifier string) (*OAuthResult, error) {
+
+	var options []oauth2.AuthCodeOption
+
+	if p.pkce {
+		options = append(options, oauth2.SetAuthURLParam("code_verifier", verifier))
+	}
+
+	token, err := p.config.Exchange(ctx, code, options...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p.fetchUser(ctx, p.config.Client(ctx, token), p.apiURL)
+}
+
+// getJSON decodes the json response of the api url into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+
+	req, err := http.NewRequest("GET", url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req.WithContext(ctx))
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New("oauth: GET " + url + ": " + resp.Status + " " + string(body))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
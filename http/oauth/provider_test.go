@@ -0,0 +1,125 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/http/oauth"
+)
+
+const fakeCode = "fake-code"
+const fakeAccessToken = "fake-access-token"
+
+// fakeProvider serves the token endpoint and the given api routes,
+// api requests must carry the access token issued for the fake code.
+type fakeProvider struct {
+	*httptest.Server
+	t *testing.T
+
+	// The last token request
+	tokenForm url.Values
+}
+
+func newFakeProvider(t *testing.T, routes map[string]interface{}) *fakeProvider {
+
+	fake := &fakeProvider{t: t}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		fake.tokenForm = r.PostForm
+
+		if r.PostForm.Get("code") != fakeCode {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"` + fakeAccessToken + `","token_type":"bearer","expires_in":3600}`))
+	})
+
+	for path, response := range routes {
+		response := response
+
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+fakeAccessToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+		})
+	}
+
+	fake.Server = httptest.NewServer(mux)
+
+	return fake
+}
+
+func (fake *fakeProvider) Config() *oauth.ProviderConfig {
+	return &oauth.ProviderConfig{
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+		RedirectURL:  "https://api.wormhole.im/v1/oauth/callback/fake",
+		AuthURL:      fake.URL + "/authorize",
+		TokenURL:     fake.URL + "/token",
+		APIURL:       fake.URL,
+	}
+}
+
+func (fake *fakeProvider) Exchange(provider oauth.Provider, verifier string) *oauth.OAuthResult {
+
+	result, err := provider.Exchange(context.Background(), fakeCode, verifier)
+	assert.Equal(fake.t, err, nil)
+
+	return result
+}
+
+func TestProvider_InvalidCode(t *testing.T) {
+	fake := newFakeProvider(t, nil)
+	defer fake.Close()
+
+	provider := oauth.NewGitHubProvider(fake.Config())
+
+	_, err := provider.Exchange(context.Background(), "invalid", "")
+	assert.Equal(t, err == nil, false)
+}
+
+func TestProvider_AuthCodeURL(t *testing.T) {
+	fake := newFakeProvider(t, nil)
+	defer fake.Close()
+
+	authURL, err := url.Parse(oauth.NewGitHubProvider(fake.Config()).AuthCodeURL("state", "verifier"))
+	assert.Equal(t, err, nil)
+
+	query := authURL.Query()
+	assert.Equal(t, authURL.Path, "/authorize")
+	assert.Equal(t, query.Get("state"), "state")
+	assert.Equal(t, query.Get("client_id"), "client-id")
+	assert.Equal(t, query.Get("redirect_uri"), "https://api.wormhole.im/v1/oauth/callback/fake")
+	assert.Equal(t, query.Get("code_challenge"), "")
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/primasio/wormhole/config"
+)
+
+const CodeChallengeMethodS256 = "S256"
+
+var ErrRedirectURINotAllowed = errors.New("redirect uri is not allowed")
+var ErrInvalidCodeChallenge = errors.New("code_challenge must be an S256 challenge")
+
+// redirectPattern is an entry of oauth.redirect_uris in config:
+//
+//	https://wormhole.im                  an origin, any uri of it is allowed
+//	https://wormhole.im/signin           the exact uri
+//	https://*.wormhole.im/oauth/*        * in the host matches one label,
+//	                                     * in the path and query matches anything
+type redirectPattern struct {
+	scheme string
+	host   *regexp.Regexp
+
+	// nil for origins
+	path *regexp.Regexp
+}
+
+var redirectPatterns []*redirectPattern
+var redirectPatternsOnce sync.Once
+
+func getRedirectPatterns() []*redirectPattern {
+	redirectPatternsOnce.Do(func() {
+		for _, entry := range config.GetConfig().GetStringSlice("oauth.redirect_uris") {
+			pattern, err := parseRedirectPattern(entry)
+
+			if err != nil {
+				glog.Error("oauth.redirect_uris: ", entry, ": ", err)
+				continue
+			}
+
+			redirectPatterns = append(redirectPatterns, pattern)
+		}
+	})
+
+	return redirectPatterns
+}
+
+func parseRedirectPattern(entry string) (*redirectPattern, error) {
+
+	parts := strings.SplitN(entry, "://", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("scheme and host required")
+	}
+
+	if strings.ContainsAny(parts[1], "#@") {
+		return nil, errors.New("fragments and user info are not allowed")
+	}
+
+	host, path := parts[1], ""
+
+	if i := strings.IndexAny(host, "/?"); i >= 0 {
+		host, path = host[:i], host[i:]
+	}
+
+	pattern := &redirectPattern{
+		scheme: strings.ToLower(parts[0]),
+		host:   compileWildcard(strings.ToLower(host), `[a-z0-9-]+`),
+	}
+
+	if path != "" {
+		pattern.path = compileWildcard(path, `.*`)
+	}
+
+	return pattern, nil
+}
+
+// compileWildcard matches the whole string with * replaced by the expression.
+func compileWildcard(s, wildcard string) *regexp.Regexp {
+
+	parts := strings.Split(s, "*")
+
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+
+	return regexp.MustCompile("^" + strings.Join(parts, wildcard) + "$")
+}
+
+func (pattern *redirectPattern) match(u *url.URL) bool {
+
+	if u.Scheme != pattern.scheme || !pattern.host.MatchString(strings.ToLower(u.Host)) {
+		return false
+	}
+
+	if pattern.path == nil {
+		return true
+	}
+
+	path := u.EscapedPath()
+
+	if u.RawQuery != "" || u.ForceQuery {
+		path += "?" + u.RawQuery
+	}
+
+	return pattern.path.MatchString(path)
+}
+
+// ValidateRedirectURI checks the redirect uri against the allowlist in config,
+// nothing is allowed if the list is empty.
+func ValidateRedirectURI(redirectURI string) error {
+
+	u, err := url.Parse(redirectURI)
+
+	if err != nil || !u.IsAbs() || u.Host == "" || u.User != nil || u.Fragment != "" || u.Opaque != "" {
+		return ErrRedirectURINotAllowed
+	}
+
+	for _, pattern := range getRedirectPatterns() {
+		if pattern.match(u) {
+			return nil
+		}
+	}
+
+	return ErrRedirectURINotAllowed
+}
+
+// CodeChallenge returns the S256 challenge of the PKCE code verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidateCodeChallenge checks the challenge sent by a client, only S256 is supported
+// since the plain method doesn't protect the code.
+func ValidateCodeChallenge(challenge, method string) error {
+
+	if method != CodeChallengeMethodS256 {
+		return ErrInvalidCodeChallenge
+	}
+
+	if decoded, err := base64.RawURLEncoding.DecodeString(challenge); err != nil || len(decoded) != sha256.Size {
+		return ErrInvalidCodeChallenge
+	}
+
+	return nil
+}
+
+// VerifyCodeVerifier checks the verifier of a client against its challenge.
+func VerifyCodeVerifier(challenge, verifier string) bool {
+
+	// RFC 7636 verifiers have 43 to 128 characters
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(CodeChallenge(verifier)), []byte(challenge)) == 1
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/primasio/wormhole/models"
+	"golang.org/x/oauth2"
+)
+
+const ProviderTwitter = "twitter"
+
+var twitterEndpoint = oauth2.Endpoint{
+	AuthURL:  "https://twitter.com/i/oauth2/authorize",
+	TokenURL: "https://api.twitter.com/2/oauth2/token",
+}
+
+type TwitterUserResponse struct {
+	Data struct {
+		Id              string `json:"id"`
+		Name            string `json:"name"`
+		Username        string `json:"username"`
+		ProfileImageURL string `json:"profile_image_url"`
+	} `json:"data"`
+}
+
+// NewTwitterProvider uses OAuth 2.0 of Twitter, which requires PKCE.
+// Twitter doesn't share the email of the user.
+func NewTwitterProvider(c *ProviderConfig) Provider {
+
+	provider := newOAuth2Provider(ProviderTwitter, c, twitterEndpoint, []string{"users.read", "tweet.read"}, "https://api.twitter.com")
+	provider.pkce = true
+	provider.fetchUser = fetchTwitterUser
+
+	return provider
+}
+
+func fetchTwitterUser(ctx context.Context, client *http.Client, apiURL string) (*OAuthResult, error) {
+
+	user := &TwitterUserResponse{}
+
+	if err := getJSON(ctx, client, apiURL+"/2/users/me?user.fields=profile_image_url", user); err != nil {
+		return nil, err
+	}
+
+	result := &OAuthResult{
+		Type:      models.OAuthTwitter,
+		Id:        user.Data.Id,
+		Name:      user.Data.Name,
+		AvatarURL: user.Data.ProfileImageURL,
+	}
+
+	if result.Name == "" {
+		result.Name = user.Data.Username
+	}
+
+	// Twitter serves a 48px avatar by default, the original size is without the suffix
+	result.AvatarURL = strings.Replace(result.AvatarURL, "_normal.", ".", 1)
+
+	return result, nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/http/oauth"
+	"github.com/primasio/wormhole/models"
+)
+
+func TestTwitterProvider(t *testing.T) {
+	fake := newFakeProvider(t, map[string]interface{}{
+		"/2/users/me": map[string]interface{}{
+			"data": map[string]interface{}{
+				"id":                "2244994945",
+				"name":              "",
+				"username":          "TwitterDev",
+				"profile_image_url": "https://pbs.twimg.com/profile_images/1445764922474827784/W2zEPN7U_normal.jpg",
+			},
+		},
+	})
+	defer fake.Close()
+
+	provider := oauth.NewTwitterProvider(fake.Config())
+	assert.Equal(t, provider.Name(), oauth.ProviderTwitter)
+
+	// The code challenge is derived from the verifier
+
+	authURL, err := url.Parse(provider.AuthCodeURL("state", "verifier"))
+	assert.Equal(t, err, nil)
+
+	sum := sha256.Sum256([]byte("verifier"))
+	assert.Equal(t, authURL.Query().Get("code_challenge"), base64.RawURLEncoding.EncodeToString(sum[:]))
+	assert.Equal(t, authURL.Query().Get("code_challenge_method"), "S256")
+
+	result := fake.Exchange(provider, "verifier")
+	assert.Equal(t, fake.tokenForm.Get("code_verifier"), "verifier")
+
+	assert.Equal(t, result.Type, uint(models.OAuthTwitter))
+	assert.Equal(t, result.Id, "2244994945")
+	assert.Equal(t, result.Name, "TwitterDev")
+	assert.Equal(t, result.Email, "")
+	assert.Equal(t, result.AvatarURL, "https://pbs.twimg.com/profile_images/1445764922474827784/W2zEPN7U.jpg")
+}
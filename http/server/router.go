@@ -17,12 +17,15 @@
 package server
 
 import (
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/primasio/wormhole/config"
 	"github.com/primasio/wormhole/http/controllers/api/v1"
 	"github.com/primasio/wormhole/http/middlewares"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/storage"
 	"github.com/szuecs/gin-glog"
 )
 
@@ -33,6 +36,7 @@ func NewRouter() *gin.Engine {
 	router := gin.New()
 	router.Use(ginglog.Logger(3 * time.Second))
 	router.Use(gin.Recovery())
+	router.Use(middlewares.RequestIDMiddleware())
 
 	// CORS config
 	c := config.GetConfig()
@@ -45,38 +49,114 @@ func NewRouter() *gin.Engine {
 		AllowCredentials: c.GetBool("cors.allow_credentials"),
 	}))
 
+	// Blobs of the local storage like avatars
+
+	if c.GetString("storage.type") != storage.StorageTypeS3 {
+		if route := c.GetString("storage.local.route"); route != "" {
+			router.Static(route, c.GetString("storage.local.dir"))
+		}
+	}
+
 	v1g := router.Group("v1")
 	{
+		// Rate limits of the route groups are configured by rate_limit.groups,
+		// the endpoints of credentials are limited by the auth group as well
+
+		authLimit := middlewares.RateLimitMiddleware("auth")
+
+		adminLimit := middlewares.RateLimitMiddleware("admin")
+
 		// OAuth 2.0 endpoints
 
 		oauthCtrl := new(v1.OAuthController)
 
-		oauthGroup := v1g.Group("oauth")
+		oauthGroup := v1g.Group("oauth", middlewares.RateLimitMiddleware("oauth"))
 		{
-			oauthGroup.GET("google", oauthCtrl.GoogleAuth)
-			oauthGroup.GET("callback/google", oauthCtrl.GoogleAuthCallback)
+			oauthGroup.GET("/:provider", oauthCtrl.Auth)
+			oauthGroup.POST("/token", authLimit, oauthCtrl.Token)
+
+			// The callbacks share the wildcard segment of the providers
+			oauthGroup.GET("/:provider/:callback_provider", routeByParam("provider", map[string][]gin.HandlerFunc{
+				"callback": {oauthCtrl.Callback},
+			}, notFound))
 		}
 
 		// User endpoints
 
 		userCtrl := new(v1.UserController)
 
-		userGroup := v1g.Group("users")
+		sessionCtrl := new(v1.SessionController)
+
+		twoFactorCtrl := new(v1.TwoFactorController)
+
+		loginLockoutCtrl := new(v1.LoginLockoutController)
+
+		roleCtrl := new(v1.RoleController)
+
+		userGroup := v1g.Group("users", middlewares.RateLimitMiddleware("users"))
 		{
-			userGroup.POST("/auth", userCtrl.Auth)
-			userGroup.POST("", userCtrl.Create)
+			userGroup.POST("/auth", authLimit, userCtrl.Auth)
+			userGroup.POST("/auth/2fa", authLimit, twoFactorCtrl.Login)
+			userGroup.POST("/token/refresh", authLimit, sessionCtrl.Refresh)
+			userGroup.POST("", authLimit, userCtrl.Create)
+			userGroup.POST("/email/verification", authLimit, userCtrl.VerifyEmail)
+			userGroup.POST("/password/forgot", authLimit, userCtrl.ForgotPassword)
+			userGroup.POST("/password/reset", authLimit, userCtrl.ResetPassword)
+
+			// The session list shares the path of the public profiles
+			userGroup.GET("/:id", routeByParam("id", map[string][]gin.HandlerFunc{
+				"sessions": {middlewares.AuthMiddleware(), sessionCtrl.List},
+				"oauth":    {middlewares.AuthMiddleware(), oauthCtrl.Identities},
+				"lockouts": {middlewares.RequirePermission(models.PermissionManageUsers), adminLimit, loginLockoutCtrl.List},
+				"roles":    {middlewares.RequirePermission(models.PermissionManageRoles), adminLimit, roleCtrl.List},
+			}, userCtrl.GetPublic))
+			userGroup.GET("/:id/comments", userCtrl.Comments)
+		}
 
-			userGroup.Use(middlewares.AuthMiddleware())
-			{
-				userGroup.GET("", userCtrl.Get)
-			}
+		userGroupAuthorized := v1g.Group("users").Use(middlewares.AuthMiddleware(), middlewares.RateLimitMiddleware("users"))
+		{
+			userGroupAuthorized.GET("", userCtrl.Get)
+			userGroupAuthorized.PATCH("", userCtrl.Update)
+			userGroupAuthorized.DELETE("", userCtrl.Delete)
+			userGroupAuthorized.POST("/avatar", userCtrl.UploadAvatar)
+			userGroupAuthorized.PUT("/email", userCtrl.UpdateEmail)
+			userGroupAuthorized.PUT("/password", userCtrl.ChangePassword)
+			userGroupAuthorized.POST("/2fa", twoFactorCtrl.Enroll)
+			userGroupAuthorized.POST("/2fa/confirmation", twoFactorCtrl.Confirm)
+			userGroupAuthorized.POST("/2fa/recovery_codes", twoFactorCtrl.RecoveryCodes)
+			userGroupAuthorized.DELETE("/2fa", twoFactorCtrl.Disable)
+			userGroupAuthorized.POST("/logout", sessionCtrl.Logout)
+			userGroupAuthorized.DELETE("/sessions/:session_id", sessionCtrl.Delete)
+			userGroupAuthorized.POST("/oauth/:provider", oauthCtrl.Link)
+			userGroupAuthorized.POST("/oauth/:provider/confirmation", oauthCtrl.ConfirmLink)
+			userGroupAuthorized.DELETE("/oauth/:provider", oauthCtrl.Unlink)
+		}
+
+		userGroupAdmin := v1g.Group("users")
+		{
+			userGroupAdmin.POST("/merge", middlewares.RequirePermission(models.PermissionManageUsers), adminLimit, userCtrl.Merge)
+			userGroupAdmin.POST("/integration", middlewares.RequirePermission(models.PermissionAdjustIntegration), adminLimit, userCtrl.AdjustIntegration)
+
+			// The static admin key is only accepted here to grant the first admin
+			userGroupAdmin.POST("/roles", middlewares.RequirePermission(models.PermissionManageRoles), adminLimit, roleCtrl.Grant)
+			userGroupAdmin.DELETE("/roles", middlewares.RequirePermission(models.PermissionManageRoles), adminLimit, roleCtrl.Revoke)
+		}
+
+		// Audit log endpoints
+
+		auditLogCtrl := new(v1.AuditLogController)
+
+		auditLogGroup := v1g.Group("audit_logs").Use(middlewares.RequirePermission(models.PermissionViewAuditLog), adminLimit)
+		{
+			auditLogGroup.GET("", auditLogCtrl.List)
+			auditLogGroup.GET("/export", auditLogCtrl.Export)
 		}
 
 		// Article endpoints
 
 		articleCtrl := new(v1.ArticleController)
 
-		articleGroupAuthorized := v1g.Group("articles").Use(middlewares.AuthMiddleware())
+		articleGroupAuthorized := v1g.Group("articles").Use(middlewares.AuthMiddleware(), middlewares.RateLimitMiddleware("articles"))
 		{
 			articleGroupAuthorized.GET("/:article_id", articleCtrl.Get)
 			articleGroupAuthorized.POST("", articleCtrl.Publish)
@@ -86,28 +166,32 @@ func NewRouter() *gin.Engine {
 
 		domainController := new(v1.DomainController)
 
-		domainGroup := v1g.Group("domains")
+		domainGroup := v1g.Group("domains", middlewares.RateLimitMiddleware("domains"))
 		{
 			domainGroup.GET("", domainController.List)
 			domainGroup.GET("/domain", domainController.Get)
+			domainGroup.GET("/domain/transitions", domainController.Transitions)
 		}
 
-		domainGroupAuthorized := v1g.Group("domains").Use(middlewares.AuthMiddleware())
+		domainGroupAuthorized := v1g.Group("domains").Use(middlewares.AuthMiddleware(), middlewares.RateLimitMiddleware("domains"))
 		{
 			domainGroupAuthorized.POST("", domainController.Create)
 			domainGroupAuthorized.PUT("/domain", domainController.Vote)
+			domainGroupAuthorized.DELETE("/domain", domainController.Withdraw)
 		}
 
-		domainGroupAdmin := v1g.Group("domains").Use(middlewares.AdminAuthMiddleware())
+		domainGroupAdmin := v1g.Group("domains").Use(middlewares.RequirePermission(models.PermissionManageDomains), adminLimit)
 		{
 			domainGroupAdmin.POST("/domain/approval", domainController.Approve)
+			domainGroupAdmin.POST("/domain/rejection", domainController.Reject)
+			domainGroupAdmin.POST("/domain/deactivation", domainController.Deactivate)
 		}
 
 		// URL Content endpoints
 
 		urlContentController := new(v1.URLContentController)
 
-		urlContentGroup := v1g.Group("urls")
+		urlContentGroup := v1g.Group("urls", middlewares.RateLimitMiddleware("urls"))
 		{
 			urlContentGroup.GET("", urlContentController.List)
 			urlContentGroup.GET("/url", urlContentController.Get)
@@ -117,14 +201,17 @@ func NewRouter() *gin.Engine {
 
 		urlContentCommentCtrl := new(v1.URLContentCommentController)
 
-		urlContentCommentGroup := v1g.Group("comments")
+		urlContentCommentGroup := v1g.Group("comments", middlewares.RateLimitMiddleware("comments"))
 		{
 			urlContentCommentGroup.GET("", urlContentCommentCtrl.List)
+			urlContentCommentGroup.GET("/:comment_id/replies", urlContentCommentCtrl.Replies)
+			urlContentCommentGroup.GET("/:comment_id/revisions", urlContentCommentCtrl.Revisions)
 		}
 
-		urlContentCommentGroupAuthorized := v1g.Group("comments").Use(middlewares.AuthMiddleware())
+		urlContentCommentGroupAuthorized := v1g.Group("comments").Use(middlewares.AuthMiddleware(), middlewares.RateLimitMiddleware("comments"))
 		{
 			urlContentCommentGroupAuthorized.POST("", urlContentCommentCtrl.Create)
+			urlContentCommentGroupAuthorized.PUT("/:comment_id", urlContentCommentCtrl.Update)
 			urlContentCommentGroupAuthorized.DELETE("/:comment_id", urlContentCommentCtrl.Delete)
 
 			urlContentCommentVoteCtrl := new(v1.URLContentCommentVoteController)
@@ -133,7 +220,7 @@ func NewRouter() *gin.Engine {
 			urlContentCommentGroupAuthorized.DELETE("/:comment_id/votes", urlContentCommentVoteCtrl.Delete)
 		}
 
-		authorizedUserGroup := v1g.Group("authorized").Use(middlewares.AuthMiddleware())
+		authorizedUserGroup := v1g.Group("authorized").Use(middlewares.AuthMiddleware(), middlewares.RateLimitMiddleware("comments"))
 		{
 			authorizedUserGroup.GET("/comments", urlContentCommentCtrl.ListWithVote)
 		}
@@ -141,3 +228,27 @@ func NewRouter() *gin.Engine {
 
 	return router
 }
+
+// routeByParam runs the handlers of the static values of the param
+// and falls back to the given handlers, since httprouter can't register
+// a static path next to a wildcard in the same segment.
+func routeByParam(param string, routes map[string][]gin.HandlerFunc, handlers ...gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+
+		chain, ok := routes[c.Param(param)]
+
+		if !ok {
+			chain = handlers
+		}
+
+		for _, handler := range chain {
+			if handler(c); c.IsAborted() {
+				return
+			}
+		}
+	}
+}
+
+func notFound(c *gin.Context) {
+	c.AbortWithStatus(http.StatusNotFound)
+}
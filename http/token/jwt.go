@@ -0,0 +1,419 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/primasio/wormhole/cache"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/util"
+)
+
+const (
+	// Access tokens are random keys looked up in the cache
+	ModeSession = "session"
+
+	// Access tokens are signed JWTs verified without the cache
+	ModeJWT = "jwt"
+)
+
+const jwtDenylistPrefix = "wormhole_jwt_denylist_"
+
+const (
+	JWTAlgorithmHS256 = "HS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+var ErrTokenExpired = errors.New("token expired")
+
+// Claims carried by the access tokens
+type Claims struct {
+	Subject   string `json:"sub"`
+	SessionID string `json:"sid,omitempty"`
+	Issuer    string `json:"iss,omitempty"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	ID        string `json:"jti"`
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// JWTKey is a signing key in config. HS256 keys have a secret,
+// EdDSA keys have a base64 encoded ed25519 seed as the private key,
+// or only the public key if the key is kept for verification after rotation.
+type JWTKey struct {
+	ID         string `mapstructure:"kid"`
+	Algorithm  string `mapstructure:"algorithm"`
+	Secret     string `mapstructure:"secret"`
+	PrivateKey string `mapstructure:"private_key"`
+	PublicKey  string `mapstructure:"public_key"`
+
+	secret     []byte
+	privateKey ed25519.PrivateKey
+	publicKey  ed25519.PublicKey
+}
+
+// JWTSigner signs tokens with the current key and verifies them
+// with any key found by the kid in the header.
+type JWTSigner struct {
+	issuer  string
+	current *JWTKey
+	keys    map[string]*JWTKey
+}
+
+func NewJWTSigner(issuer, currentKeyID string, keys []JWTKey) (*JWTSigner, error) {
+
+	signer := &JWTSigner{issuer: issuer, keys: make(map[string]*JWTKey)}
+
+	for i := range keys {
+		key := &keys[i]
+
+		if err := key.init(); err != nil {
+			return nil, err
+		}
+
+		signer.keys[key.ID] = key
+	}
+
+	current, ok := signer.keys[currentKeyID]
+
+	if !ok {
+		return nil, errors.New("jwt: current key " + currentKeyID + " not found")
+	}
+
+	if current.secret == nil && current.privateKey == nil {
+		return nil, errors.New("jwt: current key " + currentKeyID + " can't sign")
+	}
+
+	signer.current = current
+
+	return signer, nil
+}
+
+func (key *JWTKey) init() error {
+
+	if key.ID == "" {
+		return errors.New("jwt: key id is empty")
+	}
+
+	switch key.Algorithm {
+	case JWTAlgorithmHS256:
+		if len(key.Secret) < 32 {
+			return errors.New("jwt: secret of key " + key.ID + " must have at least 32 bytes")
+		}
+
+		key.secret = []byte(key.Secret)
+
+	case JWTAlgorithmEdDSA:
+		if key.PrivateKey != "" {
+			seed, err := base64.StdEncoding.DecodeString(key.PrivateKey)
+
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return errors.New("jwt: invalid private key of key " + key.ID)
+			}
+
+			key.privateKey = ed25519.NewKeyFromSeed(seed)
+			key.publicKey = key.privateKey.Public().(ed25519.PublicKey)
+		} else {
+			public, err := base64.StdEncoding.DecodeString(key.PublicKey)
+
+			if err != nil || len(public) != ed25519.PublicKeySize {
+				return errors.New("jwt: invalid public key of key " + key.ID)
+			}
+
+			key.publicKey = public
+		}
+
+	default:
+		return errors.New("jwt: unsupported algorithm " + key.Algorithm)
+	}
+
+	return nil
+}
+
+func (key *JWTKey) sign(data []byte) []byte {
+	if key.Algorithm == JWTAlgorithmHS256 {
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write(data)
+		return mac.Sum(nil)
+	}
+
+	return ed25519.Sign(key.privateKey, data)
+}
+
+func (key *JWTKey) verify(data, signature []byte) bool {
+	if key.Algorithm == JWTAlgorithmHS256 {
+		return hmac.Equal(key.sign(data), signature)
+	}
+
+	return ed25519.Verify(key.publicKey, data, signature)
+}
+
+func (s *JWTSigner) Sign(claims *Claims) (string, error) {
+
+	claims.Issuer = s.issuer
+
+	header, err := json.Marshal(&jwtHeader{Algorithm: s.current.Algorithm, Type: "JWT", KeyID: s.current.ID})
+
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodeSegment(header) + "." + encodeSegment(payload)
+	signature := s.current.sign([]byte(signingInput))
+
+	return signingInput + "." + encodeSegment(signature), nil
+}
+
+// Verify checks the signature and expiry of the token.
+// The algorithm is decided by the key, not by the header of the token.
+func (s *JWTSigner) Verify(token string) (*Claims, error) {
+
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	headerData, err := decodeSegment(parts[0])
+
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	header := &jwtHeader{}
+
+	if err := json.Unmarshal(headerData, header); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := s.keys[header.KeyID]
+
+	if !ok || header.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := decodeSegment(parts[2])
+
+	if err != nil || !key.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := decodeSegment(parts[1])
+
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{}
+
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Issuer != s.issuer || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	return claims, nil
+}
+
+// IsJWT tells whether the token looks like a JWT instead of a session key.
+func IsJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+func encodeSegment(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeSegment(segment string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(segment)
+}
+
+var jwtSigner *JWTSigner
+var jwtSignerErr error
+var jwtSignerOnce sync.Once
+
+// GetMode returns the token mode in config, session by default.
+func GetMode() string {
+	if config.GetConfig().GetString("token.mode") == ModeJWT {
+		return ModeJWT
+	}
+
+	return ModeSession
+}
+
+// GetJWTSigner loads the signing keys in config:
+//
+//	token:
+//	  jwt:
+//	    issuer: wormhole
+//	    current_kid: "2026-10"
+//	    keys:
+//	      - kid: "2026-10"
+//	        algorithm: EdDSA
+//	        private_key: <base64 ed25519 seed>
+//	      - kid: "2026-04"
+//	        algorithm: HS256
+//	        secret: <at least 32 bytes>
+func GetJWTSigner() (*JWTSigner, error) {
+	jwtSignerOnce.Do(func() {
+		c := config.GetConfig()
+
+		var keys []JWTKey
+
+		if jwtSignerErr = c.UnmarshalKey("token.jwt.keys", &keys); jwtSignerErr != nil {
+			return
+		}
+
+		jwtSigner, jwtSignerErr = NewJWTSigner(c.GetString("token.jwt.issuer"), c.GetString("token.jwt.current_kid"), keys)
+	})
+
+	return jwtSigner, jwtSignerErr
+}
+
+// Authenticate resolves the access token to the user and session,
+// the user id is empty if the token is invalid.
+// JWTs are verified without the cache unless the denylist is enabled.
+func Authenticate(accessToken string) (err error, userId string, sessionId string) {
+
+	if GetMode() != ModeJWT || !IsJWT(accessToken) {
+		return cache.SessionGetWithID(accessToken)
+	}
+
+	signer, err := GetJWTSigner()
+
+	if err != nil {
+		return err, "", ""
+	}
+
+	claims, err := signer.Verify(accessToken)
+
+	if err == ErrInvalidToken || err == ErrTokenExpired {
+		return nil, "", ""
+	}
+
+	if err != nil {
+		return err, "", ""
+	}
+
+	if denylistEnabled() {
+		var value string
+
+		err := cache.GetCache().Get(jwtDenylistPrefix+claims.ID, &value)
+
+		if err == nil {
+			return nil, "", ""
+		}
+
+		if err != cache.ErrCacheMiss && err != cache.ErrNotStored {
+			return err, "", ""
+		}
+	}
+
+	return nil, claims.Subject, claims.SessionID
+}
+
+// RevokeAccessToken stops the access token from working.
+// JWTs can only be revoked if the denylist is enabled,
+// otherwise they stay valid until they expire.
+func RevokeAccessToken(accessToken string) error {
+
+	if !IsJWT(accessToken) {
+		return cache.SessionDelete(accessToken)
+	}
+
+	if !denylistEnabled() {
+		return nil
+	}
+
+	signer, err := GetJWTSigner()
+
+	if err != nil {
+		return err
+	}
+
+	claims, err := signer.Verify(accessToken)
+
+	if err == ErrInvalidToken || err == ErrTokenExpired {
+		// Nothing to revoke
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// The entry is kept only as long as the token would be valid
+	return cache.GetCache().Set(jwtDenylistPrefix+claims.ID, "1", time.Until(time.Unix(claims.ExpiresAt, 0)))
+}
+
+func denylistEnabled() bool {
+	return config.GetConfig().GetBool("token.jwt.denylist")
+}
+
+func issueJWT(session *cache.Session, ttl time.Duration) (string, error) {
+
+	signer, err := GetJWTSigner()
+
+	if err != nil {
+		return "", err
+	}
+
+	jti, err := util.NewID()
+
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	claims := &Claims{
+		Subject:   session.UserID,
+		SessionID: session.ID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+		ID:        jti,
+	}
+
+	return signer.Sign(claims)
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package token_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/http/token"
+)
+
+const testSecret = "wormhole-test-secret-of-at-least-32-bytes"
+const testSeed = "U1CT2jXGKlYCqr6vGXAFfGxsYPj5HP4+uW9ZDbUUK00="
+
+func newClaims(ttl time.Duration) *token.Claims {
+	return &token.Claims{
+		Subject:   "1",
+		SessionID: "session",
+		IssuedAt:  time.Now().Unix(),
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+		ID:        "jti",
+	}
+}
+
+func TestJWTSigner_SignVerify(t *testing.T) {
+	for _, key := range []token.JWTKey{
+		{ID: "hs", Algorithm: token.JWTAlgorithmHS256, Secret: testSecret},
+		{ID: "ed", Algorithm: token.JWTAlgorithmEdDSA, PrivateKey: testSeed},
+	} {
+		signer, err := token.NewJWTSigner("wormhole", key.ID, []token.JWTKey{key})
+		assert.Equal(t, err, nil)
+
+		signed, err := signer.Sign(newClaims(time.Minute))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, token.IsJWT(signed), true)
+
+		claims, err := signer.Verify(signed)
+		assert.Equal(t, err, nil)
+		assert.Equal(t, claims.Subject, "1")
+		assert.Equal(t, claims.SessionID, "session")
+
+		// Tampered payload
+		parts := strings.Split(signed, ".")
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(payload), `"sub":"1"`, `"sub":"2"`, 1)))
+
+		_, err = signer.Verify(strings.Join(parts, "."))
+		assert.Equal(t, err, token.ErrInvalidToken)
+
+		expired, _ := signer.Sign(newClaims(-time.Minute))
+		_, err = signer.Verify(expired)
+		assert.Equal(t, err, token.ErrTokenExpired)
+	}
+}
+
+func TestJWTSigner_Rotation(t *testing.T) {
+	seed, _ := base64.StdEncoding.DecodeString(testSeed)
+	public := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+
+	oldKey := token.JWTKey{ID: "old", Algorithm: token.JWTAlgorithmEdDSA, PrivateKey: testSeed}
+	newKey := token.JWTKey{ID: "new", Algorithm: token.JWTAlgorithmHS256, Secret: testSecret}
+
+	oldSigner, err := token.NewJWTSigner("wormhole", "old", []token.JWTKey{oldKey})
+	assert.Equal(t, err, nil)
+
+	oldToken, _ := oldSigner.Sign(newClaims(time.Minute))
+
+	// The retired key is kept for verification by its public key
+	retired := token.JWTKey{ID: "old", Algorithm: token.JWTAlgorithmEdDSA, PublicKey: base64.StdEncoding.EncodeToString(public)}
+
+	signer, err := token.NewJWTSigner("wormhole", "new", []token.JWTKey{newKey, retired})
+	assert.Equal(t, err, nil)
+
+	_, err = signer.Verify(oldToken)
+	assert.Equal(t, err, nil)
+
+	newToken, _ := signer.Sign(newClaims(time.Minute))
+
+	_, err = oldSigner.Verify(newToken)
+	assert.Equal(t, err, token.ErrInvalidToken)
+
+	// Retired keys can't sign
+	_, err = token.NewJWTSigner("wormhole", "old", []token.JWTKey{retired})
+	assert.Equal(t, err != nil, true)
+
+	// Tokens of other issuers are rejected
+	otherSigner, _ := token.NewJWTSigner("other", "new", []token.JWTKey{newKey})
+	otherToken, _ := otherSigner.Sign(newClaims(time.Minute))
+
+	_, err = signer.Verify(otherToken)
+	assert.Equal(t, err, token.ErrInvalidToken)
+}
+
+func TestJWTSigner_AlgorithmConfusion(t *testing.T) {
+	key := token.JWTKey{ID: "ed", Algorithm: token.JWTAlgorithmEdDSA, PrivateKey: testSeed}
+
+	signer, err := token.NewJWTSigner("wormhole", "ed", []token.JWTKey{key})
+	assert.Equal(t, err, nil)
+
+	signed, _ := signer.Sign(newClaims(time.Minute))
+	parts := strings.Split(signed, ".")
+
+	for _, header := range []string{`{"alg":"none","typ":"JWT","kid":"ed"}`, `{"alg":"HS256","typ":"JWT","kid":"ed"}`} {
+		forged := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + parts[1] + "." + parts[2]
+
+		_, err = signer.Verify(forged)
+		assert.Equal(t, err, token.ErrInvalidToken)
+	}
+
+	_, err = token.NewJWTSigner("wormhole", "hs", []token.JWTKey{{ID: "hs", Algorithm: token.JWTAlgorithmHS256, Secret: "short"}})
+	assert.Equal(t, err != nil, true)
+}
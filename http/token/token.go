@@ -17,29 +17,335 @@
 package token
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/primasio/wormhole/cache"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/util"
 )
 
+// Number of used refresh tokens remembered to detect reuse
+const maxUsedRefreshTokens = 20
+
+// Last seen time of a session is updated at most once in this interval
+const touchInterval = time.Minute
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token")
+var ErrRefreshTokenReused = errors.New("refresh token reused, session revoked")
+
 type Token struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	SessionID    string `json:"session_id,omitempty"`
+}
+
+// Client describes where a session is used
+type Client struct {
+	Device    string
+	IP        string
+	UserAgent string
 }
 
 /**
  * Create new token for a given user
  */
 func IssueToken(userId uint, expires bool) (error, *Token) {
+	return IssueSessionToken(userId, expires, &Client{})
+}
+
+// IssueSessionToken starts a new session of the user on the client,
+// short sessions expire in a day instead of a month.
+func IssueSessionToken(userId uint, expires bool, client *Client) (error, *Token) {
+
+	sessionId, err := util.NewID()
+
+	if err != nil {
+		return err, nil
+	}
+
+	now := time.Now()
+
+	session := &cache.Session{
+		ID:         sessionId,
+		UserID:     fmt.Sprint(userId),
+		Device:     client.Device,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		CreatedAt:  now.Unix(),
+		LastSeenAt: now.Unix(),
+		ExpiresAt:  now.Add(getRefreshTTL(expires)).Unix(),
+	}
+
+	return issue(session, false)
+}
+
+// Refresh rotates the refresh token and issues a new access token.
+// Presenting a refresh token that has already been used revokes the session,
+// since either the client or an attacker holds a stolen copy.
+// The session is locked so a refresh token is only accepted once.
+func Refresh(refreshToken string, client *Client) (error, *Token) {
+
+	parts := strings.SplitN(refreshToken, ".", 2)
+
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return ErrInvalidRefreshToken, nil
+	}
+
+	if err := cache.SessionLock(parts[0]); err != nil {
+		return err, nil
+	}
+
+	defer cache.SessionUnlock(parts[0])
 
-	err, token := cache.NewSessionKey()
+	err, session := cache.SessionInfoGet(parts[0])
+
+	if err == cache.ErrSessionNotFound {
+		return ErrInvalidRefreshToken, nil
+	}
 
 	if err != nil {
 		return err, nil
 	}
 
-	userIdStr := fmt.Sprint(userId)
-	cache.SessionSet(token, userIdStr, expires)
+	hash := hashRefreshSecret(parts[1])
+
+	if hash != session.RefreshTokenHash {
+
+		for _, used := range session.UsedRefreshHashes {
+			if used == hash {
+				if err := revoke(session); err != nil {
+					return err, nil
+				}
+
+				return ErrRefreshTokenReused, nil
+			}
+		}
+
+		return ErrInvalidRefreshToken, nil
+	}
+
+	session.UsedRefreshHashes = append(session.UsedRefreshHashes, hash)
 
-	tokenStruct := &Token{Token: token}
+	if len(session.UsedRefreshHashes) > maxUsedRefreshTokens {
+		session.UsedRefreshHashes = session.UsedRefreshHashes[1:]
+	}
+
+	session.LastSeenAt = time.Now().Unix()
+
+	if client.IP != "" {
+		session.IP = client.IP
+	}
+
+	if client.UserAgent != "" {
+		session.UserAgent = client.UserAgent
+	}
+
+	err, token := issue(session, true)
+
+	if err == cache.ErrSessionNotFound {
+		return ErrInvalidRefreshToken, nil
+	}
+
+	return err, token
+}
+
+// Touch records the activity of the session.
+func Touch(sessionId, ip, userAgent string) error {
+
+	err, session := cache.SessionInfoGet(sessionId)
+
+	if err == cache.ErrSessionNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if now.Sub(time.Unix(session.LastSeenAt, 0)) < touchInterval {
+		return nil
+	}
+
+	return cache.SessionTouch(session, now.Unix(), ip, userAgent)
+}
+
+func ListSessions(userId uint) (error, []*cache.Session) {
+	return cache.UserSessionList(fmt.Sprint(userId))
+}
+
+// RevokeSession ends the session of the user, its tokens stop working immediately.
+func RevokeSession(userId uint, sessionId string) error {
+
+	err, session := cache.SessionInfoGet(sessionId)
+
+	if err != nil {
+		return err
+	}
+
+	if session.UserID != fmt.Sprint(userId) {
+		return cache.ErrSessionNotFound
+	}
+
+	return endSession(session.ID)
+}
+
+// RevokeUserSessions ends all sessions of the user except the given one.
+func RevokeUserSessions(userId uint, exceptSessionId string) error {
+
+	err, sessions := ListSessions(userId)
+
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if session.ID == exceptSessionId {
+			continue
+		}
+
+		if err := endSession(session.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// issue saves the session with a new token pair, an existing session
+// is only updated so a session revoked in the meantime is not brought back.
+func issue(session *cache.Session, exists bool) (error, *Token) {
+
+	secret, err := util.RandToken(43)
+
+	if err != nil {
+		return err, nil
+	}
+
+	// The previous access token is replaced
+	if session.AccessToken != "" {
+		if err := RevokeAccessToken(session.AccessToken); err != nil {
+			return err, nil
+		}
+	}
+
+	accessTTL := getAccessTTL()
+
+	if remaining := time.Until(time.Unix(session.ExpiresAt, 0)); remaining < accessTTL {
+		accessTTL = remaining
+	}
+
+	var accessToken string
+
+	if GetMode() == ModeJWT {
+		accessToken, err = issueJWT(session, accessTTL)
+
+		if err != nil {
+			return err, nil
+		}
+	} else {
+		err, accessToken = cache.NewSessionKey()
+
+		if err != nil {
+			return err, nil
+		}
+
+		if err := cache.SessionSetWithID(accessToken, session.UserID, session.ID, accessTTL); err != nil {
+			return err, nil
+		}
+	}
+
+	session.AccessToken = accessToken
+	session.RefreshTokenHash = hashRefreshSecret(secret)
+
+	if exists {
+		err = cache.SessionInfoUpdate(session)
+	} else {
+		err = cache.SessionInfoSave(session)
+	}
+
+	if err != nil {
+		RevokeAccessToken(accessToken)
+		return err, nil
+	}
+
+	token := &Token{
+		Token:        accessToken,
+		RefreshToken: session.ID + "." + secret,
+		ExpiresIn:    int64(accessTTL / time.Second),
+		SessionID:    session.ID,
+	}
+
+	return nil, token
+}
+
+// endSession removes the session under its lock,
+// so a refresh in progress doesn't keep it alive.
+func endSession(sessionId string) error {
+
+	if err := cache.SessionLock(sessionId); err != nil {
+		return err
+	}
+
+	defer cache.SessionUnlock(sessionId)
+
+	// The access token may have been rotated since the session was listed
+	err, session := cache.SessionInfoGet(sessionId)
+
+	if err == cache.ErrSessionNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return revoke(session)
+}
+
+// revoke removes the session and its access token, the caller holds its lock.
+func revoke(session *cache.Session) error {
+
+	if err := RevokeAccessToken(session.AccessToken); err != nil {
+		return err
+	}
+
+	return cache.SessionInfoDelete(session)
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func getAccessTTL() time.Duration {
+	if ttl := config.GetConfig().GetDuration("session.access_ttl"); ttl > 0 {
+		return ttl
+	}
+
+	return time.Hour * 2
+}
+
+func getRefreshTTL(short bool) time.Duration {
+
+	if short {
+		if ttl := config.GetConfig().GetDuration("session.short_refresh_ttl"); ttl > 0 {
+			return ttl
+		}
+
+		return time.Hour * 24
+	}
+
+	if ttl := config.GetConfig().GetDuration("session.refresh_ttl"); ttl > 0 {
+		return ttl
+	}
 
-	return nil, tokenStruct
+	return time.Hour * 24 * 30
 }
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mail
+
+import (
+	"os"
+	"sync"
+
+	"github.com/golang/glog"
+)
+
+// Number of sent messages kept in memory
+const maxSentMessages = 100
+
+// FileMailer appends messages to a file, or writes them to the log
+// if the file is empty. It's meant for development and tests,
+// where the recently sent messages can be inspected by Sent.
+type FileMailer struct {
+	path string
+	from string
+
+	mutex sync.Mutex
+	sent  []*Message
+}
+
+func NewFileMailer(path, from string) *FileMailer {
+	return &FileMailer{path: path, from: from}
+}
+
+// Send (see Mailer interface)
+func (m *FileMailer) Send(msg *Message) error {
+
+	if msg.From == "" {
+		msg.From = m.from
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.path == "" {
+		glog.Infof("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	} else {
+		file, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+
+		if err != nil {
+			return err
+		}
+
+		data := append(msg.Bytes(), "\r\n\r\n"...)
+
+		if _, err := file.Write(data); err != nil {
+			file.Close()
+			return err
+		}
+
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+
+	m.sent = append(m.sent, msg)
+
+	if len(m.sent) > maxSentMessages {
+		m.sent = m.sent[1:]
+	}
+
+	return nil
+}
+
+// Sent returns the messages sent recently, oldest first.
+func (m *FileMailer) Sent() []*Message {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sent := make([]*Message, len(m.sent))
+	copy(sent, m.sent)
+
+	return sent
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mail_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/mail"
+)
+
+func TestFileMailer_Send(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mail")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.log")
+	mailer := mail.NewFileMailer(path, "Wormhole <no-reply@wormhole.im>")
+
+	err = mailer.Send(&mail.Message{To: "user@example.com", Subject: "Hello\r\nBcc: evil@example.com", Body: "line 1\nline 2"})
+	assert.Equal(t, err, nil)
+
+	data, err := ioutil.ReadFile(path)
+	assert.Equal(t, err, nil)
+
+	content := string(data)
+	assert.Equal(t, strings.Contains(content, "From: Wormhole <no-reply@wormhole.im>\r\n"), true)
+	assert.Equal(t, strings.Contains(content, "To: user@example.com\r\n"), true)
+	assert.Equal(t, strings.Contains(content, "\r\nBcc:"), false)
+	assert.Equal(t, strings.Contains(content, "\r\n\r\nline 1\r\nline 2"), true)
+
+	sent := mailer.Sent()
+	assert.Equal(t, len(sent), 1)
+	assert.Equal(t, sent[0].To, "user@example.com")
+}
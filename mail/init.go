@@ -0,0 +1,60 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mail
+
+import (
+	"errors"
+
+	"github.com/primasio/wormhole/config"
+)
+
+const (
+	MailerTypeSMTP = "smtp"
+	MailerTypeFile = "file"
+	MailerTypeLog  = "log"
+)
+
+var mailer Mailer
+
+func InitMailer() error {
+	c := config.GetConfig()
+
+	from := c.GetString("mail.from")
+
+	switch c.GetString("mail.type") {
+	case MailerTypeSMTP:
+		mailer = NewSMTPMailer(
+			c.GetString("mail.smtp.host"),
+			c.GetInt("mail.smtp.port"),
+			c.GetString("mail.smtp.username"),
+			c.GetString("mail.smtp.password"),
+			from,
+		)
+	case MailerTypeFile:
+		mailer = NewFileMailer(c.GetString("mail.file"), from)
+	case "", MailerTypeLog:
+		mailer = NewFileMailer("", from)
+	default:
+		return errors.New("unrecognized mailer type")
+	}
+
+	return nil
+}
+
+func GetMailer() Mailer {
+	return mailer
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mail
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is the interface of a mail backend
+type Mailer interface {
+	// Send delivers the message, the From of the message is set by the mailer if it's empty.
+	Send(msg *Message) error
+}
+
+// Bytes formats the message in RFC 5322 with CRLF line endings.
+func (msg *Message) Bytes() []byte {
+
+	var buf bytes.Buffer
+
+	writeHeader(&buf, "From", msg.From)
+	writeHeader(&buf, "To", msg.To)
+	writeHeader(&buf, "Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "MIME-Version", "1.0")
+	writeHeader(&buf, "Content-Type", "text/plain; charset=utf-8")
+	writeHeader(&buf, "Content-Transfer-Encoding", "8bit")
+
+	buf.WriteString("\r\n")
+
+	body := strings.Replace(msg.Body, "\r\n", "\n", -1)
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	return buf.Bytes()
+}
+
+func writeHeader(buf *bytes.Buffer, name, value string) {
+	// Line breaks in values would start new headers
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+
+	buf.WriteString(name + ": " + value + "\r\n")
+}
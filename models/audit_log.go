@@ -0,0 +1,102 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Actors of the audit log
+const (
+	AuditActorUser     = "user"
+	AuditActorAdminKey = "admin_key"
+)
+
+// Actions of the audit log
+const (
+	AuditActionDomainApprove     = "domain.approve"
+	AuditActionDomainReject      = "domain.reject"
+	AuditActionDomainDeactivate  = "domain.deactivate"
+	AuditActionCommentRemove     = "comment.remove"
+	AuditActionRoleGrant         = "role.grant"
+	AuditActionRoleRevoke        = "role.revoke"
+	AuditActionUserMerge         = "user.merge"
+	AuditActionIntegrationAdjust = "integration.adjust"
+)
+
+// Targets of the audit log, the target id is the unique id of users and comments
+// and the name of domains
+const (
+	AuditTargetDomain  = "domain"
+	AuditTargetComment = "comment"
+	AuditTargetUser    = "user"
+)
+
+var ErrAuditLogAppendOnly = errors.New("audit logs can't be changed")
+
+// AuditLog records an action of moderators and admins. It's written in the
+// transaction of the action and never changed, the states before and after
+// the action are kept as json.
+type AuditLog struct {
+	ID         uint   `gorm:"primary_key" json:"-"`
+	CreatedAt  uint   `gorm:"index" json:"created_at"`
+	ActorType  string `gorm:"type:varchar(16)" json:"actor_type"`
+	ActorID    uint   `gorm:"index" json:"-"`
+	Action     string `gorm:"type:varchar(64);index" json:"action"`
+	TargetType string `gorm:"type:varchar(32);index:idx_audit_log_target" json:"target_type"`
+	TargetID   string `gorm:"type:varchar(255);index:idx_audit_log_target" json:"target_id"`
+	Before     string `gorm:"type:text" json:"-"`
+	After      string `gorm:"type:text" json:"-"`
+	IP         string `gorm:"type:varchar(64)" json:"ip"`
+	RequestID  string `gorm:"type:varchar(64);index" json:"request_id"`
+}
+
+func (log *AuditLog) BeforeCreate() error {
+	log.CreatedAt = uint(time.Now().Unix())
+	return nil
+}
+
+func (log *AuditLog) BeforeUpdate() error {
+	return ErrAuditLogAppendOnly
+}
+
+func (log *AuditLog) BeforeDelete() error {
+	return ErrAuditLogAppendOnly
+}
+
+// SetStates keeps the states of the target before and after the action as json.
+func (log *AuditLog) SetStates(before, after interface{}) error {
+
+	beforeJSON, err := json.Marshal(before)
+
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := json.Marshal(after)
+
+	if err != nil {
+		return err
+	}
+
+	log.Before = string(beforeJSON)
+	log.After = string(afterJSON)
+
+	return nil
+}
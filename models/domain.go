@@ -22,7 +22,10 @@ import (
 	"errors"
 	"github.com/jinzhu/gorm"
 	"github.com/primasio/wormhole/db"
+	"golang.org/x/net/publicsuffix"
 	"net/url"
+	"path"
+	"strings"
 )
 
 type Domain struct {
@@ -34,6 +37,114 @@ type Domain struct {
 
 	IsActive bool `gorm:"default:false" json:"is_active"`
 	Votes    uint `gorm:"default:1" json:"votes"`
+
+	Status       string `gorm:"type:varchar(32);default:'pending'" json:"status"`
+	StatusReason string `gorm:"type:text" json:"status_reason"`
+
+	// Scope of the domain, parsed from Domain before saving
+	Host              string `gorm:"type:varchar(255);index" json:"host"`
+	PathPrefix        string `gorm:"type:varchar(255)" json:"path_prefix"`
+	IncludeSubdomains bool   `gorm:"default:false" json:"include_subdomains"`
+}
+
+var ErrPublicSuffixDomain = errors.New("domain is a public suffix")
+
+func (domain *Domain) BeforeSave() error {
+	domain.Host, domain.PathPrefix = ParseDomainRule(domain.Domain)
+	return nil
+}
+
+// Matches tells whether the domain covers the canonical host and path.
+func (domain *Domain) Matches(host, urlPath string) bool {
+
+	if host != domain.Host && !(domain.IncludeSubdomains && strings.HasSuffix(host, "."+domain.Host)) {
+		return false
+	}
+
+	if domain.PathPrefix == "" {
+		return true
+	}
+
+	// Prefixes only match whole path segments
+	return urlPath == domain.PathPrefix || strings.HasPrefix(urlPath, domain.PathPrefix+"/")
+}
+
+// isMoreSpecificThan compares two domains matching the same url,
+// longer hosts win over their parents and longer path prefixes win after that.
+func (domain *Domain) isMoreSpecificThan(other *Domain) bool {
+
+	if len(domain.Host) != len(other.Host) {
+		return len(domain.Host) > len(other.Host)
+	}
+
+	if len(domain.PathPrefix) != len(other.PathPrefix) {
+		return len(domain.PathPrefix) > len(other.PathPrefix)
+	}
+
+	// An exact host is more specific than a wildcard of it
+	return !domain.IncludeSubdomains && other.IncludeSubdomains
+}
+
+const (
+	DomainStatusPending     = "pending"
+	DomainStatusActive      = "active"
+	DomainStatusRejected    = "rejected"
+	DomainStatusDeactivated = "deactivated"
+)
+
+var ErrInvalidDomainTransition = errors.New("invalid domain status transition")
+
+// GetStatus returns the status of the domain,
+// IsActive takes precedence over the inactive statuses.
+func (domain *Domain) GetStatus() string {
+	if domain.IsActive {
+		return DomainStatusActive
+	}
+
+	if domain.Status == "" || domain.Status == DomainStatusActive {
+		return DomainStatusPending
+	}
+
+	return domain.Status
+}
+
+// Transit changes the status of the domain and returns the transition record.
+// Pending domains can be activated or rejected, active domains can be deactivated,
+// rejected and deactivated domains can only be activated by admins.
+func (domain *Domain) Transit(status, actor, reason string, userID uint) (error, *DomainTransition) {
+
+	from := domain.GetStatus()
+
+	valid := false
+
+	switch status {
+	case DomainStatusActive:
+		valid = from != DomainStatusActive
+	case DomainStatusRejected:
+		valid = from == DomainStatusPending
+	case DomainStatusDeactivated:
+		valid = from == DomainStatusActive
+	}
+
+	if !valid {
+		return ErrInvalidDomainTransition, nil
+	}
+
+	domain.Status = status
+	domain.StatusReason = reason
+	domain.IsActive = status == DomainStatusActive
+
+	transition := &DomainTransition{
+		DomainID:   domain.ID,
+		FromStatus: from,
+		ToStatus:   status,
+		Reason:     reason,
+		Actor:      actor,
+		UserID:     userID,
+		Votes:      domain.Votes,
+	}
+
+	return nil, transition
 }
 
 func ExtractDomainFromURL(urlStr string) (error, string) {
@@ -46,9 +157,132 @@ func ExtractDomainFromURL(urlStr string) (error, string) {
 	return nil, u.Host
 }
 
+// CleanDomain returns the canonical form of the domain,
+// which is the host with an optional path prefix like "medium.com/@author".
+// Scheme, port, query, "www." and trailing slashes are removed.
 func CleanDomain(domain string) string {
-	//TODO: Remove trailing slash, remove www, etc
-	return domain
+	host, pathPrefix := ParseDomainRule(domain)
+	return host + pathPrefix
+}
+
+// ParseDomainRule splits the domain into its canonical host and path prefix.
+func ParseDomainRule(domain string) (string, string) {
+
+	host, err := GetURLCanonicalizer().CanonicalizeHost(domain)
+
+	if err != nil {
+		return strings.TrimSpace(domain), ""
+	}
+
+	rest := strings.TrimSpace(domain)
+
+	if i := strings.Index(rest, "://"); i >= 0 {
+		rest = rest[i+3:]
+	}
+
+	if i := strings.IndexAny(rest, "?#"); i >= 0 {
+		rest = rest[:i]
+	}
+
+	i := strings.Index(rest, "/")
+
+	if i < 0 {
+		return host, ""
+	}
+
+	pathPrefix := path.Clean(rest[i:])
+
+	if pathPrefix == "/" || pathPrefix == "." {
+		pathPrefix = ""
+	}
+
+	return host, pathPrefix
+}
+
+// IsPublicSuffix tells whether the host is a suffix under which
+// anyone can register names, like "com", "co.uk" or "github.io".
+func IsPublicSuffix(host string) bool {
+	suffix, _ := publicsuffix.PublicSuffix(host)
+	return suffix == host
+}
+
+// domainCandidates returns the host and its parents
+// up to the registrable domain, most specific first.
+func domainCandidates(host string) []string {
+
+	candidates := []string{host}
+
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(host)
+
+	if err != nil {
+		// IP addresses and public suffixes have no parent
+		return candidates
+	}
+
+	for host != registrable {
+		i := strings.Index(host, ".")
+
+		if i < 0 {
+			break
+		}
+
+		host = host[i+1:]
+		candidates = append(candidates, host)
+	}
+
+	return candidates
+}
+
+// MatchDomainByURL resolves the url to the most specific domain covering it.
+// Domains covering subdomains apply to the hosts under them
+// up to the registrable domain in the public suffix list.
+// Pending domains yield to the decided ones covering them, so a pending rule
+// doesn't shadow an active parent, while rejected and deactivated rules do.
+func MatchDomainByURL(urlStr string, dbi *gorm.DB) (error, *Domain) {
+
+	cleaned, err := GetURLCanonicalizer().CanonicalizeURL(urlStr)
+
+	if err != nil {
+		return err, nil
+	}
+
+	u, err := url.Parse(cleaned)
+
+	if err != nil {
+		return err, nil
+	}
+
+	host := u.Hostname()
+
+	var domains []Domain
+
+	if err := dbi.Where("host IN (?)", domainCandidates(host)).Find(&domains).Error; err != nil {
+		return err, nil
+	}
+
+	var matched, matchedDecided *Domain
+
+	for i := range domains {
+		domain := &domains[i]
+
+		if !domain.Matches(host, u.Path) {
+			continue
+		}
+
+		if matched == nil || domain.isMoreSpecificThan(matched) {
+			matched = domain
+		}
+
+		if domain.GetStatus() != DomainStatusPending && (matchedDecided == nil || domain.isMoreSpecificThan(matchedDecided)) {
+			matchedDecided = domain
+		}
+	}
+
+	if matchedDecided != nil {
+		return nil, matchedDecided
+	}
+
+	return nil, matched
 }
 
 func GetDomainByDomainName(domain string, dbi *gorm.DB, forUpdate bool) (error, *Domain) {
@@ -78,7 +312,7 @@ func GetDomainByDomainName(domain string, dbi *gorm.DB, forUpdate bool) (error,
 
 func GetDomainHashKey(domain string) string {
 
-	sumBytes := sha1.Sum([]byte(domain))
+	sumBytes := sha1.Sum([]byte(CleanDomain(domain)))
 
 	return hex.EncodeToString(sumBytes[:])
 }
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/models"
+)
+
+func TestParseDomainRule(t *testing.T) {
+	host, pathPrefix := models.ParseDomainRule("https://www.Medium.com/@author/")
+	assert.Equal(t, host, "medium.com")
+	assert.Equal(t, pathPrefix, "/@author")
+
+	host, pathPrefix = models.ParseDomainRule("example.com")
+	assert.Equal(t, host, "example.com")
+	assert.Equal(t, pathPrefix, "")
+
+	assert.Equal(t, models.CleanDomain("medium.com/@author/../@other?x=1"), "medium.com/@other")
+}
+
+func TestDomainMatches(t *testing.T) {
+	domain := &models.Domain{Domain: "example.com"}
+	domain.BeforeSave()
+
+	assert.Equal(t, domain.Matches("example.com", "/a"), true)
+	assert.Equal(t, domain.Matches("blog.example.com", "/a"), false)
+
+	domain.IncludeSubdomains = true
+	assert.Equal(t, domain.Matches("blog.example.com", "/a"), true)
+	assert.Equal(t, domain.Matches("notexample.com", "/a"), false)
+
+	scoped := &models.Domain{Domain: "medium.com/@author"}
+	scoped.BeforeSave()
+
+	assert.Equal(t, scoped.Matches("medium.com", "/@author"), true)
+	assert.Equal(t, scoped.Matches("medium.com", "/@author/post"), true)
+	assert.Equal(t, scoped.Matches("medium.com", "/@authorx/post"), false)
+	assert.Equal(t, scoped.Matches("medium.com", "/"), false)
+}
+
+func TestIsPublicSuffix(t *testing.T) {
+	assert.Equal(t, models.IsPublicSuffix("com"), true)
+	assert.Equal(t, models.IsPublicSuffix("co.uk"), true)
+	assert.Equal(t, models.IsPublicSuffix("github.io"), true)
+	assert.Equal(t, models.IsPublicSuffix("example.co.uk"), false)
+}
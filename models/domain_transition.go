@@ -0,0 +1,35 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+const (
+	DomainActorUser   = "user"
+	DomainActorAdmin  = "admin"
+	DomainActorSystem = "system"
+)
+
+// DomainTransition is the audit trail of the status changes of a domain.
+type DomainTransition struct {
+	BaseModel
+	DomainID   uint   `gorm:"index" json:"-"`
+	FromStatus string `gorm:"type:varchar(32)" json:"from_status"`
+	ToStatus   string `gorm:"type:varchar(32)" json:"to_status"`
+	Reason     string `gorm:"type:text" json:"reason"`
+	Actor      string `gorm:"type:varchar(32)" json:"actor"`
+	UserID     uint   `json:"-"`
+	Votes      uint   `json:"votes"`
+}
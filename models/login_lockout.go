@@ -0,0 +1,34 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+// Scopes of the failed login counters
+const (
+	LoginScopeUsername = "username"
+	LoginScopeIP       = "ip"
+)
+
+// LoginLockout records a username or an ip locked out after failed logins,
+// the username is the one tried, which may not exist.
+type LoginLockout struct {
+	BaseModel
+	Scope       string `gorm:"type:varchar(16);index" json:"scope"`
+	Username    string `gorm:"type:varchar(128);index" json:"username"`
+	IP          string `gorm:"type:varchar(64);index" json:"ip"`
+	Failures    uint   `json:"failures"`
+	LockedUntil uint   `json:"locked_until"`
+}
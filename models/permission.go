@@ -0,0 +1,84 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import "errors"
+
+// Permissions granted by the roles of users
+const (
+	PermissionModerateComments  = "comments.moderate"
+	PermissionManageDomains     = "domains.manage"
+	PermissionManageUsers       = "users.manage"
+	PermissionManageRoles       = "roles.manage"
+	PermissionAdjustIntegration = "integration.adjust"
+	PermissionViewAuditLog      = "audit_log.view"
+)
+
+var rolePermissions = map[string][]string{
+	UserRoleUser: {},
+	UserRoleModerator: {
+		PermissionModerateComments,
+		PermissionManageDomains,
+	},
+	UserRoleAdmin: {
+		PermissionModerateComments,
+		PermissionManageDomains,
+		PermissionManageUsers,
+		PermissionManageRoles,
+		PermissionAdjustIntegration,
+		PermissionViewAuditLog,
+	},
+}
+
+var ErrInvalidRole = errors.New("invalid role")
+
+// CleanRole validates the name of the role.
+func CleanRole(role string) (string, error) {
+	if _, ok := rolePermissions[role]; !ok {
+		return "", ErrInvalidRole
+	}
+
+	return role, nil
+}
+
+// GetRolePermissions returns the permissions of the role, users without
+// a role have the permissions of UserRoleUser.
+func GetRolePermissions(role string) []string {
+	if permissions, ok := rolePermissions[role]; ok {
+		return permissions
+	}
+
+	return rolePermissions[UserRoleUser]
+}
+
+func (user *User) GetRole() string {
+	if _, ok := rolePermissions[user.Role]; ok {
+		return user.Role
+	}
+
+	return UserRoleUser
+}
+
+func (user *User) HasPermission(permission string) bool {
+	for _, p := range GetRolePermissions(user.Role) {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
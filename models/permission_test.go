@@ -0,0 +1,41 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/models"
+)
+
+func TestUser_HasPermission(t *testing.T) {
+	user := &models.User{}
+
+	assert.Equal(t, user.GetRole(), models.UserRoleUser)
+	assert.Equal(t, user.HasPermission(models.PermissionModerateComments), false)
+
+	user.Role = models.UserRoleModerator
+	assert.Equal(t, user.HasPermission(models.PermissionModerateComments), true)
+	assert.Equal(t, user.HasPermission(models.PermissionManageRoles), false)
+
+	user.Role = models.UserRoleAdmin
+	assert.Equal(t, user.HasPermission(models.PermissionManageRoles), true)
+
+	_, err := models.CleanRole("root")
+	assert.Equal(t, err, models.ErrInvalidRole)
+}
@@ -20,9 +20,13 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
+	"strings"
+	"sync"
 
 	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/config"
 	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/util"
 )
 
 type URLContent struct {
@@ -34,14 +38,71 @@ type URLContent struct {
 	TotalComment uint `gorm:"default:1" json:"total_comment"`
 }
 
+type URLQueryRule struct {
+	Domain string   `mapstructure:"domain"`
+	Params []string `mapstructure:"params"`
+}
+
+var urlCanonicalizer *util.URLCanonicalizer
+var urlCanonicalizerOnce sync.Once
+
+// GetURLCanonicalizer returns the canonicalizer with the rules in config:
+//
+//	url:
+//	  tracking_params: [from]
+//	  query_rules:
+//	    - domain: example.com
+//	      params: [id, page]
+func GetURLCanonicalizer() *util.URLCanonicalizer {
+	urlCanonicalizerOnce.Do(func() {
+		urlCanonicalizer = util.NewURLCanonicalizer()
+
+		c := config.GetConfig()
+
+		if c == nil {
+			return
+		}
+
+		urlCanonicalizer.AddTrackingParams(c.GetStringSlice("url.tracking_params"))
+
+		var rules []URLQueryRule
+
+		if err := c.UnmarshalKey("url.query_rules", &rules); err == nil {
+			for _, rule := range rules {
+				urlCanonicalizer.SetQueryRule(rule.Domain, rule.Params)
+			}
+		}
+	})
+
+	return urlCanonicalizer
+}
+
+// CleanURL returns the canonical form of the url,
+// the url is returned as it is if it can't be parsed.
 func CleanURL(url string) string {
-	//TODO: Remove trailing slash, remove hash, etc.
-	return url
+
+	cleaned, err := GetURLCanonicalizer().CanonicalizeURL(url)
+
+	if err != nil {
+		return strings.TrimSpace(url)
+	}
+
+	return cleaned
 }
 
+// GetURLHashKey returns the key of the url's canonical form,
+// http and https versions of the same url share the key.
 func GetURLHashKey(url string) string {
 
-	sumBytes := sha1.Sum([]byte(url))
+	identity := CleanURL(url)
+
+	if i := strings.Index(identity, "://"); i >= 0 {
+		if scheme := identity[:i]; scheme == "http" || scheme == "https" {
+			identity = identity[i+3:]
+		}
+	}
+
+	sumBytes := sha1.Sum([]byte(identity))
 
 	return hex.EncodeToString(sumBytes[:])
 }
@@ -17,9 +17,8 @@
 package models
 
 import (
-	"errors"
+	"time"
 
-	"github.com/jinzhu/gorm"
 	"github.com/primasio/wormhole/util"
 )
 
@@ -31,36 +30,121 @@ type URLContentComment struct {
 	URLContentId uint   `json:"-"`
 	Content      string `gorm:"type:longtext" json:"content"`
 
+	// Replies reference their direct parent and the top level comment of the thread.
+	// Both are zero for top level comments.
+	ParentID       uint   `gorm:"index" json:"-"`
+	ParentUniqueID string `gorm:"type:varchar(128)" json:"parent_id"`
+	RootID         uint   `gorm:"index" json:"-"`
+	Depth          uint   `gorm:"default:0" json:"depth"`
+
+	ReplyCount       uint `json:"reply_count" gorm:"type:INT(11);default:0"`
+	ThreadReplyCount uint `json:"thread_reply_count" gorm:"type:INT(11);default:0"`
+
 	CommentUpVotes   uint `json:"comment_up_votes" gorm:"type:INT(11);default:0"`
 	CommentDownVotes uint `json:"comment_down_votes" gorm:"type:INT(11);default:0"`
 
+	// Ranking scores used for sorting, updated on every vote
+	Score              int64   `json:"score" gorm:"type:INT(11);default:0;index"`
+	BestScore          float64 `json:"-" gorm:"default:0;index"`
+	HotScore           float64 `json:"-" gorm:"default:0;index"`
+	ControversialScore float64 `json:"-" gorm:"default:0;index"`
+
 	User User `gorm:"save_associations:false" json:"user"`
 
 	IsDeleted bool `gorm:"default:false" json:"is_deleted"`
+	IsEdited  bool `gorm:"default:false" json:"is_edited"`
+	EditedAt  uint `json:"edited_at"`
+
+	// Who deleted the comment and why, reason is only required
+	// when the comment is removed by an admin
+	RemovedAt    uint   `json:"-"`
+	RemovedByID  uint   `json:"-"`
+	RemoveReason string `gorm:"type:text" json:"-"`
+}
+
+func (comment *URLContentComment) BeforeCreate() error {
+	comment.CreatedAt = uint(time.Now().Unix())
+	comment.UpdateScores()
+	return nil
+}
+
+// UpdateScores recalculates the ranking scores from the votes.
+func (comment *URLContentComment) UpdateScores() {
+	comment.Score = util.NetScore(comment.CommentUpVotes, comment.CommentDownVotes)
+	comment.BestScore = util.WilsonScore(comment.CommentUpVotes, comment.CommentDownVotes)
+	comment.HotScore = util.HotScore(comment.CommentUpVotes, comment.CommentDownVotes, comment.CreatedAt)
+	comment.ControversialScore = util.ControversialScore(comment.CommentUpVotes, comment.CommentDownVotes)
 }
 
-func (comment *URLContentComment) SetUniqueID(db *gorm.DB) error {
-	var counter = 0
+// SetUniqueID sets a 128-bit ID sortable by creation time,
+// which is unique without checking the database.
+func (comment *URLContentComment) SetUniqueID() error {
+	uid, err := util.NewID()
+
+	if err != nil {
+		return err
+	}
 
-	for {
-		counter = counter + 1
-		uid := util.RandStringUppercase(8)
+	comment.UniqueID = uid
 
-		check := &URLContentComment{UniqueID: uid}
+	return nil
+}
 
-		db.Where(&check).First(&check)
+// Edit replaces the content of the comment and returns the revision
+// holding the previous version.
+func (comment *URLContentComment) Edit(content string) *URLContentCommentRevision {
+	revision := NewURLContentCommentRevision(comment)
 
-		if check.ID == 0 {
-			comment.UniqueID = uid
-			return nil
-		}
+	comment.Content = content
+	comment.IsEdited = true
+	comment.EditedAt = uint(time.Now().Unix())
 
-		if counter >= 5 {
-			// This is unlikely to happen
-			// Must be error from other parts
-			return errors.New("too many iterations while generating new session key")
-		}
+	return revision
+}
+
+// CanBeDeletedBy tells whether the user is allowed to delete the comment.
+func (comment *URLContentComment) CanBeDeletedBy(user *User) bool {
+	return comment.UserID == user.ID || user.HasPermission(PermissionModerateComments)
+}
+
+// MarkDeleted soft deletes the comment on behalf of the given user.
+func (comment *URLContentComment) MarkDeleted(user *User, reason string) {
+	comment.IsDeleted = true
+	comment.RemovedAt = uint(time.Now().Unix())
+	comment.RemovedByID = user.ID
+	comment.RemoveReason = reason
+}
+
+func (comment *URLContentComment) IsReply() bool {
+	return comment.ParentID != 0
+}
+
+// ThreadID returns the id of the top level comment of the thread this comment belongs to.
+func (comment *URLContentComment) ThreadID() uint {
+	if comment.RootID != 0 {
+		return comment.RootID
 	}
+	return comment.ID
+}
+
+// SetParent makes the comment a reply of the given parent comment.
+func (comment *URLContentComment) SetParent(parent *URLContentComment) {
+	comment.ParentID = parent.ID
+	comment.ParentUniqueID = parent.UniqueID
+	comment.RootID = parent.ThreadID()
+	comment.Depth = parent.Depth + 1
+}
+
+// IsTombstone tells whether a deleted comment still has to be displayed
+// to keep the replies under it in place.
+func (comment *URLContentComment) IsTombstone() bool {
+	return comment.IsDeleted && comment.ReplyCount > 0
+}
+
+// Tombstone removes the content and author of a deleted comment.
+func (comment *URLContentComment) Tombstone() {
+	comment.Content = ""
+	comment.User = User{}
 }
 
 func (comment *URLContentComment) IncrementVote(like bool) {
@@ -69,6 +153,8 @@ func (comment *URLContentComment) IncrementVote(like bool) {
 	} else {
 		comment.CommentDownVotes++
 	}
+
+	comment.UpdateScores()
 }
 
 func (comment *URLContentComment) SwitchVote(like bool) {
@@ -79,6 +165,8 @@ func (comment *URLContentComment) SwitchVote(like bool) {
 		comment.CommentUpVotes--
 		comment.CommentDownVotes++
 	}
+
+	comment.UpdateScores()
 }
 
 func (comment *URLContentComment) CancelVote(like bool) {
@@ -87,4 +175,45 @@ func (comment *URLContentComment) CancelVote(like bool) {
 	} else {
 		comment.CommentDownVotes--
 	}
+
+	comment.UpdateScores()
+}
+
+type URLContentCommentThread struct {
+	URLContentComment
+	Replies []*URLContentCommentThread `json:"replies"`
+}
+
+// BuildCommentThread arranges the replies under the given comment into a tree.
+// Comments that are deleted and have no visible replies are dropped,
+// the others are tombstoned.
+func BuildCommentThread(comment *URLContentComment, replies []URLContentComment) *URLContentCommentThread {
+
+	children := make(map[uint][]*URLContentComment)
+
+	for i := range replies {
+		reply := &replies[i]
+		children[reply.ParentID] = append(children[reply.ParentID], reply)
+	}
+
+	var build func(c *URLContentComment) *URLContentCommentThread
+
+	build = func(c *URLContentComment) *URLContentCommentThread {
+		node := &URLContentCommentThread{URLContentComment: *c, Replies: make([]*URLContentCommentThread, 0)}
+
+		if node.IsDeleted {
+			node.Tombstone()
+		}
+
+		for _, child := range children[c.ID] {
+			if child.IsDeleted && !child.IsTombstone() {
+				continue
+			}
+			node.Replies = append(node.Replies, build(child))
+		}
+
+		return node
+	}
+
+	return build(comment)
 }
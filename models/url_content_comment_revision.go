@@ -0,0 +1,38 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+// URLContentCommentRevision keeps a previous version of an edited comment
+// together with the votes the comment had collected before the edit.
+type URLContentCommentRevision struct {
+	BaseModel
+
+	URLContentCommentID uint   `gorm:"index" json:"-"`
+	Content             string `gorm:"type:longtext" json:"content"`
+
+	CommentUpVotes   uint `json:"comment_up_votes" gorm:"type:INT(11);default:0"`
+	CommentDownVotes uint `json:"comment_down_votes" gorm:"type:INT(11);default:0"`
+}
+
+func NewURLContentCommentRevision(comment *URLContentComment) *URLContentCommentRevision {
+	return &URLContentCommentRevision{
+		URLContentCommentID: comment.ID,
+		Content:             comment.Content,
+		CommentUpVotes:      comment.CommentUpVotes,
+		CommentDownVotes:    comment.CommentDownVotes,
+	}
+}
@@ -63,3 +63,50 @@ func TestCancelVote(t *testing.T) {
 	assert.Equal(t, comment.CommentUpVotes, uint(29))
 	assert.Equal(t, comment.CommentDownVotes, uint(19))
 }
+
+func TestBuildCommentThread(t *testing.T) {
+	root := models.URLContentComment{Content: "root", ReplyCount: 2}
+	root.ID = 1
+
+	replies := []models.URLContentComment{
+		{Content: "reply 1", ParentID: 1, RootID: 1, Depth: 1, ReplyCount: 1, IsDeleted: true},
+		{Content: "reply 2", ParentID: 1, RootID: 1, Depth: 1, IsDeleted: true},
+		{Content: "reply 1.1", ParentID: 2, RootID: 1, Depth: 2},
+	}
+	replies[0].ID = 2
+	replies[1].ID = 3
+	replies[2].ID = 4
+
+	thread := models.BuildCommentThread(&root, replies)
+
+	assert.Equal(t, thread.Content, "root")
+	assert.Equal(t, len(thread.Replies), 1)
+
+	// Deleted comment with replies is kept as a tombstone
+	assert.Equal(t, thread.Replies[0].IsDeleted, true)
+	assert.Equal(t, thread.Replies[0].Content, "")
+	assert.Equal(t, len(thread.Replies[0].Replies), 1)
+	assert.Equal(t, thread.Replies[0].Replies[0].Content, "reply 1.1")
+}
+
+func TestSetParent(t *testing.T) {
+	root := models.URLContentComment{UniqueID: "ROOT"}
+	root.ID = 1
+
+	reply := models.URLContentComment{}
+	reply.SetParent(&root)
+
+	assert.Equal(t, reply.ParentID, uint(1))
+	assert.Equal(t, reply.ParentUniqueID, "ROOT")
+	assert.Equal(t, reply.RootID, uint(1))
+	assert.Equal(t, reply.Depth, uint(1))
+
+	reply.ID = 2
+
+	reply2 := models.URLContentComment{}
+	reply2.SetParent(&reply)
+
+	assert.Equal(t, reply2.ParentID, uint(2))
+	assert.Equal(t, reply2.RootID, uint(1))
+	assert.Equal(t, reply2.Depth, uint(2))
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/models"
+)
+
+func TestGetURLHashKey(t *testing.T) {
+	hashKey := models.GetURLHashKey("https://example.com/a")
+
+	urls := []string{
+		"https://www.example.com/a/",
+		"http://example.com/a#top",
+		"https://example.com/a?utm_source=x",
+		"example.com/a",
+	}
+
+	for _, url := range urls {
+		assert.Equal(t, models.GetURLHashKey(url), hashKey, url)
+	}
+
+	assert.Equal(t, models.GetURLHashKey("https://example.com/b") == hashKey, false)
+	assert.Equal(t, models.GetURLHashKey("ftp://example.com/a") == hashKey, false)
+}
+
+func TestGetDomainHashKey(t *testing.T) {
+	hashKey := models.GetDomainHashKey("example.com")
+
+	assert.Equal(t, models.GetDomainHashKey("https://www.Example.com/"), hashKey)
+	assert.Equal(t, models.CleanDomain("WWW.example.com"), "example.com")
+}
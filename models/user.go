@@ -17,16 +17,29 @@
 package models
 
 import (
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"math/big"
+	"net/mail"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/config"
 	"github.com/primasio/wormhole/util"
 	"golang.org/x/crypto/sha3"
 )
 
+const (
+	UserRoleUser      = "user"
+	UserRoleModerator = "moderator"
+	UserRoleAdmin     = "admin"
+)
+
 type User struct {
 	BaseModel
 	UniqueID         string `json:"id" gorm:"type:varchar(128);unique_index"`
@@ -35,21 +48,226 @@ type User struct {
 	Salt             string `json:"-"`
 	Nickname         string `json:"nickname"`
 	AvatarURL        string `json:"avatar_url"`
+	AvatarKey        string `json:"-"`
 	Integration      int64  `json:"integration" gorm:"type:INT(18);default:0"`
 	CommentUpVotes   uint   `json:"comment_up_votes" gorm:"type:INT(11);default:0"`
 	CommentDownVotes uint   `json:"comment_down_votes" gorm:"type:INT(11);default:0"`
 	Balance          string `json:"balance"`
+	Role             string `json:"-" gorm:"type:varchar(32);default:'user'"`
+
+	// Email is private to the user, so it's not in the json of the user
+	Email           string `json:"-" gorm:"type:varchar(255);index"`
+	EmailVerified   bool   `json:"-" gorm:"default:false"`
+	EmailVerifiedAt uint   `json:"-"`
+
+	// Deleted users are kept without personal data
+	// so their comments and votes stay consistent
+	IsDeleted bool `json:"-" gorm:"default:false"`
+
+	// The TOTP secret is set on enrollment and the second factor
+	// is enabled once a first code is confirmed. Codes of steps up to
+	// the last counter are rejected so a code can't be used twice.
+	TOTPSecret      string `json:"-" gorm:"type:varchar(64)"`
+	TOTPEnabled     bool   `json:"-" gorm:"default:false"`
+	TOTPLastCounter int64  `json:"-" gorm:"default:0"`
+}
+
+const (
+	maxNicknameLength  = 32
+	maxAvatarURLLength = 1024
+)
+
+var ErrInvalidNickname = errors.New("nickname must have 1 to 32 printable characters")
+var ErrInvalidAvatarURL = errors.New("avatar url must be an http or https url")
+
+var ErrInvalidEmail = errors.New("invalid email")
+
+// CleanEmail validates the email address and returns it in lower case.
+func CleanEmail(email string) (string, error) {
+
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	address, err := mail.ParseAddress(email)
+
+	// Only bare addresses are accepted, not "Name <address>"
+	if err != nil || address.Address != email {
+		return "", ErrInvalidEmail
+	}
+
+	return email, nil
+}
+
+// CleanNickname validates the nickname and trims the spaces around it.
+func CleanNickname(nickname string) (string, error) {
+
+	nickname = strings.TrimSpace(nickname)
+
+	if nickname == "" || utf8.RuneCountInString(nickname) > maxNicknameLength {
+		return "", ErrInvalidNickname
+	}
+
+	for _, r := range nickname {
+		if !unicode.IsPrint(r) {
+			return "", ErrInvalidNickname
+		}
+	}
+
+	return nickname, nil
+}
+
+func CleanAvatarURL(avatarURL string) (string, error) {
+
+	avatarURL = strings.TrimSpace(avatarURL)
+
+	if len(avatarURL) > maxAvatarURLLength {
+		return "", ErrInvalidAvatarURL
+	}
+
+	u, err := url.Parse(avatarURL)
+
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ErrInvalidAvatarURL
+	}
+
+	return avatarURL, nil
+}
+
+// Anonymize removes the personal data of the deleted user.
+// The row is kept since comments, votes and integration history refer to it,
+// the unique id is replaced so the comments can't be traced to the old profile.
+func (user *User) Anonymize() error {
+
+	if err := user.SetUniqueID(); err != nil {
+		return err
+	}
+
+	user.Username = ""
+	user.Password = ""
+	user.Salt = ""
+	user.Nickname = ""
+	user.AvatarURL = ""
+	user.AvatarKey = ""
+	user.Email = ""
+	user.EmailVerified = false
+	user.EmailVerifiedAt = 0
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastCounter = 0
+	user.IsDeleted = true
+
+	return nil
+}
+
+// SetEmail changes the email of the user, which needs to be verified again.
+func (user *User) SetEmail(email string) {
+	if email == user.Email {
+		return
+	}
+
+	user.Email = email
+	user.EmailVerified = false
+	user.EmailVerifiedAt = 0
+}
+
+func (user *User) VerifyEmail() {
+	user.EmailVerified = true
+	user.EmailVerifiedAt = uint(time.Now().Unix())
+}
+
+var passwordHasher util.PasswordHasher
+var passwordHasherOnce sync.Once
+
+// GetPasswordHasher returns the hasher of new passwords configured by
+// password.algorithm, argon2id is used by default.
+func GetPasswordHasher() util.PasswordHasher {
+	passwordHasherOnce.Do(func() {
+		c := config.GetConfig()
+
+		if c != nil && c.GetString("password.algorithm") == util.PasswordAlgorithmBcrypt {
+			passwordHasher = util.NewBcryptHasher(c.GetInt("password.bcrypt_cost"))
+			return
+		}
+
+		memory, iterations, threads := uint32(64*1024), uint32(3), uint8(2)
+
+		if c != nil {
+			if v := c.GetInt("password.argon2.memory"); v > 0 {
+				memory = uint32(v)
+			}
+
+			if v := c.GetInt("password.argon2.time"); v > 0 {
+				iterations = uint32(v)
+			}
+
+			if v := c.GetInt("password.argon2.threads"); v > 0 {
+				threads = uint8(v)
+			}
+		}
+
+		passwordHasher = util.NewArgon2idHasher(memory, iterations, threads)
+	})
+
+	return passwordHasher
 }
 
 func (user *User) VerifyPassword(password string) bool {
-	return user.Password == user.getPasswordHash(password)
+
+	if user.Password == "" {
+		return false
+	}
+
+	if user.isLegacyPassword() {
+		return subtle.ConstantTimeCompare([]byte(user.Password), []byte(user.getLegacyPasswordHash(password))) == 1
+	}
+
+	ok, err := util.VerifyPasswordHash(GetPasswordHasher(), password, user.Password)
+
+	return err == nil && ok
+}
+
+// NeedsPasswordRehash tells whether the password should be hashed again
+// with the current hasher after a successful verification.
+func (user *User) NeedsPasswordRehash() bool {
+
+	if user.Password == "" {
+		return false
+	}
+
+	if user.isLegacyPassword() {
+		return true
+	}
+
+	hasher := GetPasswordHasher()
+
+	if util.IdentifyPasswordHash(user.Password) != hasher.Algorithm() {
+		return true
+	}
+
+	return hasher.NeedsRehash(user.Password)
 }
 
-func (user *User) hashPassword() {
-	user.Password = user.getPasswordHash(user.Password)
+// SetPassword hashes the plain password with the current hasher.
+func (user *User) SetPassword(password string) error {
+
+	hash, err := GetPasswordHasher().Hash(password)
+
+	if err != nil {
+		return err
+	}
+
+	user.Password = hash
+	user.Salt = ""
+
+	return nil
 }
 
-func (user *User) getPasswordHash(password string) string {
+// Legacy passwords are hashed by getLegacyPasswordHash with a salt,
+// the base64 encoded hash never starts with "$".
+func (user *User) isLegacyPassword() bool {
+	return util.IdentifyPasswordHash(user.Password) == "" && user.Salt != ""
+}
+
+func (user *User) getLegacyPasswordHash(password string) string {
 
 	// Password hash = sha3(sha3(password) + salt)
 
@@ -66,17 +284,14 @@ func (user *User) getPasswordHash(password string) string {
 	return base64.StdEncoding.EncodeToString(finalByte)
 }
 
-func (user *User) setSalt() {
-	user.Salt = util.RandString(8)
-}
-
 func (user *User) BeforeCreate() error {
 
 	user.CreatedAt = uint(time.Now().Unix())
 
 	if user.Password != "" {
-		user.setSalt()
-		user.hashPassword()
+		if err := user.SetPassword(user.Password); err != nil {
+			return err
+		}
 	}
 
 	user.SetBalance(big.NewInt(0))
@@ -94,28 +309,18 @@ func (user *User) SetBalance(num *big.Int) {
 	user.Balance = num.String()
 }
 
-func (user *User) SetUniqueID(db *gorm.DB) error {
-	var counter = 0
-
-	for {
-		counter = counter + 1
-		uid := util.RandStringUppercase(8)
-
-		check := &User{UniqueID: uid}
+// SetUniqueID sets a 128-bit ID sortable by creation time,
+// which is unique without checking the database.
+func (user *User) SetUniqueID() error {
+	uid, err := util.NewID()
 
-		db.Where(&check).First(&check)
+	if err != nil {
+		return err
+	}
 
-		if check.ID == 0 {
-			user.UniqueID = uid
-			return nil
-		}
+	user.UniqueID = uid
 
-		if counter >= 5 {
-			// This is unlikely to happen
-			// Must be error from other parts
-			return errors.New("too many iterations while generating new session key")
-		}
-	}
+	return nil
 }
 
 func (user *User) IncrementCommentVote(like bool) {
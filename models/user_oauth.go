@@ -16,7 +16,13 @@
 
 package models
 
-const OAuthGoogle = 1
+// Vendor types of OAuth accounts
+const (
+	OAuthGoogle   = 1
+	OAuthGitHub   = 2
+	OAuthFacebook = 3
+	OAuthTwitter  = 4
+)
 
 type UserOAuth struct {
 	BaseModel
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// UserRecoveryCode is a one-time code to sign in without the authenticator,
+// only the hash of the code is stored.
+type UserRecoveryCode struct {
+	BaseModel
+	UserID   uint   `gorm:"index"`
+	CodeHash string `gorm:"type:varchar(64)"`
+	UsedAt   uint
+}
+
+// HashRecoveryCode hashes the code of the user. Codes are random,
+// so a fast hash is enough, dashes and case are ignored.
+func HashRecoveryCode(userID uint, code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", userID, code)))
+	return hex.EncodeToString(sum[:])
+}
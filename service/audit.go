@@ -0,0 +1,48 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/models"
+)
+
+var audit *Audit
+var auditOnce sync.Once
+
+type Audit struct{}
+
+func GetAudit() *Audit {
+	auditOnce.Do(func() {
+		audit = &Audit{}
+	})
+
+	return audit
+}
+
+// Record appends the log in the transaction of the action,
+// so the action is rolled back if it can't be logged.
+func (s *Audit) Record(tx *gorm.DB, log *models.AuditLog, before, after interface{}) error {
+
+	if err := log.SetStates(before, after); err != nil {
+		return err
+	}
+
+	return tx.Create(log).Error
+}
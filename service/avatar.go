@@ -0,0 +1,375 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	// Decoders of the accepted formats
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/storage"
+	"github.com/primasio/wormhole/util"
+	"golang.org/x/image/draw"
+)
+
+const (
+	defaultAvatarMaxSize      = 5 * 1024 * 1024
+	defaultAvatarMaxDimension = 4096
+	defaultAvatarMirrorTime   = time.Second * 10
+)
+
+// Standard sizes of avatars in pixels, the first one is the default avatar
+var defaultAvatarSizes = []int{256, 128, 64}
+
+var avatarContentTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+var ErrAvatarTooLarge = errors.New("avatar is too large")
+var ErrAvatarType = errors.New("avatar must be a jpeg, png, gif or webp image")
+var ErrAvatarAddress = errors.New("avatar host is not a public address")
+
+// Networks avatars are never mirrored from, so that avatar urls
+// can not reach the services or the cloud metadata behind the server
+var avatarBlockedNetworks = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+var avatarService *Avatar
+var avatarServiceOnce sync.Once
+
+type Avatar struct {
+	client *http.Client
+}
+
+func GetAvatar() *Avatar {
+	avatarServiceOnce.Do(func() {
+		timeout := config.GetConfig().GetDuration("avatar.mirror_timeout")
+
+		if timeout <= 0 {
+			timeout = defaultAvatarMirrorTime
+		}
+
+		// Every connection, including the ones of redirects, is checked
+		// against the resolved address by the dialer
+		dialer := &net.Dialer{Timeout: timeout, Control: checkAvatarAddress}
+
+		avatarService = &Avatar{client: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{DialContext: dialer.DialContext},
+		}}
+	})
+
+	return avatarService
+}
+
+// GetMaxSize returns the max size of avatar files in bytes.
+func (s *Avatar) GetMaxSize() int64 {
+	if size := config.GetConfig().GetInt64("avatar.max_size"); size > 0 {
+		return size
+	}
+
+	return defaultAvatarMaxSize
+}
+
+func (s *Avatar) GetSizes() []int {
+	var sizes []int
+
+	if err := config.GetConfig().UnmarshalKey("avatar.sizes", &sizes); err != nil || len(sizes) == 0 {
+		return defaultAvatarSizes
+	}
+
+	return sizes
+}
+
+// Resize checks the image and resizes the center square of it
+// into the standard sizes, encoded as jpeg.
+func (s *Avatar) Resize(data []byte) (map[int][]byte, error) {
+
+	if int64(len(data)) > s.GetMaxSize() {
+		return nil, ErrAvatarTooLarge
+	}
+
+	if !avatarContentTypes[http.DetectContentType(data)] {
+		return nil, ErrAvatarType
+	}
+
+	// Check the dimensions before decoding the pixels
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, ErrAvatarType
+	}
+
+	maxDimension := config.GetConfig().GetInt("avatar.max_dimension")
+
+	if maxDimension <= 0 {
+		maxDimension = defaultAvatarMaxDimension
+	}
+
+	if imageConfig.Width > maxDimension || imageConfig.Height > maxDimension {
+		return nil, ErrAvatarTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, ErrAvatarType
+	}
+
+	bounds := src.Bounds()
+	side := bounds.Dx()
+
+	if bounds.Dy() < side {
+		side = bounds.Dy()
+	}
+
+	x := bounds.Min.X + (bounds.Dx()-side)/2
+	y := bounds.Min.Y + (bounds.Dy()-side)/2
+	square := image.Rect(x, y, x+side, y+side)
+
+	resized := make(map[int][]byte)
+
+	for _, size := range s.GetSizes() {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+
+		// Transparent pixels become white in jpeg
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.ZP, draw.Src)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, square, draw.Over, nil)
+
+		var buf bytes.Buffer
+
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 90}); err != nil {
+			return nil, err
+		}
+
+		resized[size] = buf.Bytes()
+	}
+
+	return resized, nil
+}
+
+// Save resizes the image and stores it as the avatar of the user,
+// the previous avatar is removed. The user is saved with dbi.
+func (s *Avatar) Save(dbi *gorm.DB, user *models.User, data []byte) error {
+
+	resized, err := s.Resize(data)
+
+	if err != nil {
+		return err
+	}
+
+	// A new key for every upload so cached avatars are not served
+	version, err := util.NewID()
+
+	if err != nil {
+		return err
+	}
+
+	key := "avatars/" + user.UniqueID + "/" + version
+	store := storage.GetStorage()
+
+	for size, blob := range resized {
+		if err := store.Put(avatarBlobKey(key, size), blob, "image/jpeg"); err != nil {
+			return err
+		}
+	}
+
+	previousKey := user.AvatarKey
+
+	user.AvatarKey = key
+	user.AvatarURL = store.URL(avatarBlobKey(key, s.GetSizes()[0]))
+
+	if err := dbi.Model(user).UpdateColumns(map[string]interface{}{
+		"avatar_key": user.AvatarKey,
+		"avatar_url": user.AvatarURL,
+	}).Error; err != nil {
+		return err
+	}
+
+	if previousKey != "" {
+		return s.removeBlobs(previousKey)
+	}
+
+	return nil
+}
+
+// URLs returns the urls of the standard sizes of the avatar of the user.
+func (s *Avatar) URLs(user *models.User) map[string]string {
+
+	urls := make(map[string]string)
+
+	if user.AvatarKey == "" {
+		return urls
+	}
+
+	for _, size := range s.GetSizes() {
+		urls[strconv.Itoa(size)] = storage.GetStorage().URL(avatarBlobKey(user.AvatarKey, size))
+	}
+
+	return urls
+}
+
+// Remove deletes the stored avatar of the user.
+func (s *Avatar) Remove(user *models.User) error {
+
+	if user.AvatarKey == "" {
+		return nil
+	}
+
+	return s.removeBlobs(user.AvatarKey)
+}
+
+// NeedsMirror tells whether the avatar of the user is hot-linked from another site.
+func (s *Avatar) NeedsMirror(user *models.User) bool {
+	return user.AvatarKey == "" && user.AvatarURL != ""
+}
+
+// Mirror downloads the avatar of the user and stores it as an uploaded one.
+// Nothing is done if the avatar of the user is no longer sourceURL,
+// which happens when the user uploads an avatar in the meantime.
+func (s *Avatar) Mirror(dbi *gorm.DB, userID uint, sourceURL string) error {
+
+	user := &models.User{}
+	dbi.Where("id = ?", userID).First(user)
+
+	if user.ID == 0 || user.AvatarURL != sourceURL || !s.NeedsMirror(user) {
+		return nil
+	}
+
+	if _, err := models.CleanAvatarURL(sourceURL); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Get(sourceURL)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("avatar: " + sourceURL + ": " + resp.Status)
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, s.GetMaxSize()+1))
+
+	if err != nil {
+		return err
+	}
+
+	return s.Save(dbi, user, data)
+}
+
+func (s *Avatar) removeBlobs(key string) error {
+
+	for _, size := range s.GetSizes() {
+		if err := storage.GetStorage().Delete(avatarBlobKey(key, size)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkAvatarAddress refuses connections to loopback, private,
+// link-local and other non-public addresses.
+func checkAvatarAddress(network, address string, conn syscall.RawConn) error {
+
+	if config.GetConfig().GetBool("avatar.mirror_private_networks") {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return ErrAvatarAddress
+	}
+
+	for _, blocked := range avatarBlockedNetworks {
+		if blocked.Contains(ip) {
+			return ErrAvatarAddress
+		}
+	}
+
+	return nil
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+
+	var networks []*net.IPNet
+
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+
+		if err != nil {
+			panic(err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+func avatarBlobKey(key string, size int) string {
+	return key + "_" + strconv.Itoa(size) + ".jpg"
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/models"
+)
+
+const (
+	// Comments are allowed on any domain
+	DomainModeOpen = "open"
+
+	// Comments are only allowed on approved domains
+	DomainModeWhitelist = "whitelist"
+)
+
+var ErrInvalidURLDomain = errors.New("invalid url, domain not found")
+
+// DomainNotApprovedError tells which domain needs votes
+// before the url can be commented.
+type DomainNotApprovedError struct {
+	Domain     string
+	Registered bool
+	Votes      uint
+}
+
+func (e *DomainNotApprovedError) Error() string {
+	return "domain " + e.Domain + " is not approved"
+}
+
+var domainService *Domain
+var domainServiceOnce sync.Once
+
+type Domain struct{}
+
+func GetDomain() *Domain {
+	domainServiceOnce.Do(func() {
+		domainService = &Domain{}
+	})
+
+	return domainService
+}
+
+// GetMode returns the domain mode in config, open if not set.
+func (s *Domain) GetMode() string {
+	if config.GetConfig().GetString("comment.domain_mode") == DomainModeWhitelist {
+		return DomainModeWhitelist
+	}
+
+	return DomainModeOpen
+}
+
+// CheckURL returns a *DomainNotApprovedError if no approved domain
+// covers the url in whitelist mode.
+func (s *Domain) CheckURL(url string, dbi *gorm.DB) error {
+
+	err, host := models.ExtractDomainFromURL(models.CleanURL(url))
+
+	if err != nil || host == "" {
+		return ErrInvalidURLDomain
+	}
+
+	if s.GetMode() == DomainModeOpen {
+		return nil
+	}
+
+	err, domainModel := models.MatchDomainByURL(url, dbi)
+
+	if err != nil {
+		return err
+	}
+
+	if domainModel == nil {
+		return &DomainNotApprovedError{Domain: models.CleanDomain(host)}
+	}
+
+	if !domainModel.IsActive {
+		return &DomainNotApprovedError{Domain: models.CleanDomain(domainModel.Domain), Registered: true, Votes: domainModel.Votes}
+	}
+
+	return nil
+}
+
+// GetVoteThreshold returns the number of votes to activate a domain automatically,
+// 0 means domains are only activated by admins.
+func (s *Domain) GetVoteThreshold() uint {
+	threshold := config.GetConfig().GetInt("domain.vote_threshold")
+
+	if threshold < 0 {
+		return 0
+	}
+
+	return uint(threshold)
+}
+
+// Create saves a new pending domain with the vote of its creator.
+func (s *Domain) Create(tx *gorm.DB, domain *models.Domain) error {
+
+	domain.Status = models.DomainStatusPending
+	domain.IsActive = false
+
+	if err := tx.Create(domain).Error; err != nil {
+		return err
+	}
+
+	transition := &models.DomainTransition{
+		DomainID: domain.ID,
+		ToStatus: models.DomainStatusPending,
+		Actor:    models.DomainActorUser,
+		UserID:   domain.UserID,
+		Votes:    domain.Votes,
+	}
+
+	if err := tx.Create(transition).Error; err != nil {
+		return err
+	}
+
+	return s.ActivateIfVoted(tx, domain)
+}
+
+// Transit changes the status of the domain and records the transition.
+func (s *Domain) Transit(tx *gorm.DB, domain *models.Domain, status, actor, reason string, userID uint) error {
+
+	err, transition := domain.Transit(status, actor, reason, userID)
+
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Save(domain).Error; err != nil {
+		return err
+	}
+
+	return tx.Create(transition).Error
+}
+
+// ActivateIfVoted activates a pending domain once its votes reach the threshold.
+// Rejected and deactivated domains are left for admins to decide.
+func (s *Domain) ActivateIfVoted(tx *gorm.DB, domain *models.Domain) error {
+
+	threshold := s.GetVoteThreshold()
+
+	if threshold == 0 || domain.Votes < threshold || domain.GetStatus() != models.DomainStatusPending {
+		return nil
+	}
+
+	return s.Transit(tx, domain, models.DomainStatusActive, models.DomainActorSystem, "vote threshold reached", 0)
+}
+
+func (s *Domain) ListTransitions(domain *models.Domain, dbi *gorm.DB) (error, []models.DomainTransition) {
+
+	transitions := make([]models.DomainTransition, 0)
+
+	err := dbi.Where("domain_id = ?", domain.ID).Order("created_at ASC, id ASC").Find(&transitions).Error
+
+	return err, transitions
+}
@@ -17,11 +17,18 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
+	"github.com/jinzhu/gorm"
 	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/util"
 )
 
+var ErrInvalidIntegrationAdjustment = errors.New("the score of the adjustment can't be 0")
+
 var integration *Integration
 var integrationOnce sync.Once
 
@@ -48,3 +55,35 @@ func (s *Integration) GetURLContentCommentVoteScore(like bool) int64 {
 func (s *Integration) GetRegisterScore() int64 {
 	return config.GetConfig().GetInt64("integration.register")
 }
+
+// Adjust changes the integration of the locked user by the score on behalf of an admin,
+// the reason is shown to the user in the integration history.
+func (s *Integration) Adjust(tx *gorm.DB, user *models.User, score int64, reason string) (error, *models.IntegrationHistory) {
+
+	if score == 0 {
+		return ErrInvalidIntegrationAdjustment, nil
+	}
+
+	id, err := util.NewID()
+
+	if err != nil {
+		return err, nil
+	}
+
+	user.IncrementIntegration(score)
+
+	if err := tx.Model(user).UpdateColumn("integration", user.Integration).Error; err != nil {
+		return err, nil
+	}
+
+	integrationHistory := &models.IntegrationHistory{UserID: user.ID, Integration: score}
+	integrationHistory.Description = reason
+	integrationHistory.Data = fmt.Sprintf(`{"event": "ADMIN_ADJUST", "user_id": %d, "adjustment_id": "%s"}`, user.ID, id)
+	integrationHistory.SetUniqueID()
+
+	if err := tx.Create(integrationHistory).Error; err != nil {
+		return err, nil
+	}
+
+	return nil, integrationHistory
+}
@@ -0,0 +1,313 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/cache"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/models"
+)
+
+const loginGuardPrefix = "wormhole_login_"
+
+// LoginCheck tells if a login attempt may go ahead
+type LoginCheck struct {
+	// Set while the username or the ip is locked out or backing off
+	RetryAfter time.Duration
+
+	CaptchaRequired bool
+
+	// Counts of a reserved attempt
+	username     string
+	ip           string
+	userFailures int64
+	ipFailures   int64
+}
+
+func (check *LoginCheck) Allowed() bool {
+	return check.RetryAfter <= 0
+}
+
+// LoginGuard counts failed logins by username and by ip in the cache.
+// Failures of a username are delayed with exponential backoff, a captcha
+// is required after a few failures, and usernames and ips with too many
+// failures are locked out for a while. Usernames which don't exist are
+// counted as well so the responses don't tell them apart.
+//
+// Attempts are counted as failures before the password is verified and
+// reset when it's right, so parallel attempts can't get past the limits.
+type LoginGuard struct {
+	dummyHash     string
+	dummyHashOnce sync.Once
+}
+
+var loginGuard *LoginGuard
+var loginGuardOnce sync.Once
+
+func GetLoginGuard() *LoginGuard {
+	loginGuardOnce.Do(func() {
+		loginGuard = &LoginGuard{}
+	})
+
+	return loginGuard
+}
+
+// Check is called before the captcha is verified, nothing is counted.
+func (s *LoginGuard) Check(username, ip string) (*LoginCheck, error) {
+
+	check := &LoginCheck{}
+
+	if err := s.checkUntil(check, s.key("lock", models.LoginScopeUsername, username), s.key("lock", models.LoginScopeIP, ip), s.key("wait", models.LoginScopeUsername, username)); err != nil {
+		return nil, err
+	}
+
+	userFailures, err := cache.GetCounter(s.key("failures", models.LoginScopeUsername, username))
+
+	if err != nil {
+		return nil, err
+	}
+
+	ipFailures, err := cache.GetCounter(s.key("failures", models.LoginScopeIP, ip))
+
+	if err != nil {
+		return nil, err
+	}
+
+	check.CaptchaRequired = userFailures >= s.getInt("captcha_after", 3) || ipFailures >= s.getInt("ip_captcha_after", 10)
+
+	return check, nil
+}
+
+// Reserve counts the attempt as failed before the password is verified.
+// The attempt may not go ahead if other attempts reached the limits in
+// the meantime. Succeed resets the count when the password is right,
+// Fail locks out the username or the ip when it's wrong.
+// The username is not counted if it's empty.
+func (s *LoginGuard) Reserve(dbi *gorm.DB, username, ip string) (*LoginCheck, error) {
+
+	window := getDuration("login_guard.window", time.Hour)
+
+	check := &LoginCheck{username: username, ip: ip}
+
+	var err error
+
+	if username != "" {
+		if check.userFailures, err = cache.IncrementCounter(s.key("failures", models.LoginScopeUsername, username), window); err != nil {
+			return nil, err
+		}
+	}
+
+	if check.ipFailures, err = cache.IncrementCounter(s.key("failures", models.LoginScopeIP, ip), window); err != nil {
+		return nil, err
+	}
+
+	// Attempts past the limits come from parallel requests,
+	// they lock out unless the failure at the limit already did
+
+	if check.userFailures > s.getInt("lockout_after", 10) {
+		if err := s.lockout(dbi, models.LoginScopeUsername, username, username, ip, check.userFailures-1); err != nil {
+			return nil, err
+		}
+	}
+
+	if check.ipFailures > s.getInt("ip_lockout_after", 100) {
+		if err := s.lockout(dbi, models.LoginScopeIP, ip, username, ip, check.ipFailures-1); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkUntil(check, s.key("lock", models.LoginScopeUsername, username), s.key("lock", models.LoginScopeIP, ip)); err != nil {
+		return nil, err
+	}
+
+	if !check.Allowed() {
+		return check, nil
+	}
+
+	// Backoff doubles with every failure after the free ones,
+	// only the attempt which adds the delay may go ahead
+
+	if exponent := check.userFailures - s.getInt("backoff_after", 3); username != "" && exponent >= 0 {
+
+		base := getDuration("login_guard.backoff_base", time.Second)
+		max := getDuration("login_guard.backoff_max", time.Minute)
+
+		delay := time.Duration(float64(base) * math.Pow(2, float64(exponent)))
+
+		if delay > max || delay <= 0 {
+			delay = max
+		}
+
+		waitKey := s.key("wait", models.LoginScopeUsername, username)
+
+		if err := cache.GetCache().Add(waitKey, time.Now().Add(delay).UnixNano(), delay); err != nil {
+			if err != cache.ErrNotStored {
+				return nil, err
+			}
+
+			if err := s.checkUntil(check, waitKey); err != nil {
+				return nil, err
+			}
+
+			if !check.Allowed() {
+				return check, nil
+			}
+		}
+	}
+
+	return check, nil
+}
+
+// Fail locks out the username or the ip of a reserved attempt
+// with a wrong password once they reach the limit.
+func (s *LoginGuard) Fail(dbi *gorm.DB, check *LoginCheck) error {
+
+	if check.username != "" && check.userFailures >= s.getInt("lockout_after", 10) {
+		if err := s.lockout(dbi, models.LoginScopeUsername, check.username, check.username, check.ip, check.userFailures); err != nil {
+			return err
+		}
+	}
+
+	if check.ipFailures >= s.getInt("ip_lockout_after", 100) {
+		if err := s.lockout(dbi, models.LoginScopeIP, check.ip, check.username, check.ip, check.ipFailures); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Succeed resets the failures of the username and takes back the attempt
+// reserved for the ip, other failures of the ip are kept so an attacker
+// can't reset them with an account of their own.
+func (s *LoginGuard) Succeed(username, ip string) {
+	if username != "" {
+		for _, kind := range []string{"failures", "wait"} {
+			if err := cache.GetCache().Delete(s.key(kind, models.LoginScopeUsername, username)); err != nil && err != cache.ErrCacheMiss {
+				glog.Error(err)
+			}
+		}
+	}
+
+	ipKey := s.key("failures", models.LoginScopeIP, ip)
+
+	count, err := cache.GetCache().Decrement(ipKey, 1)
+
+	if err != nil && err != cache.ErrCacheMiss {
+		glog.Error(err)
+	}
+
+	// Redis decrements missing keys from zero, they are dropped again
+	if err == nil && count <= 0 {
+		if err := cache.GetCache().Delete(ipKey); err != nil && err != cache.ErrCacheMiss {
+			glog.Error(err)
+		}
+	}
+}
+
+// VerifyDummyPassword takes as long as verifying a real password,
+// it's used for unknown usernames so the response time doesn't tell them apart.
+func (s *LoginGuard) VerifyDummyPassword(password string) {
+
+	s.dummyHashOnce.Do(func() {
+		hash, err := models.GetPasswordHasher().Hash("wormhole-dummy-password")
+
+		if err != nil {
+			glog.Error(err)
+		}
+
+		s.dummyHash = hash
+	})
+
+	if s.dummyHash != "" {
+		models.GetPasswordHasher().Verify(password, s.dummyHash)
+	}
+}
+
+func (s *LoginGuard) lockout(dbi *gorm.DB, scope, value, username, ip string, failures int64) error {
+
+	duration := getDuration("login_guard.lockout_duration", time.Minute*15)
+
+	// Parallel failures lock out and record it only once
+	if err := cache.GetCache().Add(s.key("lock", scope, value), time.Now().Add(duration).UnixNano(), duration); err != nil {
+		if err == cache.ErrNotStored {
+			return nil
+		}
+
+		return err
+	}
+
+	// The next lockout needs the full number of failures again
+	if err := cache.GetCache().Delete(s.key("failures", scope, value)); err != nil && err != cache.ErrCacheMiss {
+		return err
+	}
+
+	glog.Warningf("login lockout of %s %q after %d failures, last username %q from %s", scope, value, failures, username, ip)
+
+	lockout := &models.LoginLockout{
+		Scope:       scope,
+		Username:    username,
+		IP:          ip,
+		Failures:    uint(failures),
+		LockedUntil: uint(time.Now().Add(duration).Unix()),
+	}
+
+	return dbi.Create(lockout).Error
+}
+
+// checkUntil sets the retry of the check to the latest time stored in the keys.
+func (s *LoginGuard) checkUntil(check *LoginCheck, keys ...string) error {
+
+	for _, key := range keys {
+		var until int64
+
+		if err := cache.GetCache().Get(key, &until); err != nil {
+			if err == cache.ErrCacheMiss {
+				continue
+			}
+
+			return err
+		}
+
+		if wait := time.Until(time.Unix(0, until)); wait > check.RetryAfter {
+			check.RetryAfter = wait
+		}
+	}
+
+	return nil
+}
+
+func (s *LoginGuard) key(kind, scope, value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return loginGuardPrefix + kind + "_" + scope + "_" + hex.EncodeToString(sum[:])
+}
+
+func (s *LoginGuard) getInt(key string, defaultValue int64) int64 {
+	if c := config.GetConfig(); c.IsSet("login_guard." + key) {
+		return c.GetInt64("login_guard." + key)
+	}
+
+	return defaultValue
+}
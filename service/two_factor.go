@@ -0,0 +1,469 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/cache"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/util"
+)
+
+const twoFactorChallengePrefix = "wormhole_2fa_challenge_"
+const twoFactorFailuresPrefix = "wormhole_2fa_failures_"
+
+// Wrong codes allowed for a user within the failures window, on any path
+// taking a code, challenges issued in the meantime don't allow more
+const maxTwoFactorAttempts = 5
+
+var ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+var ErrTwoFactorNotEnrolled = errors.New("two-factor authentication is not enrolled")
+var ErrInvalidTwoFactorCode = errors.New("invalid code")
+var ErrInvalidChallenge = errors.New("invalid or expired challenge")
+var ErrTooManyTwoFactorAttempts = errors.New("too many wrong codes, try again later")
+
+// Recovery codes are written down by users, so the alphabet avoids upper case
+var recoveryCodeGenerator, _ = util.NewRandomGenerator("abcdefghijklmnopqrstuvwxyz0123456789")
+
+// TwoFactorChallenge is stored in the cache between the password
+// and the code steps of a login
+type TwoFactorChallenge struct {
+	UserID    uint   `json:"user_id"`
+	Remember  bool   `json:"remember"`
+	Device    string `json:"device"`
+	ExpiresAt int64  `json:"expires_at"`
+
+	// Set after the password step, the attempt reserved
+	// in the login guard is taken back after the code
+	PasswordLogin bool `json:"password_login"`
+}
+
+var twoFactorService *TwoFactor
+var twoFactorServiceOnce sync.Once
+
+type TwoFactor struct{}
+
+func GetTwoFactor() *TwoFactor {
+	twoFactorServiceOnce.Do(func() {
+		twoFactorService = &TwoFactor{}
+	})
+
+	return twoFactorService
+}
+
+// Enroll sets a new secret for the user and returns it with the otpauth uri
+// shown as a QR code. The factor is enabled by Confirm.
+func (s *TwoFactor) Enroll(dbi *gorm.DB, user *models.User) (error, string, string) {
+
+	if user.TOTPEnabled {
+		return ErrTwoFactorEnabled, "", ""
+	}
+
+	secret, err := util.NewTOTPSecret()
+
+	if err != nil {
+		return err, "", ""
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastCounter = 0
+
+	if err := dbi.Model(user).UpdateColumns(map[string]interface{}{"totp_secret": secret, "totp_last_counter": 0}).Error; err != nil {
+		return err, "", ""
+	}
+
+	account := user.Email
+
+	if account == "" {
+		account = user.Username
+	}
+
+	if account == "" {
+		account = user.UniqueID
+	}
+
+	return nil, secret, util.TOTPURI(s.getIssuer(), account, secret)
+}
+
+// Confirm enables the factor with a first code from the authenticator
+// and returns the recovery codes, which are only shown once.
+func (s *TwoFactor) Confirm(dbi *gorm.DB, user *models.User, code, ip string) (error, []string) {
+
+	if user.TOTPEnabled {
+		return ErrTwoFactorEnabled, nil
+	}
+
+	if user.TOTPSecret == "" {
+		return ErrTwoFactorNotEnrolled, nil
+	}
+
+	verify := func() error { return s.verifyTOTP(dbi, user, code) }
+
+	if err, _ := s.verifyCounted(dbi, user, ip, verify); err != nil {
+		return err, nil
+	}
+
+	tx := dbi.Begin()
+
+	if err := tx.Model(user).UpdateColumn("totp_enabled", true).Error; err != nil {
+		tx.Rollback()
+		return err, nil
+	}
+
+	err, codes := s.generateRecoveryCodes(tx, user)
+
+	if err != nil {
+		tx.Rollback()
+		return err, nil
+	}
+
+	user.TOTPEnabled = true
+
+	return tx.Commit().Error, codes
+}
+
+// Disable turns the factor off, a current code is required.
+// Wrong codes are counted like the ones of RedeemChallenge.
+func (s *TwoFactor) Disable(dbi *gorm.DB, user *models.User, code, ip string) error {
+
+	verify := func() error { return s.Verify(dbi, user, code) }
+
+	if err, _ := s.verifyCounted(dbi, user, ip, verify); err != nil {
+		return err
+	}
+
+	tx := dbi.Begin()
+
+	if err := tx.Model(user).UpdateColumns(map[string]interface{}{"totp_secret": "", "totp_enabled": false, "totp_last_counter": 0}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserRecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+
+	return tx.Commit().Error
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, a current code is required.
+func (s *TwoFactor) RegenerateRecoveryCodes(dbi *gorm.DB, user *models.User, code, ip string) (error, []string) {
+
+	verify := func() error { return s.Verify(dbi, user, code) }
+
+	if err, _ := s.verifyCounted(dbi, user, ip, verify); err != nil {
+		return err, nil
+	}
+
+	tx := dbi.Begin()
+
+	err, codes := s.generateRecoveryCodes(tx, user)
+
+	if err != nil {
+		tx.Rollback()
+		return err, nil
+	}
+
+	return tx.Commit().Error, codes
+}
+
+// Verify checks a code from the authenticator or an unused recovery code,
+// either can only be used once.
+func (s *TwoFactor) Verify(dbi *gorm.DB, user *models.User, code string) error {
+
+	if !user.TOTPEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if len(code) == util.TOTPDigits {
+		return s.verifyTOTP(dbi, user, code)
+	}
+
+	result := dbi.Model(&models.UserRecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at = 0", user.ID, models.HashRecoveryCode(user.ID, code)).
+		UpdateColumn("used_at", uint(time.Now().Unix()))
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected != 1 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// verifyCounted counts the attempt of the user before verifying the code,
+// so parallel requests can't get past the limit. A wrong code is counted
+// as a failed login from the ip as well, the count of attempts is returned.
+func (s *TwoFactor) verifyCounted(dbi *gorm.DB, user *models.User, ip string, verify func() error) (error, int64) {
+
+	failuresKey := twoFactorFailuresKey(user.ID)
+
+	attempts, err := cache.IncrementCounter(failuresKey, getDuration("two_factor.failures_window", time.Minute*15))
+
+	if err != nil {
+		return err, 0
+	}
+
+	if attempts > maxTwoFactorAttempts {
+		return ErrTooManyTwoFactorAttempts, attempts
+	}
+
+	if err := verify(); err != nil {
+
+		if err != ErrInvalidTwoFactorCode {
+			return err, attempts
+		}
+
+		guard := GetLoginGuard()
+
+		check, err := guard.Reserve(dbi, user.Username, ip)
+
+		if err == nil {
+			err = guard.Fail(dbi, check)
+		}
+
+		if err != nil {
+			glog.Error(err)
+		}
+
+		return ErrInvalidTwoFactorCode, attempts
+	}
+
+	if err := cache.GetCache().Delete(failuresKey); err != nil && err != cache.ErrCacheMiss {
+		glog.Error(err)
+	}
+
+	return nil, attempts
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes.
+func (s *TwoFactor) CountRecoveryCodes(dbi *gorm.DB, user *models.User) (uint, error) {
+	var count uint
+	err := dbi.Model(&models.UserRecoveryCode{}).Where("user_id = ? AND used_at = 0", user.ID).Count(&count).Error
+	return count, err
+}
+
+func (s *TwoFactor) verifyTOTP(dbi *gorm.DB, user *models.User, code string) error {
+
+	ok, counter := util.VerifyTOTP(user.TOTPSecret, code, time.Now(), s.getSkew())
+
+	if !ok || counter <= user.TOTPLastCounter {
+		return ErrInvalidTwoFactorCode
+	}
+
+	// Only one of concurrent requests with the same code gets through
+	result := dbi.Model(&models.User{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+		UpdateColumn("totp_last_counter", counter)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected != 1 {
+		return ErrInvalidTwoFactorCode
+	}
+
+	user.TOTPLastCounter = counter
+
+	return nil
+}
+
+func (s *TwoFactor) generateRecoveryCodes(tx *gorm.DB, user *models.User) (error, []string) {
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserRecoveryCode{}).Error; err != nil {
+		return err, nil
+	}
+
+	count := config.GetConfig().GetInt("two_factor.recovery_codes")
+
+	if count <= 0 {
+		count = 10
+	}
+
+	codes := make([]string, 0, count)
+
+	for i := 0; i < count; i++ {
+		code, err := recoveryCodeGenerator.String(10)
+
+		if err != nil {
+			return err, nil
+		}
+
+		code = code[:5] + "-" + code[5:]
+
+		recoveryCode := &models.UserRecoveryCode{UserID: user.ID, CodeHash: models.HashRecoveryCode(user.ID, code)}
+
+		if err := tx.Create(recoveryCode).Error; err != nil {
+			return err, nil
+		}
+
+		codes = append(codes, code)
+	}
+
+	return nil, codes
+}
+
+// IssueChallenge is called after the password of a user with the factor enabled
+// is verified or the user signed in with a provider, the token of the challenge
+// is redeemed with a code.
+func (s *TwoFactor) IssueChallenge(user *models.User, remember bool, device string, passwordLogin bool) (error, string, time.Duration) {
+
+	token, err := util.RandToken(43)
+
+	if err != nil {
+		return err, "", 0
+	}
+
+	ttl := getDuration("two_factor.challenge_ttl", time.Minute*5)
+
+	challenge := &TwoFactorChallenge{
+		UserID:        user.ID,
+		Remember:      remember,
+		Device:        device,
+		ExpiresAt:     time.Now().Add(ttl).Unix(),
+		PasswordLogin: passwordLogin,
+	}
+
+	if err := s.saveChallenge(token, challenge); err != nil {
+		return err, "", 0
+	}
+
+	return nil, token, ttl
+}
+
+// RedeemChallenge verifies the code for the challenge and returns the challenge
+// with its user. The challenge is removed on success and after too many wrong codes.
+// Wrong codes are counted as failed logins from the ip as well.
+func (s *TwoFactor) RedeemChallenge(dbi *gorm.DB, token, code, ip string) (error, *TwoFactorChallenge, *models.User) {
+
+	key := challengeKey(token)
+
+	var value string
+
+	if err := cache.GetCache().Get(key, &value); err != nil {
+		if err == cache.ErrCacheMiss || err == cache.ErrNotStored {
+			return ErrInvalidChallenge, nil, nil
+		}
+
+		return err, nil, nil
+	}
+
+	challenge := &TwoFactorChallenge{}
+
+	if err := json.Unmarshal([]byte(value), challenge); err != nil {
+		return err, nil, nil
+	}
+
+	if challenge.ExpiresAt <= time.Now().Unix() {
+		return ErrInvalidChallenge, nil, nil
+	}
+
+	user := &models.User{}
+	dbi.Where("id = ?", challenge.UserID).First(user)
+
+	if user.ID == 0 || user.IsDeleted {
+		cache.GetCache().Delete(key)
+		return ErrInvalidChallenge, nil, nil
+	}
+
+	verify := func() error { return s.Verify(dbi, user, code) }
+
+	if err, attempts := s.verifyCounted(dbi, user, ip, verify); err != nil {
+
+		if err == ErrTooManyTwoFactorAttempts || (err == ErrInvalidTwoFactorCode && attempts >= maxTwoFactorAttempts) {
+			cache.GetCache().Delete(key)
+		}
+
+		return err, nil, nil
+	}
+
+	// The challenge is used by whoever deletes it first
+	if err := cache.GetCache().Delete(key); err != nil {
+		if err == cache.ErrCacheMiss {
+			return ErrInvalidChallenge, nil, nil
+		}
+
+		return err, nil, nil
+	}
+
+	if challenge.PasswordLogin {
+		GetLoginGuard().Succeed(user.Username, ip)
+	}
+
+	return nil, challenge, user
+}
+
+func (s *TwoFactor) saveChallenge(token string, challenge *TwoFactorChallenge) error {
+
+	value, err := json.Marshal(challenge)
+
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Until(time.Unix(challenge.ExpiresAt, 0))
+
+	if ttl <= 0 {
+		return ErrInvalidChallenge
+	}
+
+	return cache.GetCache().Set(challengeKey(token), string(value), ttl)
+}
+
+func (s *TwoFactor) getIssuer() string {
+	if issuer := config.GetConfig().GetString("two_factor.issuer"); issuer != "" {
+		return issuer
+	}
+
+	return "Wormhole"
+}
+
+func (s *TwoFactor) getSkew() int64 {
+	if config.GetConfig().IsSet("two_factor.skew") {
+		return config.GetConfig().GetInt64("two_factor.skew")
+	}
+
+	return 1
+}
+
+func challengeKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return twoFactorChallengePrefix + hex.EncodeToString(sum[:])
+}
+
+func twoFactorFailuresKey(userID uint) string {
+	return twoFactorFailuresPrefix + strconv.FormatUint(uint64(userID), 10)
+}
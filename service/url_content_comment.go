@@ -17,12 +17,28 @@
 package service
 
 import (
+	"errors"
 	"sync"
 
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/config"
 	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/models"
+	"github.com/primasio/wormhole/util"
 )
 
+const defaultMaxReplyDepth = 5
+
+const (
+	CommentSortNew           = "new"
+	CommentSortTop           = "top"
+	CommentSortBest          = "best"
+	CommentSortControversial = "controversial"
+	CommentSortHot           = "hot"
+)
+
+var ErrInvalidCommentSort = errors.New("invalid sort, must be one of new, top, best, controversial and hot")
+
 var ucc *URLContentComment
 var uccOnce sync.Once
 
@@ -36,14 +52,21 @@ func GetURLContentComment() *URLContentComment {
 	return ucc
 }
 
-func (s *URLContentComment) ListWithVote(userID uint, urlContent *models.URLContent, page, pageSize, offsetNum int) interface{} {
+// ListWithVote lists the top level comments of the url together with the vote of the user.
+// Comments are paged by offset in the given order, or by cursor if cursor is not nil.
+func (s *URLContentComment) ListWithVote(userID uint, urlContent *models.URLContent, page, pageSize, offsetNum int, order string, cursor *util.Cursor) interface{} {
 	type ScanItem struct {
 		models.BaseModel
 		UniqueID         string
 		Content          string
 		CommentUpVotes   uint
 		CommentDownVotes uint
+		Score            int64
 		IsDeleted        bool
+		IsEdited         bool
+		EditedAt         uint
+		ReplyCount       uint
+		ThreadReplyCount uint
 
 		UserUniqueID         string
 		UserAvatarURL        string
@@ -77,22 +100,38 @@ func (s *URLContentComment) ListWithVote(userID uint, urlContent *models.URLCont
 		Content          string `json:"content"`
 		CommentUpVotes   uint   `json:"comment_up_votes"`
 		CommentDownVotes uint   `json:"comment_down_votes"`
+		Score            int64  `json:"score"`
 		User             User   `json:"user"`
 		Like             string `json:"like"`
 		IsDeleted        bool   `json:"is_deleted"`
+		IsEdited         bool   `json:"is_edited"`
+		EditedAt         uint   `json:"edited_at"`
+		ReplyCount       uint   `json:"reply_count"`
+		ThreadReplyCount uint   `json:"thread_reply_count"`
 	}
 
 	items := make([]ResultItem, 0)
+	keys := make([]util.Cursor, 0)
 
 	dbi := db.GetDb()
 	query := dbi.Table("url_content_comments")
-	rows, _ := query.Order("url_content_comments.created_at DESC").
+
+	if cursor != nil {
+		if sql, args := cursor.Condition("url_content_comments."); sql != "" {
+			query = query.Where(sql, args...)
+		}
+		query = query.Order(cursor.Order("url_content_comments.")).Limit(pageSize + 1)
+	} else {
+		query = query.Order(order).Offset(offsetNum).Limit(pageSize)
+	}
+
+	rows, _ := query.
 		Select("users.integration as user_integration, users.comment_up_votes as user_comment_up_votes, users.comment_down_votes as user_comment_down_votes, users.balance as user_balance,users.created_at as user_created_at, users.updated_at as users_updated_at,users.avatar_url as user_avatar_url, users.nickname as user_nickname, users.unique_id as user_unique_id, url_content_comments.*, url_content_comment_votes.like").
 		Joins("left join users on url_content_comments.user_id = users.id").
 		Joins("left join url_content_comment_votes on url_content_comment_votes.url_content_comment_id = url_content_comments.id and url_content_comment_votes.user_id = ?", userID).
-		Where("url_content_comments.url_content_id = ? AND url_content_comments.is_deleted = 0", urlContent.ID).
-		Offset(offsetNum).
-		Limit(pageSize).Rows()
+		Where("url_content_comments.url_content_id = ? AND url_content_comments.parent_id = 0", urlContent.ID).
+		Where("url_content_comments.is_deleted = 0 OR url_content_comments.reply_count > 0").
+		Rows()
 
 	defer rows.Close()
 
@@ -105,7 +144,12 @@ func (s *URLContentComment) ListWithVote(userID uint, urlContent *models.URLCont
 			Content:          v.Content,
 			CommentUpVotes:   v.CommentUpVotes,
 			CommentDownVotes: v.CommentDownVotes,
+			Score:            v.Score,
 			IsDeleted:        v.IsDeleted,
+			IsEdited:         v.IsEdited,
+			EditedAt:         v.EditedAt,
+			ReplyCount:       v.ReplyCount,
+			ThreadReplyCount: v.ThreadReplyCount,
 			CreatedAt:        v.CreatedAt,
 			UpdatedAt:        v.UpdatedAt,
 			Like:             v.Like,
@@ -116,8 +160,231 @@ func (s *URLContentComment) ListWithVote(userID uint, urlContent *models.URLCont
 			},
 		}
 
+		if v.IsDeleted {
+			// Tombstone of a deleted comment that still has replies
+			result.Content = ""
+			result.User = User{}
+		}
+
 		items = append(items, result)
+		keys = append(keys, util.Cursor{CreatedAt: v.CreatedAt, ID: v.ID})
+	}
+
+	if cursor != nil {
+		return util.CursorPaginate(cursor, uint(pageSize), keys, items)
 	}
 
 	return items
 }
+
+// UserCommentItem is a comment in the comment history of a user
+type UserCommentItem struct {
+	CreatedAt        uint   `json:"created_at"`
+	UpdatedAt        uint   `json:"updated_at"`
+	ID               string `json:"id"`
+	Content          string `json:"content"`
+	ParentID         string `json:"parent_id"`
+	Depth            uint   `json:"depth"`
+	CommentUpVotes   uint   `json:"comment_up_votes"`
+	CommentDownVotes uint   `json:"comment_down_votes"`
+	Score            int64  `json:"score"`
+	ReplyCount       uint   `json:"reply_count"`
+	IsEdited         bool   `json:"is_edited"`
+	EditedAt         uint   `json:"edited_at"`
+	URL              string `json:"url"`
+}
+
+// CountByUser counts the comments of the user that are not deleted.
+func (s *URLContentComment) CountByUser(dbi *gorm.DB, userID uint) (uint, error) {
+	var count uint
+
+	err := dbi.Model(&models.URLContentComment{}).Where("user_id = ? AND is_deleted = ?", userID, false).Count(&count).Error
+
+	return count, err
+}
+
+// ListByUser lists the comments of the user that are not deleted together with their urls, newest first.
+// Comments are paged by offset, or by cursor if cursor is not nil.
+func (s *URLContentComment) ListByUser(dbi *gorm.DB, userID uint, offset, pageSize uint, cursor *util.Cursor) (interface{}, error) {
+	type ScanItem struct {
+		models.URLContentComment
+		URL string
+	}
+
+	query := dbi.Table("url_content_comments").
+		Select("url_content_comments.*, url_contents.url as url").
+		Joins("left join url_contents on url_contents.id = url_content_comments.url_content_id").
+		Where("url_content_comments.user_id = ? AND url_content_comments.is_deleted = ?", userID, false)
+
+	if cursor != nil {
+		if sql, args := cursor.Condition("url_content_comments."); sql != "" {
+			query = query.Where(sql, args...)
+		}
+		query = query.Order(cursor.Order("url_content_comments.")).Limit(pageSize + 1)
+	} else {
+		query = query.Order("url_content_comments.created_at DESC, url_content_comments.id DESC").Offset(offset).Limit(pageSize)
+	}
+
+	rows, err := query.Rows()
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	items := make([]UserCommentItem, 0)
+	keys := make([]util.Cursor, 0)
+
+	for rows.Next() {
+		v := &ScanItem{}
+
+		if err := dbi.ScanRows(rows, v); err != nil {
+			return nil, err
+		}
+
+		items = append(items, UserCommentItem{
+			CreatedAt:        v.CreatedAt,
+			UpdatedAt:        v.UpdatedAt,
+			ID:               v.UniqueID,
+			Content:          v.Content,
+			ParentID:         v.ParentUniqueID,
+			Depth:            v.Depth,
+			CommentUpVotes:   v.CommentUpVotes,
+			CommentDownVotes: v.CommentDownVotes,
+			Score:            v.Score,
+			ReplyCount:       v.ReplyCount,
+			IsEdited:         v.IsEdited,
+			EditedAt:         v.EditedAt,
+			URL:              v.URL,
+		})
+
+		keys = append(keys, util.Cursor{CreatedAt: v.CreatedAt, ID: v.ID})
+	}
+
+	if cursor != nil {
+		return util.CursorPaginate(cursor, pageSize, keys, items), nil
+	}
+
+	return items, nil
+}
+
+// GetSortOrder returns the order clause of the sort mode,
+// prefix is the table name prefix of the columns.
+// Comments are sorted by new if sort is empty.
+func (s *URLContentComment) GetSortOrder(sort, prefix string) (string, error) {
+
+	var column string
+
+	switch sort {
+	case "", CommentSortNew:
+		return prefix + "created_at DESC, " + prefix + "id DESC", nil
+	case CommentSortTop:
+		column = "score"
+	case CommentSortBest:
+		column = "best_score"
+	case CommentSortControversial:
+		column = "controversial_score"
+	case CommentSortHot:
+		column = "hot_score"
+	default:
+		return "", ErrInvalidCommentSort
+	}
+
+	return prefix + column + " DESC, " + prefix + "created_at DESC, " + prefix + "id DESC", nil
+}
+
+func (s *URLContentComment) GetMaxReplyDepth() uint {
+	depth := config.GetConfig().GetInt("comment.max_reply_depth")
+
+	if depth <= 0 {
+		return defaultMaxReplyDepth
+	}
+
+	return uint(depth)
+}
+
+// AddReply updates the reply counters of the parent comment and the thread
+// after a reply to parent is created.
+func (s *URLContentComment) AddReply(tx *gorm.DB, parent *models.URLContentComment) error {
+
+	lockedParent := &models.URLContentComment{}
+	if err := db.ForUpdate(tx).Where("id = ?", parent.ID).First(lockedParent).Error; err != nil {
+		return err
+	}
+
+	lockedParent.ReplyCount++
+
+	if err := tx.Save(lockedParent).Error; err != nil {
+		return err
+	}
+
+	root := &models.URLContentComment{}
+	if err := db.ForUpdate(tx).Where("id = ?", parent.ThreadID()).First(root).Error; err != nil {
+		return err
+	}
+
+	root.ThreadReplyCount++
+
+	return tx.Save(root).Error
+}
+
+// RemoveReply updates the reply counters after the given reply is deleted.
+// A deleted comment stays in the thread as a tombstone as long as it has replies,
+// so removing the last reply of a tombstone removes the tombstone as well.
+func (s *URLContentComment) RemoveReply(tx *gorm.DB, comment *models.URLContentComment) error {
+
+	if !comment.IsReply() {
+		return nil
+	}
+
+	current := comment
+
+	for current.IsReply() && !current.IsTombstone() {
+
+		parent := &models.URLContentComment{}
+		if err := db.ForUpdate(tx).Where("id = ?", current.ParentID).First(parent).Error; err != nil {
+			return err
+		}
+
+		if parent.ReplyCount > 0 {
+			parent.ReplyCount--
+		}
+
+		if err := tx.Save(parent).Error; err != nil {
+			return err
+		}
+
+		if !parent.IsDeleted {
+			break
+		}
+
+		current = parent
+	}
+
+	root := &models.URLContentComment{}
+	if err := db.ForUpdate(tx).Where("id = ?", comment.RootID).First(root).Error; err != nil {
+		return err
+	}
+
+	if root.ThreadReplyCount > 0 {
+		root.ThreadReplyCount--
+	}
+
+	return tx.Save(root).Error
+}
+
+// GetThread loads the reply subtree of the given comment.
+func (s *URLContentComment) GetThread(dbi *gorm.DB, comment *models.URLContentComment) (*models.URLContentCommentThread, error) {
+
+	replies := make([]models.URLContentComment, 0)
+
+	err := dbi.Where("root_id = ? AND depth > ?", comment.ThreadID(), comment.Depth).
+		Order("created_at ASC, id ASC").Preload("User").Find(&replies).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return models.BuildCommentThread(comment, replies), nil
+}
@@ -41,8 +41,14 @@ func GetURLContentCommentVote() *URLContentCommentVote {
 	return uccVote
 }
 
+var ErrCommentDeleted = errors.New("comment is deleted")
+
 func (s *URLContentCommentVote) CreateVote(dbi *gorm.DB, comment *models.URLContentComment, user *models.User, like bool) error {
 
+	if comment.IsDeleted {
+		return ErrCommentDeleted
+	}
+
 	vote := &models.URLContentCommentVote{UserID: user.ID, URLContentCommentID: comment.ID, Like: like}
 	vote.SetUniqueID()
 
@@ -94,6 +100,11 @@ func (s *URLContentCommentVote) CreateVote(dbi *gorm.DB, comment *models.URLCont
 }
 
 func (s *URLContentCommentVote) UpdateVote(dbi *gorm.DB, comment *models.URLContentComment, user *models.User, like bool) error {
+
+	if comment.IsDeleted {
+		return ErrCommentDeleted
+	}
+
 	vote := &models.URLContentCommentVote{UserID: user.ID, URLContentCommentID: comment.ID, Like: like}
 	vote.SetUniqueID()
 
@@ -180,6 +191,11 @@ func (s *URLContentCommentVote) UpdateVote(dbi *gorm.DB, comment *models.URLCont
 }
 
 func (s *URLContentCommentVote) CancelVote(dbi *gorm.DB, comment *models.URLContentComment, user *models.User) error {
+
+	if comment.IsDeleted {
+		return ErrCommentDeleted
+	}
+
 	vote := &models.URLContentCommentVote{UserID: user.ID, URLContentCommentID: comment.ID}
 	vote.SetUniqueID()
 
@@ -253,6 +269,164 @@ func (s *URLContentCommentVote) CancelVote(dbi *gorm.DB, comment *models.URLCont
 	return tx.Commit().Error
 }
 
+// ReverseCommentIntegration takes back the integration the owner of a deleted comment
+// earned through votes on it. A compensating history is recorded instead of
+// removing the vote histories so the points stay traceable.
+func (s *URLContentCommentVote) ReverseCommentIntegration(tx *gorm.DB, comment *models.URLContentComment) error {
+
+	votes := make([]models.URLContentCommentVote, 0)
+
+	if err := tx.Where("url_content_comment_id = ?", comment.ID).Find(&votes).Error; err != nil {
+		return err
+	}
+
+	if len(votes) == 0 {
+		return nil
+	}
+
+	uids := make([]string, 0, len(votes))
+
+	for _, vote := range votes {
+		h := sha1.New()
+		io.WriteString(h, s.GenIntegrationData(vote.UserID, comment.ID, vote.ID))
+		uids = append(uids, fmt.Sprintf("%x", h.Sum(nil)))
+	}
+
+	histories := make([]models.IntegrationHistory, 0)
+
+	if err := tx.Where("unique_id IN (?)", uids).Find(&histories).Error; err != nil {
+		return err
+	}
+
+	var earned int64
+
+	for _, history := range histories {
+		earned += history.Integration
+	}
+
+	if earned == 0 {
+		return nil
+	}
+
+	commentOwner := &models.User{}
+	if err := db.ForUpdate(tx).Where("id = ?", comment.UserID).First(commentOwner).Error; err != nil {
+		return err
+	}
+
+	commentOwner.IncrementIntegration(-earned)
+
+	if err := tx.Save(commentOwner).Error; err != nil {
+		return err
+	}
+
+	integrationHistory := &models.IntegrationHistory{UserID: commentOwner.ID, Integration: -earned}
+	integrationHistory.Description = fmt.Sprintf(`評論已刪除, 收回積分 %d`, earned)
+	integrationHistory.Data = fmt.Sprintf(`{"event": "URL_CONTENT_COMMENT_DELETE", "url_content_comment_id": %d}`, comment.ID)
+	integrationHistory.SetUniqueID()
+
+	return tx.Create(integrationHistory).Error
+}
+
+// MoveVotes gives the comment votes of the source user to the target user when users are merged.
+// A vote on a comment the target has voted too is dropped and its effect is reversed.
+func (s *URLContentCommentVote) MoveVotes(tx *gorm.DB, source, target *models.User) error {
+
+	votes := make([]models.URLContentCommentVote, 0)
+
+	if err := tx.Where("user_id = ?", source.ID).Find(&votes).Error; err != nil {
+		return err
+	}
+
+	for _, vote := range votes {
+
+		history := &models.IntegrationHistory{}
+		uid := s.genIntegrationUniqueID(source.ID, vote.URLContentCommentID, vote.ID)
+
+		if err := tx.Where("unique_id = ?", uid).First(history).Error; err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+
+		moved := &models.URLContentCommentVote{UserID: target.ID, URLContentCommentID: vote.URLContentCommentID}
+		moved.SetUniqueID()
+
+		exists, err := moved.CheckVoteExists(tx, moved.UniqueID)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			if err := s.dropVote(tx, &vote, history); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		vote.UserID = target.ID
+		vote.UniqueID = moved.UniqueID
+
+		if err := tx.Save(&vote).Error; err != nil {
+			return err
+		}
+
+		if history.ID == 0 {
+			continue
+		}
+
+		// The history is found by the voter, see UpdateVote and CancelVote
+		history.Data = s.GenIntegrationData(target.ID, vote.URLContentCommentID, vote.ID)
+		history.SetUniqueID()
+
+		if err := tx.Save(history).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropVote removes the vote as in CancelVote. Integration on deleted comments
+// has been taken back already, so only the vote counts are changed for them.
+func (s *URLContentCommentVote) dropVote(tx *gorm.DB, vote *models.URLContentCommentVote, history *models.IntegrationHistory) error {
+
+	if err := tx.Delete(vote).Error; err != nil {
+		return err
+	}
+
+	contentComment := &models.URLContentComment{}
+	if err := db.ForUpdate(tx).Where("id = ?", vote.URLContentCommentID).First(contentComment).Error; err != nil {
+		return err
+	}
+
+	contentComment.CancelVote(vote.Like)
+	if err := tx.Save(contentComment).Error; err != nil {
+		return err
+	}
+
+	commentOwner := &models.User{}
+	if err := db.ForUpdate(tx).Where("id = ?", contentComment.UserID).First(commentOwner).Error; err != nil {
+		return err
+	}
+
+	commentOwner.CancelCommentVote(vote.Like)
+
+	if !contentComment.IsDeleted && history.ID != 0 {
+		commentOwner.IncrementIntegration(-history.Integration)
+
+		if err := tx.Delete(history).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Save(commentOwner).Error
+}
+
+func (s *URLContentCommentVote) genIntegrationUniqueID(userID, urlContentCommentID, urlContentCommentVoteID uint) string {
+	h := sha1.New()
+	io.WriteString(h, s.GenIntegrationData(userID, urlContentCommentID, urlContentCommentVoteID))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
 func (s *URLContentCommentVote) GenIntegrationDescription(nickname string, score int64, like bool) string {
 	if like {
 		return fmt.Sprintf(`%s 爲你點讚, 獎勵積分 %d`, nickname, score)
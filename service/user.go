@@ -0,0 +1,375 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/jinzhu/gorm"
+	"github.com/primasio/wormhole/cache"
+	"github.com/primasio/wormhole/config"
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/mail"
+	"github.com/primasio/wormhole/models"
+)
+
+const (
+	userTokenVerifyEmail   = "verify_email"
+	userTokenResetPassword = "reset_password"
+)
+
+var ErrEmailExists = errors.New("email is used by another user")
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+var ErrIncorrectPassword = errors.New("incorrect password")
+var ErrMergeSameUser = errors.New("a user can't be merged into itself")
+
+// userTokenData is stored with the tokens sent by email,
+// tokens are only valid as long as the email of the user is unchanged.
+type userTokenData struct {
+	UserID uint   `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+var userService *User
+var userServiceOnce sync.Once
+
+type User struct{}
+
+func GetUser() *User {
+	userServiceOnce.Do(func() {
+		userService = &User{}
+	})
+
+	return userService
+}
+
+// SetEmail validates the email and checks that no other user has it,
+// the email of the user needs to be verified again if it's changed.
+func (s *User) SetEmail(dbi *gorm.DB, user *models.User, email string) error {
+
+	email, err := models.CleanEmail(email)
+
+	if err != nil {
+		return err
+	}
+
+	var count int
+
+	if err := dbi.Model(&models.User{}).Where("email = ? AND id <> ?", email, user.ID).Count(&count).Error; err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrEmailExists
+	}
+
+	user.SetEmail(email)
+
+	return nil
+}
+
+// SendVerification mails a link to verify the email of the user.
+func (s *User) SendVerification(user *models.User) error {
+
+	if user.Email == "" || user.EmailVerified {
+		return nil
+	}
+
+	ttl := getDuration("mail.verify_email_ttl", time.Hour*48)
+
+	err, token := cache.OneTimeTokenIssue(userTokenVerifyEmail, &userTokenData{UserID: user.ID, Email: user.Email}, ttl)
+
+	if err != nil {
+		return err
+	}
+
+	return mail.GetMailer().Send(&mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: "Hi " + user.Nickname + ",\n\n" +
+			"Please verify your email by opening the link below:\n\n" +
+			getMailLink("mail.links.verify_email", token) + "\n\n" +
+			"The link expires in " + ttl.String() + ".\n",
+	})
+}
+
+// VerifyEmail marks the email of the token as verified.
+func (s *User) VerifyEmail(dbi *gorm.DB, token string) (error, *models.User) {
+
+	err, user := s.consumeToken(dbi, userTokenVerifyEmail, token)
+
+	if err != nil {
+		return err, nil
+	}
+
+	user.VerifyEmail()
+
+	if err := dbi.Save(user).Error; err != nil {
+		return err, nil
+	}
+
+	return nil, user
+}
+
+// SendPasswordReset mails a password reset link to the user of the email.
+// Nothing is sent if no user has the email, callers should not tell
+// whether an email is registered.
+func (s *User) SendPasswordReset(dbi *gorm.DB, email string) error {
+
+	email, err := models.CleanEmail(email)
+
+	if err != nil {
+		return err
+	}
+
+	user := &models.User{}
+
+	dbi.Where("email = ?", email).First(user)
+
+	if user.ID == 0 {
+		return nil
+	}
+
+	ttl := getDuration("mail.reset_password_ttl", time.Hour)
+
+	err, token := cache.OneTimeTokenIssue(userTokenResetPassword, &userTokenData{UserID: user.ID, Email: user.Email}, ttl)
+
+	if err != nil {
+		return err
+	}
+
+	return mail.GetMailer().Send(&mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Hi " + user.Nickname + ",\n\n" +
+			"Someone asked to reset the password of your account. Open the link below to choose a new password:\n\n" +
+			getMailLink("mail.links.reset_password", token) + "\n\n" +
+			"The link expires in " + ttl.String() + ". If you didn't ask for it, you can ignore this email.\n",
+	})
+}
+
+// ResetPassword sets the password of the user of the token.
+// Receiving the token proves the ownership of the email,
+// so the email is verified as well.
+func (s *User) ResetPassword(dbi *gorm.DB, token, password string) (error, *models.User) {
+
+	err, user := s.consumeToken(dbi, userTokenResetPassword, token)
+
+	if err != nil {
+		return err, nil
+	}
+
+	if err := user.SetPassword(password); err != nil {
+		return err, nil
+	}
+
+	if !user.EmailVerified {
+		user.VerifyEmail()
+	}
+
+	if err := dbi.Save(user).Error; err != nil {
+		return err, nil
+	}
+
+	return nil, user
+}
+
+// ChangePassword sets the new password if the current one is correct.
+func (s *User) ChangePassword(dbi *gorm.DB, user *models.User, currentPassword, newPassword string) error {
+
+	if !user.VerifyPassword(currentPassword) {
+		return ErrIncorrectPassword
+	}
+
+	if err := user.SetPassword(newPassword); err != nil {
+		return err
+	}
+
+	return dbi.Save(user).Error
+}
+
+// Delete anonymizes the user, unlinks the OAuth accounts and removes the recovery codes,
+// the comments of the user stay with an anonymous author.
+func (s *User) Delete(tx *gorm.DB, user *models.User) error {
+
+	// The account is deleted even if the avatar files can't be removed
+	if err := GetAvatar().Remove(user); err != nil {
+		glog.Error(err)
+	}
+
+	if err := user.Anonymize(); err != nil {
+		return err
+	}
+
+	if err := tx.Save(user).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.UserRecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("user_id = ?", user.ID).Delete(&models.UserOAuth{}).Error
+}
+
+// Merge moves the comments, votes, integration and OAuth accounts of the source user
+// to the target user and deletes the source user. OAuth accounts of providers
+// the target has linked already are removed with the source user.
+func (s *User) Merge(tx *gorm.DB, source, target *models.User) error {
+
+	if source.ID == target.ID {
+		return ErrMergeSameUser
+	}
+
+	if err := GetURLContentCommentVote().MoveVotes(tx, source, target); err != nil {
+		return err
+	}
+
+	// Votes on domains the target has voted too are dropped
+
+	domainVotes := make([]models.DomainVote, 0)
+
+	if err := tx.Where("user_id = ?", source.ID).Find(&domainVotes).Error; err != nil {
+		return err
+	}
+
+	for _, vote := range domainVotes {
+		targetVote := &models.DomainVote{}
+		tx.Where("user_id = ? AND domain_id = ?", target.ID, vote.DomainID).First(targetVote)
+
+		if targetVote.ID == 0 {
+			if err := tx.Model(&vote).UpdateColumn("user_id", target.ID).Error; err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := tx.Delete(&vote).Error; err != nil {
+			return err
+		}
+
+		domain := &models.Domain{}
+		if err := db.ForUpdate(tx).Where("id = ?", vote.DomainID).First(domain).Error; err != nil {
+			return err
+		}
+
+		if domain.Votes > 0 {
+			domain.Votes--
+		}
+
+		if err := tx.Save(domain).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(&models.URLContentComment{}).Where("user_id = ?", source.ID).UpdateColumn("user_id", target.ID).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&models.IntegrationHistory{}).Where("user_id = ?", source.ID).UpdateColumn("user_id", target.ID).Error; err != nil {
+		return err
+	}
+
+	// Counters of the users are reloaded since moving the votes may have changed them
+
+	if err := db.ForUpdate(tx).Where("id = ?", source.ID).First(source).Error; err != nil {
+		return err
+	}
+
+	if err := db.ForUpdate(tx).Where("id = ?", target.ID).First(target).Error; err != nil {
+		return err
+	}
+
+	target.IncrementIntegration(source.Integration)
+	target.CommentUpVotes += source.CommentUpVotes
+	target.CommentDownVotes += source.CommentDownVotes
+
+	source.Integration = 0
+	source.CommentUpVotes = 0
+	source.CommentDownVotes = 0
+
+	if err := tx.Save(target).Error; err != nil {
+		return err
+	}
+
+	linked := make([]uint, 0)
+
+	if err := tx.Model(&models.UserOAuth{}).Where("user_id = ?", target.ID).Pluck("vendor_type", &linked).Error; err != nil {
+		return err
+	}
+
+	moved := tx.Model(&models.UserOAuth{}).Where("user_id = ?", source.ID)
+
+	if len(linked) > 0 {
+		moved = moved.Where("vendor_type NOT IN (?)", linked)
+	}
+
+	if err := moved.UpdateColumn("user_id", target.ID).Error; err != nil {
+		return err
+	}
+
+	return s.Delete(tx, source)
+}
+
+func (s *User) consumeToken(dbi *gorm.DB, purpose, token string) (error, *models.User) {
+
+	data := &userTokenData{}
+
+	if err := cache.OneTimeTokenConsume(purpose, token, data); err != nil {
+		if err == cache.ErrOneTimeTokenNotFound {
+			return ErrInvalidUserToken, nil
+		}
+
+		return err, nil
+	}
+
+	user := &models.User{}
+
+	dbi.Where("id = ?", data.UserID).First(user)
+
+	if user.ID == 0 || user.IsDeleted || user.Email != data.Email {
+		return ErrInvalidUserToken, nil
+	}
+
+	return nil, user
+}
+
+// getMailLink fills the token into the link template in config,
+// the token itself is used if no template is set.
+func getMailLink(key, token string) string {
+
+	link := config.GetConfig().GetString(key)
+
+	if link == "" {
+		return token
+	}
+
+	return strings.Replace(link, "{token}", token, -1)
+}
+
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	if d := config.GetConfig().GetDuration(key); d > 0 {
+		return d
+	}
+
+	return defaultValue
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"errors"
+
+	"github.com/primasio/wormhole/config"
+)
+
+const (
+	StorageTypeLocal = "local"
+	StorageTypeS3    = "s3"
+)
+
+var blobStorage BlobStorage
+
+func InitStorage() error {
+	c := config.GetConfig()
+
+	switch c.GetString("storage.type") {
+	case "", StorageTypeLocal:
+		blobStorage = NewLocalStorage(c.GetString("storage.local.dir"), c.GetString("storage.local.base_url"))
+	case StorageTypeS3:
+		blobStorage = NewS3Storage(&S3Config{
+			Endpoint:  c.GetString("storage.s3.endpoint"),
+			Region:    c.GetString("storage.s3.region"),
+			Bucket:    c.GetString("storage.s3.bucket"),
+			AccessKey: c.GetString("storage.s3.access_key"),
+			SecretKey: c.GetString("storage.s3.secret_key"),
+			ACL:       c.GetString("storage.s3.acl"),
+			BaseURL:   c.GetString("storage.s3.base_url"),
+		})
+	default:
+		return errors.New("unrecognized storage type")
+	}
+
+	return nil
+}
+
+func GetStorage() BlobStorage {
+	return blobStorage
+}
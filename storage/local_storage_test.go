@@ -0,0 +1,53 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/storage"
+)
+
+func TestLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blobs")
+	assert.Equal(t, err, nil)
+	defer os.RemoveAll(dir)
+
+	s := storage.NewLocalStorage(dir, "https://api.wormhole.im/blobs/")
+
+	assert.Equal(t, s.Put("avatars/ID/256.jpg", []byte("image"), "image/jpeg"), nil)
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "avatars", "ID", "256.jpg"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, string(data), "image")
+
+	assert.Equal(t, s.URL("avatars/ID/256.jpg"), "https://api.wormhole.im/blobs/avatars/ID/256.jpg")
+
+	assert.Equal(t, s.Delete("avatars/ID/256.jpg"), nil)
+	assert.Equal(t, s.Delete("avatars/ID/256.jpg"), nil)
+
+	_, err = os.Stat(filepath.Join(dir, "avatars", "ID", "256.jpg"))
+	assert.Equal(t, os.IsNotExist(err), true)
+
+	for _, key := range []string{"", "/etc/passwd", "avatars/../../escape", "avatars//ID"} {
+		assert.Equal(t, s.Put(key, []byte("image"), "image/jpeg"), storage.ErrInvalidKey)
+	}
+}
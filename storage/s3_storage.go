@@ -0,0 +1,221 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps blobs in a bucket of an S3 compatible service.
+// Requests are signed with AWS Signature Version 4 and use path style urls,
+// which are supported by AWS as well as MinIO and most other services.
+type S3Storage struct {
+	endpoint  string
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	acl       string
+	baseURL   string
+
+	client *http.Client
+	now    func() time.Time
+}
+
+type S3Config struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+
+	// Canned ACL of new blobs like "public-read", empty to use the bucket policy
+	ACL string
+
+	// Public url of the bucket, like the url of a CDN in front of it
+	BaseURL string
+}
+
+func NewS3Storage(config *S3Config) *S3Storage {
+	return &S3Storage{
+		endpoint:  strings.TrimSuffix(config.Endpoint, "/"),
+		region:    config.Region,
+		bucket:    config.Bucket,
+		accessKey: config.AccessKey,
+		secretKey: config.SecretKey,
+		acl:       config.ACL,
+		baseURL:   strings.TrimSuffix(config.BaseURL, "/"),
+		client:    &http.Client{Timeout: time.Second * 30},
+		now:       time.Now,
+	}
+}
+
+// Put (see BlobStorage interface)
+func (s *S3Storage) Put(key string, data []byte, contentType string) error {
+
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("PUT", s.objectURL(key), bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	if s.acl != "" {
+		req.Header.Set("X-Amz-Acl", s.acl)
+	}
+
+	return s.do(req, data)
+}
+
+// Delete (see BlobStorage interface)
+func (s *S3Storage) Delete(key string) error {
+
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("DELETE", s.objectURL(key), nil)
+
+	if err != nil {
+		return err
+	}
+
+	return s.do(req, nil)
+}
+
+// URL (see BlobStorage interface)
+func (s *S3Storage) URL(key string) string {
+	if s.baseURL != "" {
+		return s.baseURL + "/" + key
+	}
+
+	return s.objectURL(key)
+}
+
+func (s *S3Storage) objectURL(key string) string {
+
+	segments := strings.Split(key, "/")
+
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return s.endpoint + "/" + url.PathEscape(s.bucket) + "/" + strings.Join(segments, "/")
+}
+
+func (s *S3Storage) do(req *http.Request, payload []byte) error {
+
+	s.sign(req, payload)
+
+	resp, err := s.client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.New("s3: " + req.Method + " " + req.URL.Path + ": " + resp.Status + " " + string(body))
+	}
+
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers to the request.
+func (s *S3Storage) sign(req *http.Request, payload []byte) {
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadSum := sha256.Sum256(payload)
+	payloadHash := hex.EncodeToString(payloadSum[:])
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Headers to sign, the host is always signed
+	headers := map[string]string{"host": req.URL.Host}
+
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	requestSum := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestSum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
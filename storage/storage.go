@@ -0,0 +1,53 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// BlobStorage is the interface of a storage backend of public files
+type BlobStorage interface {
+	// Put stores the data under the key, replacing any existing blob.
+	Put(key string, data []byte, contentType string) error
+
+	// Delete removes the blob. Does nothing if the key is not stored.
+	Delete(key string) error
+
+	// URL returns the public url of the blob.
+	URL(key string) string
+}
+
+// checkKey rejects keys escaping the storage root,
+// keys are slash separated paths like "avatars/ID/256.jpg".
+func checkKey(key string) error {
+
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return ErrInvalidKey
+		}
+	}
+
+	return nil
+}
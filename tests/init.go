@@ -22,10 +22,10 @@ import (
 	"github.com/primasio/wormhole/config"
 	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/db/migrations"
+	"github.com/primasio/wormhole/mail"
+	"github.com/primasio/wormhole/storage"
 	"log"
-	"math/rand"
 	"os"
-	"time"
 )
 
 func InitTestEnv(configPath string) {
@@ -48,10 +48,20 @@ func InitTestEnv(configPath string) {
 		os.Exit(1)
 	}
 
-	if err := migrations.Migrate(); err != nil {
+	// Init Mailer
+	if err := mail.InitMailer(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	// Init Blob Storage
+	if err := storage.InitStorage(); err != nil {
 		log.Println(err)
 		os.Exit(1)
 	}
 
-	rand.Seed(time.Now().UnixNano())
+	if err := migrations.Migrate(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,285 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var ErrInvalidURL = errors.New("invalid url")
+
+// Query parameters that never change the content of a page
+var defaultTrackingParams = []string{
+	"fbclid", "gclid", "dclid", "msclkid", "yclid", "igshid",
+	"mc_cid", "mc_eid", "_ga", "_gl", "_hsenc", "_hsmi",
+	"ref_src", "ref_url", "spm", "share", "sharesource",
+}
+
+// Query parameters with these prefixes are dropped as well
+var defaultTrackingPrefixes = []string{"utm_", "pk_", "hmb_"}
+
+// Sites identifying their pages by some of the query parameters,
+// the other parameters are dropped for them.
+var defaultQueryRules = map[string][]string{
+	"youtube.com":          {"v", "list"},
+	"news.ycombinator.com": {"id"},
+	"facebook.com":         {"id", "story_fbid", "v"},
+	"google.com":           {"q"},
+	"bilibili.com":         {"p"},
+}
+
+// URLCanonicalizer turns the different forms of a URL
+// into the same canonical one.
+type URLCanonicalizer struct {
+	trackingParams   map[string]bool
+	trackingPrefixes []string
+	queryRules       map[string]map[string]bool
+}
+
+func NewURLCanonicalizer() *URLCanonicalizer {
+	c := &URLCanonicalizer{
+		trackingParams:   make(map[string]bool),
+		trackingPrefixes: defaultTrackingPrefixes,
+		queryRules:       make(map[string]map[string]bool),
+	}
+
+	for _, param := range defaultTrackingParams {
+		c.trackingParams[param] = true
+	}
+
+	for domain, params := range defaultQueryRules {
+		c.SetQueryRule(domain, params)
+	}
+
+	return c
+}
+
+// SetQueryRule keeps only the given query parameters in the URLs
+// of the domain and its subdomains. Empty params removes the rule.
+func (c *URLCanonicalizer) SetQueryRule(domain string, params []string) {
+
+	host, err := c.CanonicalizeHost(domain)
+
+	if err != nil {
+		return
+	}
+
+	if len(params) == 0 {
+		delete(c.queryRules, host)
+		return
+	}
+
+	keep := make(map[string]bool)
+
+	for _, param := range params {
+		keep[param] = true
+	}
+
+	c.queryRules[host] = keep
+}
+
+// AddTrackingParams drops the query parameters from all URLs.
+func (c *URLCanonicalizer) AddTrackingParams(params []string) {
+	for _, param := range params {
+		c.trackingParams[param] = true
+	}
+}
+
+// CanonicalizeURL lowercases the scheme and host, converts IDN hosts to punycode,
+// drops user info, default ports, "www.", fragments and tracking parameters,
+// cleans the path and sorts the query parameters.
+// URLs without scheme are treated as http.
+func (c *URLCanonicalizer) CanonicalizeURL(rawURL string) (string, error) {
+
+	rawURL = strings.TrimSpace(rawURL)
+
+	if rawURL == "" {
+		return "", ErrInvalidURL
+	}
+
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+
+	if err != nil || u.Host == "" {
+		return "", ErrInvalidURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.User = nil
+
+	hostname := u.Hostname()
+	port := u.Port()
+
+	host, err := c.CanonicalizeHost(hostname)
+
+	if err != nil {
+		return "", err
+	}
+
+	if port != "" && !isDefaultPort(u.Scheme, port) {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 address
+		host = "[" + host + "]"
+	}
+
+	u.Host = host
+
+	// Clean the path, dot segments, duplicate and trailing slashes are removed
+
+	p := u.Path
+
+	if p == "" {
+		p = "/"
+	}
+
+	p = path.Clean(p)
+
+	if p == "." {
+		p = "/"
+	}
+
+	u.Path = p
+	u.RawPath = ""
+
+	u.RawQuery = c.canonicalizeQuery(u.Hostname(), u.Query())
+
+	// Hash bang fragments are the paths of single page applications
+	if strings.HasPrefix(u.Fragment, "!") {
+		u.Fragment = "!" + strings.TrimRight(u.Fragment[1:], "/")
+	} else {
+		u.Fragment = ""
+	}
+
+	u.RawFragment = ""
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// CanonicalizeHost lowercases the host, converts IDN to punycode
+// and removes the trailing dot and "www." prefix.
+// Scheme, port and path are dropped if host is a URL.
+func (c *URLCanonicalizer) CanonicalizeHost(host string) (string, error) {
+
+	host = strings.TrimSpace(host)
+
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+
+	if i := strings.IndexAny(host, "/?#"); i >= 0 {
+		host = host[:i]
+	}
+
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	host = strings.Trim(host, "[]")
+	host = strings.TrimSuffix(host, ".")
+
+	if host == "" {
+		return "", ErrInvalidURL
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+
+	if err != nil {
+		return "", ErrInvalidURL
+	}
+
+	ascii = strings.TrimPrefix(ascii, "www.")
+
+	return ascii, nil
+}
+
+func (c *URLCanonicalizer) canonicalizeQuery(host string, query url.Values) string {
+
+	keep := c.queryRule(host)
+
+	for param := range query {
+		if keep != nil {
+			if !keep[param] {
+				query.Del(param)
+			}
+			continue
+		}
+
+		if c.isTrackingParam(param) {
+			query.Del(param)
+		}
+	}
+
+	// Encode sorts the parameters by key
+	return query.Encode()
+}
+
+// queryRule finds the rule of the host or its closest parent domain.
+func (c *URLCanonicalizer) queryRule(host string) map[string]bool {
+	for {
+		if keep, ok := c.queryRules[host]; ok {
+			return keep
+		}
+
+		i := strings.Index(host, ".")
+
+		if i < 0 {
+			return nil
+		}
+
+		host = host[i+1:]
+	}
+}
+
+func (c *URLCanonicalizer) isTrackingParam(param string) bool {
+
+	param = strings.ToLower(param)
+
+	if c.trackingParams[param] {
+		return true
+	}
+
+	for _, prefix := range c.trackingPrefixes {
+		if strings.HasPrefix(param, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isDefaultPort(scheme, port string) bool {
+	return (scheme == "http" && port == "80") || (scheme == "https" && port == "443")
+}
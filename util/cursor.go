@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position of an item in a list ordered by created_at DESC, id DESC.
+// Backward cursors fetch the items before the position instead of after it.
+type Cursor struct {
+	CreatedAt uint `json:"c"`
+	ID        uint `json:"i"`
+	Backward  bool `json:"b,omitempty"`
+}
+
+type CursorPagination struct {
+	PerPage    uint        `json:"per_page"`
+	NextCursor string      `json:"next_cursor"`
+	PrevCursor string      `json:"prev_cursor"`
+	Data       interface{} `json:"data"`
+}
+
+// ParseCursor decodes an opaque cursor string,
+// empty string is the cursor of the first page.
+func ParseCursor(cursorStr string) (*Cursor, error) {
+
+	cursor := &Cursor{}
+
+	if cursorStr == "" {
+		return cursor, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(cursorStr)
+
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if cursor.ID == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return cursor, nil
+}
+
+func (cursor *Cursor) Encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func (cursor *Cursor) IsFirst() bool {
+	return cursor.ID == 0
+}
+
+// Condition returns the keyset condition of the cursor,
+// prefix is the table name prefix of the columns like "comments.".
+func (cursor *Cursor) Condition(prefix string) (string, []interface{}) {
+
+	if cursor.IsFirst() {
+		return "", nil
+	}
+
+	op := "<"
+
+	if cursor.Backward {
+		op = ">"
+	}
+
+	sql := prefix + "created_at " + op + " ? OR (" + prefix + "created_at = ? AND " + prefix + "id " + op + " ?)"
+
+	return sql, []interface{}{cursor.CreatedAt, cursor.CreatedAt, cursor.ID}
+}
+
+// Order returns the order clause to fetch items in the direction of the cursor.
+func (cursor *Cursor) Order(prefix string) string {
+
+	if cursor.Backward {
+		return prefix + "created_at ASC, " + prefix + "id ASC"
+	}
+
+	return prefix + "created_at DESC, " + prefix + "id DESC"
+}
+
+// CursorPaginate builds the pagination of the items fetched with the cursor.
+// data is a slice of items in the query order, keys are the positions of them.
+// At most pageSize + 1 items should be fetched,
+// the extra one tells that there are more items in the direction of the cursor.
+func CursorPaginate(cursor *Cursor, pageSize uint, keys []Cursor, data interface{}) *CursorPagination {
+
+	items := reflect.ValueOf(data)
+
+	hasMore := uint(len(keys)) > pageSize
+
+	if hasMore {
+		keys = keys[:pageSize]
+		items = items.Slice(0, int(pageSize))
+	}
+
+	pagination := &CursorPagination{PerPage: pageSize, Data: items.Interface()}
+
+	if len(keys) == 0 {
+		return pagination
+	}
+
+	first := keys[0]
+	last := keys[len(keys)-1]
+
+	if cursor.Backward {
+		// Items are fetched in ascending order
+		// reverse them to be consistent with the forward pages
+
+		swap := reflect.Swapper(pagination.Data)
+
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			swap(i, j)
+		}
+
+		first, last = last, first
+
+		pagination.NextCursor = (&Cursor{CreatedAt: last.CreatedAt, ID: last.ID}).Encode()
+
+		if hasMore {
+			pagination.PrevCursor = (&Cursor{CreatedAt: first.CreatedAt, ID: first.ID, Backward: true}).Encode()
+		}
+
+		return pagination
+	}
+
+	if hasMore {
+		pagination.NextCursor = (&Cursor{CreatedAt: last.CreatedAt, ID: last.ID}).Encode()
+	}
+
+	if !cursor.IsFirst() {
+		pagination.PrevCursor = (&Cursor{CreatedAt: first.CreatedAt, ID: first.ID, Backward: true}).Encode()
+	}
+
+	return pagination
+}
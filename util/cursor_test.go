@@ -0,0 +1,98 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/util"
+)
+
+func TestParseCursor(t *testing.T) {
+	cursor, err := util.ParseCursor("")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, cursor.IsFirst(), true)
+
+	encoded := (&util.Cursor{CreatedAt: 100, ID: 5, Backward: true}).Encode()
+
+	cursor, err = util.ParseCursor(encoded)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, cursor.CreatedAt, uint(100))
+	assert.Equal(t, cursor.ID, uint(5))
+	assert.Equal(t, cursor.Backward, true)
+
+	_, err = util.ParseCursor("not a cursor")
+	assert.Equal(t, err, util.ErrInvalidCursor)
+}
+
+func TestCursorCondition(t *testing.T) {
+	cursor := &util.Cursor{}
+
+	sql, _ := cursor.Condition("")
+	assert.Equal(t, sql, "")
+
+	cursor = &util.Cursor{CreatedAt: 100, ID: 5}
+
+	sql, args := cursor.Condition("c.")
+	assert.Equal(t, sql, "c.created_at < ? OR (c.created_at = ? AND c.id < ?)")
+	assert.Equal(t, len(args), 3)
+	assert.Equal(t, cursor.Order("c."), "c.created_at DESC, c.id DESC")
+
+	cursor.Backward = true
+
+	sql, _ = cursor.Condition("")
+	assert.Equal(t, sql, "created_at > ? OR (created_at = ? AND id > ?)")
+	assert.Equal(t, cursor.Order(""), "created_at ASC, id ASC")
+}
+
+func TestCursorPaginate(t *testing.T) {
+	keys := []util.Cursor{{CreatedAt: 3, ID: 3}, {CreatedAt: 2, ID: 2}, {CreatedAt: 1, ID: 1}}
+	data := []uint{3, 2, 1}
+
+	// First page with more items
+
+	p := util.CursorPaginate(&util.Cursor{}, 2, keys, data)
+	assert.Equal(t, p.Data, []uint{3, 2})
+	assert.Equal(t, p.PrevCursor, "")
+
+	next, err := util.ParseCursor(p.NextCursor)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, next.ID, uint(2))
+	assert.Equal(t, next.Backward, false)
+
+	// Last page
+
+	p = util.CursorPaginate(next, 2, keys[2:], data[2:])
+	assert.Equal(t, p.Data, []uint{1})
+	assert.Equal(t, p.NextCursor, "")
+
+	prev, err := util.ParseCursor(p.PrevCursor)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, prev.ID, uint(1))
+	assert.Equal(t, prev.Backward, true)
+
+	// Backward page is fetched in ascending order
+
+	p = util.CursorPaginate(prev, 2, []util.Cursor{{CreatedAt: 2, ID: 2}, {CreatedAt: 3, ID: 3}}, []uint{2, 3})
+	assert.Equal(t, p.Data, []uint{3, 2})
+	assert.Equal(t, p.PrevCursor, "")
+
+	next, err = util.ParseCursor(p.NextCursor)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, next.ID, uint(2))
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+)
+
+var ErrUnknownPasswordAlgorithm = errors.New("unknown password hash algorithm")
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordHasher hashes passwords into self describing strings
+// prefixed with the algorithm, so hashes of different algorithms can coexist.
+type PasswordHasher interface {
+	Algorithm() string
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+
+	// NeedsRehash tells whether the hash is made with other parameters
+	NeedsRehash(encoded string) bool
+}
+
+// IdentifyPasswordHash returns the algorithm of the encoded hash,
+// empty string if the hash is not made by any PasswordHasher.
+func IdentifyPasswordHash(encoded string) string {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return PasswordAlgorithmArgon2id
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return PasswordAlgorithmBcrypt
+	}
+
+	return ""
+}
+
+// Argon2idHasher encodes hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+type Argon2idHasher struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+func NewArgon2idHasher(memory, time uint32, threads uint8) *Argon2idHasher {
+	return &Argon2idHasher{Memory: memory, Time: time, Threads: threads, SaltLen: 16, KeyLen: 32}
+}
+
+func (h *Argon2idHasher) Algorithm() string {
+	return PasswordAlgorithmArgon2id
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+
+	salt := make([]byte, h.SaltLen)
+
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Time, h.Memory, h.Threads, h.KeyLen)
+
+	encoded := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Time, h.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+
+	return encoded, nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+
+	params, salt, key, err := h.decode(encoded)
+
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+
+	params, _, key, err := h.decode(encoded)
+
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != h.Memory || params.Time != h.Time || params.Threads != h.Threads || uint32(len(key)) != h.KeyLen
+}
+
+func (h *Argon2idHasher) decode(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+
+	parts := strings.Split(encoded, "$")
+
+	if len(parts) != 6 || parts[1] != PasswordAlgorithmArgon2id {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	params := &Argon2idHasher{}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+
+	if err != nil {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	return params, salt, key, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func NewBcryptHasher(cost int) *BcryptHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+
+	return &BcryptHasher{Cost: cost}
+}
+
+func (h *BcryptHasher) Algorithm() string {
+	return PasswordAlgorithmBcrypt
+}
+
+// Hash fails on passwords longer than 72 bytes, which bcrypt can't handle.
+func (h *BcryptHasher) Hash(password string) (string, error) {
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, ErrInvalidPasswordHash
+	}
+
+	return true, nil
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+
+	cost, err := bcrypt.Cost([]byte(encoded))
+
+	return err != nil || cost != h.Cost
+}
+
+// VerifyPasswordHash verifies the password against a hash of any supported algorithm,
+// the hasher is used for its own algorithm so its parameters are respected.
+func VerifyPasswordHash(hasher PasswordHasher, password, encoded string) (bool, error) {
+
+	algorithm := IdentifyPasswordHash(encoded)
+
+	if algorithm == hasher.Algorithm() {
+		return hasher.Verify(password, encoded)
+	}
+
+	switch algorithm {
+	case PasswordAlgorithmArgon2id:
+		return (&Argon2idHasher{}).Verify(password, encoded)
+	case PasswordAlgorithmBcrypt:
+		return (&BcryptHasher{}).Verify(password, encoded)
+	}
+
+	return false, ErrUnknownPasswordAlgorithm
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/util"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	hasher := util.NewArgon2idHasher(1024, 1, 1)
+
+	encoded, err := hasher.Hash("secret")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"), true)
+	assert.Equal(t, util.IdentifyPasswordHash(encoded), util.PasswordAlgorithmArgon2id)
+
+	ok, err := hasher.Verify("secret", encoded)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+
+	ok, _ = hasher.Verify("wrong", encoded)
+	assert.Equal(t, ok, false)
+
+	// Salted hashes differ
+	other, _ := hasher.Hash("secret")
+	assert.Equal(t, other == encoded, false)
+
+	assert.Equal(t, hasher.NeedsRehash(encoded), false)
+	assert.Equal(t, util.NewArgon2idHasher(2048, 1, 1).NeedsRehash(encoded), true)
+
+	_, err = hasher.Verify("secret", "$argon2id$broken")
+	assert.Equal(t, err, util.ErrInvalidPasswordHash)
+}
+
+func TestBcryptHasher(t *testing.T) {
+	hasher := util.NewBcryptHasher(4)
+
+	encoded, err := hasher.Hash("secret")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, util.IdentifyPasswordHash(encoded), util.PasswordAlgorithmBcrypt)
+
+	ok, err := hasher.Verify("secret", encoded)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+
+	ok, _ = hasher.Verify("wrong", encoded)
+	assert.Equal(t, ok, false)
+
+	assert.Equal(t, hasher.NeedsRehash(encoded), false)
+	assert.Equal(t, util.NewBcryptHasher(5).NeedsRehash(encoded), true)
+}
+
+func TestVerifyPasswordHash(t *testing.T) {
+	bcryptHash, _ := util.NewBcryptHasher(4).Hash("secret")
+
+	// Hashes of other algorithms are still verified
+	ok, err := util.VerifyPasswordHash(util.NewArgon2idHasher(1024, 1, 1), "secret", bcryptHash)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, ok, true)
+
+	_, err = util.VerifyPasswordHash(util.NewBcryptHasher(4), "secret", "plain")
+	assert.Equal(t, err, util.ErrUnknownPasswordAlgorithm)
+}
@@ -17,24 +17,184 @@
 package util
 
 import (
-	"math/rand"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
 )
 
-var letterRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890")
-var letterUpper = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890")
+const (
+	AlphabetAlphanumeric = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+	AlphabetUppercase    = "ABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890"
+	AlphabetURLSafe      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_"
 
-func RandString(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letterRunes[rand.Intn(len(letterRunes))]
+	// Crockford's base32, sorts in the same order as the values it encodes
+	AlphabetBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+)
+
+var ErrInvalidAlphabet = errors.New("alphabet must have 2 to 256 characters")
+
+// RandomGenerator generates random strings from crypto/rand,
+// each character is drawn uniformly from the alphabet.
+type RandomGenerator struct {
+	alphabet []byte
+	reader   io.Reader
+}
+
+func NewRandomGenerator(alphabet string) (*RandomGenerator, error) {
+	if len(alphabet) < 2 || len(alphabet) > 256 {
+		return nil, ErrInvalidAlphabet
 	}
-	return string(b)
+
+	return &RandomGenerator{alphabet: []byte(alphabet), reader: rand.Reader}, nil
+}
+
+func (g *RandomGenerator) String(n int) (string, error) {
+
+	size := len(g.alphabet)
+
+	// Bytes at or above the largest multiple of the alphabet size are rejected
+	// so that every character has the same probability
+	limit := 256 - 256%size
+
+	result := make([]byte, 0, n)
+	buf := make([]byte, n+n/4+1)
+
+	for len(result) < n {
+		if _, err := io.ReadFull(g.reader, buf); err != nil {
+			return "", err
+		}
+
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+
+			result = append(result, g.alphabet[int(b)%size])
+
+			if len(result) == n {
+				break
+			}
+		}
+	}
+
+	return string(result), nil
+}
+
+// MustString panics if the system random source fails,
+// which leaves no safe way to continue.
+func (g *RandomGenerator) MustString(n int) string {
+	str, err := g.String(n)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return str
+}
+
+var alphanumericGenerator, _ = NewRandomGenerator(AlphabetAlphanumeric)
+var uppercaseGenerator, _ = NewRandomGenerator(AlphabetUppercase)
+var urlSafeGenerator, _ = NewRandomGenerator(AlphabetURLSafe)
+
+func RandString(n int) string {
+	return alphanumericGenerator.MustString(n)
 }
 
 func RandStringUppercase(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letterUpper[rand.Intn(len(letterUpper))]
+	return uppercaseGenerator.MustString(n)
+}
+
+// RandToken generates URL safe tokens with 6 bits of entropy per character.
+func RandToken(n int) (string, error) {
+	return urlSafeGenerator.String(n)
+}
+
+// IDGenerator generates 128-bit IDs sortable by creation time,
+// 48 bits of milliseconds followed by 80 random bits.
+// IDs of the same millisecond increase the random part by one
+// so they still sort in the order of generation.
+type IDGenerator struct {
+	mutex  sync.Mutex
+	reader io.Reader
+	now    func() time.Time
+
+	lastMs   uint64
+	lastHigh uint16
+	lastLow  uint64
+}
+
+func NewIDGenerator() *IDGenerator {
+	return &IDGenerator{reader: rand.Reader, now: time.Now}
+}
+
+var ErrIDOverflow = errors.New("too many ids generated in the same millisecond")
+
+func (g *IDGenerator) New() (string, error) {
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	ms := uint64(g.now().UnixNano() / int64(time.Millisecond))
+
+	if ms <= g.lastMs {
+		// Same millisecond or clock moved backwards
+		ms = g.lastMs
+
+		g.lastLow++
+
+		if g.lastLow == 0 {
+			g.lastHigh++
+
+			if g.lastHigh == 0 {
+				return "", ErrIDOverflow
+			}
+		}
+	} else {
+		var random [10]byte
+
+		if _, err := io.ReadFull(g.reader, random[:]); err != nil {
+			return "", err
+		}
+
+		g.lastMs = ms
+		g.lastHigh = binary.BigEndian.Uint16(random[:2])
+		g.lastLow = binary.BigEndian.Uint64(random[2:])
+	}
+
+	var id [16]byte
+
+	binary.BigEndian.PutUint16(id[0:2], uint16(ms>>32))
+	binary.BigEndian.PutUint32(id[2:6], uint32(ms))
+	binary.BigEndian.PutUint16(id[6:8], g.lastHigh)
+	binary.BigEndian.PutUint64(id[8:16], g.lastLow)
+
+	return encodeBase32(id), nil
+}
+
+// encodeBase32 encodes the 128 bits into 26 characters,
+// the first character only carries 3 bits.
+func encodeBase32(id [16]byte) string {
+
+	hi := binary.BigEndian.Uint64(id[:8])
+	lo := binary.BigEndian.Uint64(id[8:])
+
+	out := make([]byte, 26)
+
+	for i := 25; i >= 0; i-- {
+		out[i] = AlphabetBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
 	}
-	return string(b)
+
+	return string(out)
+}
+
+var idGenerator = NewIDGenerator()
+
+// NewID returns a 26 characters ID sortable by creation time.
+func NewID() (string, error) {
+	return idGenerator.New()
 }
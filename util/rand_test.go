@@ -0,0 +1,66 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/util"
+)
+
+func TestRandomGenerator(t *testing.T) {
+	g, err := util.NewRandomGenerator("ab")
+	assert.Equal(t, err, nil)
+
+	str, err := g.String(100)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(str), 100)
+	assert.Equal(t, strings.Trim(str, "ab"), "")
+
+	_, err = util.NewRandomGenerator("a")
+	assert.Equal(t, err, util.ErrInvalidAlphabet)
+
+	upper := util.RandStringUppercase(64)
+	assert.Equal(t, strings.Trim(upper, util.AlphabetUppercase), "")
+
+	token, err := util.RandToken(32)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(token), 32)
+	assert.Equal(t, token == util.RandString(32), false)
+}
+
+func TestNewID(t *testing.T) {
+	ids := make([]string, 1000)
+	seen := make(map[string]bool)
+
+	for i := range ids {
+		id, err := util.NewID()
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(id), 26)
+		assert.Equal(t, strings.Trim(id, util.AlphabetBase32), "")
+
+		ids[i] = id
+		seen[id] = true
+	}
+
+	// Unique and sorted in the order of generation
+	assert.Equal(t, len(seen), len(ids))
+	assert.Equal(t, sort.StringsAreSorted(ids), true)
+}
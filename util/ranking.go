@@ -0,0 +1,88 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import "math"
+
+// z-score of the 80% confidence level used by the Wilson score
+const wilsonZ = 1.281551565545
+
+// Timestamps of hot scores are counted from this point,
+// a score difference of 1 is worth 12.5 hours
+const hotEpoch = 1134028003
+const hotPeriod = 45000
+
+// NetScore is the number of up votes minus down votes.
+func NetScore(ups, downs uint) int64 {
+	return int64(ups) - int64(downs)
+}
+
+// WilsonScore is the lower bound of the Wilson score confidence interval
+// for the ratio of up votes, so items with few votes are not overrated.
+func WilsonScore(ups, downs uint) float64 {
+	n := float64(ups + downs)
+
+	if n == 0 {
+		return 0
+	}
+
+	z := wilsonZ
+	p := float64(ups) / n
+
+	left := p + z*z/(2*n)
+	right := z * math.Sqrt(p*(1-p)/n+z*z/(4*n*n))
+	under := 1 + z*z/n
+
+	return (left - right) / under
+}
+
+// ControversialScore is high when there are many votes evenly split
+// between up and down.
+func ControversialScore(ups, downs uint) float64 {
+	if ups == 0 || downs == 0 {
+		return 0
+	}
+
+	magnitude := float64(ups + downs)
+	balance := float64(downs) / float64(ups)
+
+	if ups < downs {
+		balance = float64(ups) / float64(downs)
+	}
+
+	return math.Pow(magnitude, balance)
+}
+
+// HotScore combines the net score with the creation time so newer items
+// need less votes to rank as high as older ones.
+func HotScore(ups, downs uint, createdAt uint) float64 {
+	score := NetScore(ups, downs)
+
+	order := math.Log10(math.Max(math.Abs(float64(score)), 1))
+
+	var sign float64
+
+	if score > 0 {
+		sign = 1
+	} else if score < 0 {
+		sign = -1
+	}
+
+	seconds := float64(createdAt) - hotEpoch
+
+	return sign*order + seconds/hotPeriod
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"testing"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/util"
+)
+
+func TestNetScore(t *testing.T) {
+	assert.Equal(t, util.NetScore(3, 5), int64(-2))
+	assert.Equal(t, util.NetScore(5, 3), int64(2))
+}
+
+func TestWilsonScore(t *testing.T) {
+	assert.Equal(t, util.WilsonScore(0, 0), float64(0))
+
+	// More votes with the same ratio are more trustworthy
+	assert.Equal(t, util.WilsonScore(100, 10) > util.WilsonScore(10, 1), true)
+
+	// A single up vote is not better than a lot of mostly up votes
+	assert.Equal(t, util.WilsonScore(1, 0) < util.WilsonScore(90, 10), true)
+}
+
+func TestControversialScore(t *testing.T) {
+	assert.Equal(t, util.ControversialScore(10, 0), float64(0))
+	assert.Equal(t, util.ControversialScore(50, 50) > util.ControversialScore(90, 10), true)
+	assert.Equal(t, util.ControversialScore(10, 90), util.ControversialScore(90, 10))
+}
+
+func TestHotScore(t *testing.T) {
+	now := uint(1540000000)
+
+	// Newer items rank higher with the same votes
+	assert.Equal(t, util.HotScore(10, 0, now+3600) > util.HotScore(10, 0, now), true)
+
+	// More votes rank higher at the same time
+	assert.Equal(t, util.HotScore(100, 0, now) > util.HotScore(10, 0, now), true)
+
+	// Negative score ranks lower
+	assert.Equal(t, util.HotScore(0, 10, now) < util.HotScore(0, 0, now), true)
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magiconair/properties/assert"
+	"github.com/primasio/wormhole/util"
+)
+
+// Test vectors of RFC 6238 with SHA1, truncated to 6 digits
+func TestVerifyTOTP(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, code := range vectors {
+		ok, counter := util.VerifyTOTP(secret, code, time.Unix(unix, 0), 0)
+		assert.Equal(t, ok, true)
+		assert.Equal(t, counter, unix/util.TOTPPeriod)
+	}
+
+	// Codes of the neighbouring steps are accepted with skew
+
+	ok, _ := util.VerifyTOTP(secret, "287082", time.Unix(59+util.TOTPPeriod, 0), 0)
+	assert.Equal(t, ok, false)
+
+	ok, counter := util.VerifyTOTP(secret, "287082", time.Unix(59+util.TOTPPeriod, 0), 1)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, counter, int64(1))
+
+	ok, _ = util.VerifyTOTP(secret, "28708", time.Unix(59, 0), 1)
+	assert.Equal(t, ok, false)
+
+	ok, _ = util.VerifyTOTP("not base32!", "287082", time.Unix(59, 0), 1)
+	assert.Equal(t, ok, false)
+}
+
+func TestTOTPURI(t *testing.T) {
+	secret, err := util.NewTOTPSecret()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(secret), 32)
+
+	uri := util.TOTPURI("Wormhole", "alice@example.com", secret)
+	assert.Equal(t, strings.HasPrefix(uri, "otpauth://totp/Wormhole:alice@example.com?"), true)
+	assert.Equal(t, strings.Contains(uri, "secret="+secret), true)
+	assert.Equal(t, strings.Contains(uri, "issuer=Wormhole"), true)
+}
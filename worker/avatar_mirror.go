@@ -0,0 +1,73 @@
+/*
+ * Copyright 2018 Primas Lab Foundation
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package worker
+
+import (
+	"sync"
+
+	"github.com/golang/glog"
+
+	"github.com/primasio/wormhole/db"
+	"github.com/primasio/wormhole/service"
+)
+
+// Number of avatars waiting to be mirrored,
+// avatars are skipped when the queue is full
+const avatarMirrorQueueSize = 1000
+
+type avatarMirrorJob struct {
+	UserID    uint
+	SourceURL string
+}
+
+// AvatarMirrorWorker downloads the avatars hot-linked from other sites
+// like OAuth providers into the blob storage.
+type AvatarMirrorWorker struct {
+	jobs chan avatarMirrorJob
+}
+
+var avatarMirrorWorker *AvatarMirrorWorker
+var avatarMirrorWorkerOnce sync.Once
+
+func GetAvatarMirrorWorker() *AvatarMirrorWorker {
+	avatarMirrorWorkerOnce.Do(func() {
+		avatarMirrorWorker = &AvatarMirrorWorker{jobs: make(chan avatarMirrorJob, avatarMirrorQueueSize)}
+	})
+
+	return avatarMirrorWorker
+}
+
+// Enqueue schedules the avatar of the user to be mirrored,
+// false is returned if the queue is full.
+func (w *AvatarMirrorWorker) Enqueue(userID uint, sourceURL string) bool {
+	select {
+	case w.jobs <- avatarMirrorJob{UserID: userID, SourceURL: sourceURL}:
+		return true
+	default:
+		glog.Warningf("avatar mirror queue is full, skipped user %d", userID)
+		return false
+	}
+}
+
+func (w *AvatarMirrorWorker) Run() {
+	for job := range w.jobs {
+		if err := service.GetAvatar().Mirror(db.GetDb(), job.UserID, job.SourceURL); err != nil {
+			// The avatar stays hot-linked and is mirrored again on the next login
+			glog.Error(err)
+		}
+	}
+}
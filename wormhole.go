@@ -18,9 +18,7 @@ package main
 
 import (
 	"flag"
-	"math/rand"
 	"os"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang/glog"
@@ -29,14 +27,13 @@ import (
 	"github.com/primasio/wormhole/db"
 	"github.com/primasio/wormhole/db/migrations"
 	"github.com/primasio/wormhole/http/server"
+	"github.com/primasio/wormhole/mail"
+	"github.com/primasio/wormhole/storage"
 	"github.com/primasio/wormhole/worker"
 )
 
 func main() {
 
-	// Init random seed
-	rand.Seed(time.Now().UnixNano())
-
 	migrate := flag.Bool("migrate", false, "whether to run the database migration")
 
 	flag.Parse()
@@ -92,9 +89,23 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Init Mailer
+	if err := mail.InitMailer(); err != nil {
+		glog.Error(err)
+		os.Exit(1)
+	}
+
+	// Init Blob Storage
+	if err := storage.InitStorage(); err != nil {
+		glog.Error(err)
+		os.Exit(1)
+	}
+
 	w := worker.NewRegisterIntegrationWorker()
 	go w.Run()
 
+	go worker.GetAvatarMirrorWorker().Run()
+
 	// Start HTTP server
 	server.Init()
 }